@@ -2,6 +2,7 @@ package slogdedup
 
 import (
 	"log/slog"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -163,3 +164,312 @@ func TestAppendHandler_CaseInsensitiveKeepIfBuiltinConflict(t *testing.T) {
 	// t.Error(jStr)
 	// t.Error(tester.String())
 }
+
+func TestAppendHandler_AppendedGroupFormat(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		format   AppendedGroupFormat
+		expected string
+	}{
+		{
+			format:   AppendedGroupMap,
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"appended group","group1":[{"arg1":"val1","arg2":"val2"},{"arg1":"val3","sub":{"arg2":4}}]}`,
+		},
+		{
+			format:   AppendedGroupAttrs,
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"appended group","group1":[{"arg1":"val1","arg2":"val2"},{"sub":{"arg2":4},"arg1":"val3"}]}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		log := slog.New(NewAppendHandler(tester, &AppendHandlerOptions{AppendedGroupFormat: testCase.format, CommonOptions: CommonOptions{OrderMode: OrderInsertion}}))
+		log.Info("appended group", slog.Group("group1", "arg1", "val1", "arg2", "val2"), slog.Group("group1", slog.Group("sub", "arg2", 4), "arg1", "val3"))
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != testCase.expected {
+			t.Errorf("%d Expected:\n%s\nGot:\n%s", testCase.format, testCase.expected, jStr)
+		}
+
+		// Check the type of the rendered groups
+		tester.Record.Attrs(func(a slog.Attr) bool {
+			for _, v := range a.Value.Any().([]any) {
+				_, isMap := v.(map[string]any)
+				group, isGroup := v.(AppendedGroup)
+				if isMap != (testCase.format == AppendedGroupMap) || isGroup != (testCase.format == AppendedGroupAttrs) {
+					t.Errorf("%d Unexpected type %T", testCase.format, v)
+				}
+				if isGroup && len(group) != 2 {
+					t.Errorf("Expected AppendedGroup to keep its attributes; Got: %v", group)
+				}
+			}
+			return true
+		})
+
+		checkRecordForDuplicates(t, tester.Record)
+	}
+}
+
+func TestAppendHandler_JoinKeys(t *testing.T) {
+	t.Parallel()
+
+	tester := &testHandler{}
+	h := NewAppendHandler(tester, &AppendHandlerOptions{JoinKeys: map[string]string{"tags": ",", "path": "/"}})
+
+	log := slog.New(h).With("tags", "a", "ids", 1)
+	log.Info("join keys", "tags", "b", "ids", 2, "tags", 3, slog.Group("group1", "path", "usr", "path", "local"), slog.Group("path", "arg1", "val1"))
+
+	jBytes, err := tester.MarshalJSON()
+	if err != nil {
+		t.Errorf("Unable to marshal json: %v", err)
+	}
+	jStr := strings.TrimSpace(string(jBytes))
+
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"join keys","group1":{"path":"usr/local"},"ids":[1,2],"path":{"arg1":"val1"},"tags":["a,b",3]}`
+	if jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+
+	checkRecordForDuplicates(t, tester.Record)
+}
+
+func TestAppendHandler_JoinKeysMixedKinds(t *testing.T) {
+	t.Parallel()
+
+	tester := &testHandler{}
+	h := NewAppendHandler(tester, &AppendHandlerOptions{JoinKeys: map[string]string{"ids": ",", "tags": ","}})
+
+	log := slog.New(h).With("ids", 1, "tags", true)
+	log.Info("join keys", "ids", "2", "ids", 3, "tags", "a", "tags", "b")
+
+	jBytes, err := tester.MarshalJSON()
+	if err != nil {
+		t.Errorf("Unable to marshal json: %v", err)
+	}
+	jStr := strings.TrimSpace(string(jBytes))
+
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"join keys","ids":[1,"2",3],"tags":[true,"a","b"]}`
+	if jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+
+	checkRecordForDuplicates(t, tester.Record)
+}
+
+func TestAppendHandler_AppendedOrder(t *testing.T) {
+	t.Parallel()
+
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"appended order","arg1":["with1","with2","with3","main1","main2"],"group1":["with1",{"arg1":"main1"}]}`
+	expected2 := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"appended order","arg1":["with1","with2","with3"],"group1":["with1",{"arg1":["with3","main1"]}]}`
+
+	for _, opts := range []*AppendHandlerOptions{
+		{},
+		{CommonOptions: CommonOptions{OrderMode: OrderInsertion}},
+		{CommonOptions: CommonOptions{OrderMode: OrderInsertionStableDedup}},
+		{CommonOptions: CommonOptions{CacheWithAttrs: true}},
+	} {
+		tester := &testHandler{}
+		log := slog.New(NewAppendHandler(tester, opts)).
+			With("arg1", "with1", "group1", "with1").
+			With("arg1", "with2").
+			With(slog.Group("", "arg1", "with3"))
+		log2 := log.WithGroup("group1").With("arg1", "with3")
+
+		// Log repeatedly, to make sure the order does not change between records
+		for i := 0; i < 3; i++ {
+			log.Info("appended order", "arg1", "main1", "arg1", "main2", slog.Group("group1", "arg1", "main1"))
+
+			jBytes, err := tester.MarshalJSON()
+			if err != nil {
+				t.Errorf("Unable to marshal json: %v", err)
+				continue
+			}
+			jStr := strings.TrimSpace(string(jBytes))
+
+			if jStr != expected {
+				t.Errorf("%+v Expected:\n%s\nGot:\n%s", opts, expected, jStr)
+			}
+			checkRecordForDuplicates(t, tester.Record)
+
+			log2.Info("appended order", "arg1", "main1")
+			jBytes, err = tester.MarshalJSON()
+			if err != nil {
+				t.Errorf("Unable to marshal json: %v", err)
+				continue
+			}
+			jStr = strings.TrimSpace(string(jBytes))
+
+			if jStr != expected2 {
+				t.Errorf("%+v Expected:\n%s\nGot:\n%s", opts, expected2, jStr)
+			}
+			checkRecordForDuplicates(t, tester.Record)
+		}
+	}
+}
+
+func TestAppendHandler_AppendedGroupElements(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		opts     *AppendHandlerOptions
+		expected string
+	}{
+		{
+			name:     "separate elements",
+			opts:     &AppendHandlerOptions{},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"grouped elements","group1":["str",{"a":1},{"a":2,"b":3}]}`,
+		},
+		{
+			name:     "unified elements",
+			opts:     &AppendHandlerOptions{CommonOptions: CommonOptions{UnifyGroupSources: true}},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"grouped elements","group1":["str",{"a":[1,2],"b":3}]}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		log := slog.New(NewAppendHandler(tester, testCase.opts)).With("group1", "str")
+		log.Info("grouped elements", slog.Group("group1", "a", 1), slog.Group("group1", "a", 2, "b", 3))
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != testCase.expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, testCase.expected, jStr)
+		}
+	}
+}
+
+func TestAppendHandler_SortAppendedGroupSlices(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		opts     *AppendHandlerOptions
+		expected string
+	}{
+		{
+			name:     "insertion order",
+			opts:     &AppendHandlerOptions{},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"sorted slices","group1":["str",{"a":["z",10,{"c":[3,1]},2],"b":1}]}`,
+		},
+		{
+			name:     "sorted map",
+			opts:     &AppendHandlerOptions{SortAppendedGroupSlices: true},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"sorted slices","group1":["str",{"a":[2,10,"z",{"c":[1,3]}],"b":1}]}`,
+		},
+		{
+			name:     "sorted attrs",
+			opts:     &AppendHandlerOptions{SortAppendedGroupSlices: true, AppendedGroupFormat: AppendedGroupAttrs},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"sorted slices","group1":["str",{"a":[2,10,"z",{"c":[1,3]}],"b":1}]}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		var first string
+		for i := 0; i < 20; i++ {
+			tester := &testHandler{}
+			log := slog.New(NewAppendHandler(tester, testCase.opts)).With("group1", "str")
+			log.Info("sorted slices", slog.Group("group1", "a", "z", "b", 1, "a", 10, slog.Group("a", "c", 3, "c", 1), "a", 2))
+
+			jBytes, err := tester.MarshalJSON()
+			if err != nil {
+				t.Errorf("Unable to marshal json: %v", err)
+				continue
+			}
+			jStr := strings.TrimSpace(string(jBytes))
+
+			if i == 0 {
+				first = jStr
+			} else if jStr != first {
+				t.Errorf("%s Expected identical output across runs:\n%s\nGot:\n%s", testCase.name, first, jStr)
+			}
+		}
+
+		if first != testCase.expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, testCase.expected, first)
+		}
+	}
+}
+
+func TestAppendHandler_SortAppendedGroupSlicesUserValues(t *testing.T) {
+	t.Parallel()
+
+	// Values that were logged as slices and maps belong to the user, so must
+	// not be sorted or modified, even within appended groups
+	userSlice := []any{3, 1, 2}
+	userMap := map[string]any{"s": []any{3, 1, 2}}
+
+	tester := &testHandler{}
+	log := slog.New(NewAppendHandler(tester, &AppendHandlerOptions{SortAppendedGroupSlices: true})).With("group1", "str")
+	log.Info("user values", "top", userSlice, slog.Group("group1", "slice", userSlice, "map", userMap, "a", 2, "a", 1))
+
+	jBytes, err := tester.MarshalJSON()
+	if err != nil {
+		t.Fatalf("Unable to marshal json: %v", err)
+	}
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"user values","group1":["str",{"a":[1,2],"map":{"s":[3,1,2]},"slice":[3,1,2]}],"top":[3,1,2]}`
+	if jStr := strings.TrimSpace(string(jBytes)); jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+
+	if !reflect.DeepEqual(userSlice, []any{3, 1, 2}) || !reflect.DeepEqual(userMap, map[string]any{"s": []any{3, 1, 2}}) {
+		t.Errorf("Expected the user's values to be unchanged, got: %v %v", userSlice, userMap)
+	}
+}
+
+func TestAppendHandler_OrderMode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		mode     OrderMode
+		expected string
+	}{
+		{
+			name:     "sorted",
+			mode:     OrderSorted,
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"order","arg1":"main1arg1","arg2":["with1arg2","main1arg2"],"group1":{"alpha":6,"beta":5},"zed":[1,2]}`,
+		},
+		{
+			name:     "insertion",
+			mode:     OrderInsertion,
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"order","arg1":"main1arg1","zed":[1,2],"group1":{"beta":5,"alpha":6},"arg2":["with1arg2","main1arg2"]}`,
+		},
+		{
+			name:     "insertion stable dedup",
+			mode:     OrderInsertionStableDedup,
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"order","zed":[1,2],"arg2":["with1arg2","main1arg2"],"arg1":"main1arg1","group1":{"beta":5,"alpha":6}}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		log := slog.New(NewAppendHandler(tester, &AppendHandlerOptions{CommonOptions: CommonOptions{OrderMode: testCase.mode}})).With("zed", 1, "arg2", "with1arg2")
+		log.Info("order", "arg1", "main1arg1", "zed", 2, slog.Group("group1", "beta", 5, "alpha", 6), "arg2", "main1arg2")
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != testCase.expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, testCase.expected, jStr)
+		}
+
+		checkRecordForDuplicates(t, tester.Record)
+	}
+}
@@ -0,0 +1,162 @@
+package slogdedup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// ErrDuplicateKey is returned by the AssertNoDuplicatesHandler's Handle when
+// a record has a duplicate key.
+var ErrDuplicateKey = errors.New("slogdedup: duplicate key")
+
+// AssertNoDuplicatesHandlerOptions are options for an AssertNoDuplicatesHandler
+type AssertNoDuplicatesHandlerOptions struct {
+	// Panic, if true, panics with the error instead of returning it.
+	Panic bool
+}
+
+// AssertNoDuplicatesHandler is a slog.Handler middleware that checks that
+// records have no duplicate keys, at the root level or within any group,
+// before passing them off to the next handler. Root level keys that conflict
+// with the builtin keys (ie: time, level, msg, and source) are also
+// duplicates. It is a safety net to put after one of the deduplicating
+// handlers, to catch misconfigured options such as a ResolveKey function that
+// lets duplicates through.
+// Records with duplicates are still passed to the next handler, so that they
+// are not lost, and then an error wrapping ErrDuplicateKey is returned with
+// the path of the first duplicate key, such as "group1.arg1".
+type AssertNoDuplicatesHandler struct {
+	next  slog.Handler
+	goa   *groupOrAttrs
+	panic bool
+}
+
+var _ slog.Handler = &AssertNoDuplicatesHandler{} // Assert conformance with interface
+
+// NewAssertNoDuplicatesMiddleware creates an AssertNoDuplicatesHandler slog.Handler middleware
+// that conforms to [github.com/samber/slog-multi.Middleware] interface.
+// It can be used with slogmulti methods such as Pipe to easily setup a pipeline of slog handlers:
+//
+//	slog.SetDefault(slog.New(slogmulti.
+//		Pipe(slogdedup.NewOverwriteMiddleware(&slogdedup.OverwriteHandlerOptions{})).
+//		Pipe(slogdedup.NewAssertNoDuplicatesMiddleware(&slogdedup.AssertNoDuplicatesHandlerOptions{})).
+//		Handler(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{})),
+//	))
+func NewAssertNoDuplicatesMiddleware(options *AssertNoDuplicatesHandlerOptions) func(slog.Handler) slog.Handler {
+	return func(next slog.Handler) slog.Handler {
+		return NewAssertNoDuplicatesHandler(
+			next,
+			options,
+		)
+	}
+}
+
+// NewAssertNoDuplicatesHandler creates an AssertNoDuplicatesHandler slog.Handler middleware
+// that checks that records have no duplicate keys, before passing them off to
+// the next handler.
+// If opts is nil, the default options are used.
+// If next is nil, all records are discarded instead of panicking.
+func NewAssertNoDuplicatesHandler(next slog.Handler, opts *AssertNoDuplicatesHandlerOptions) *AssertNoDuplicatesHandler {
+	if opts == nil {
+		opts = &AssertNoDuplicatesHandlerOptions{}
+	}
+	return &AssertNoDuplicatesHandler{
+		next:  nextOrDiscard(next),
+		panic: opts.Panic,
+	}
+}
+
+// Enabled reports whether the next handler handles records at the given level.
+// The handler ignores records whose level is lower.
+func (h *AssertNoDuplicatesHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle passes the record to the next handler, then returns an error if the
+// record, along with this handler's groups and attributes, has a duplicate key.
+func (h *AssertNoDuplicatesHandler) Handle(ctx context.Context, r slog.Record) error {
+	finalAttrs := make([]slog.Attr, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		finalAttrs = append(finalAttrs, a)
+		return true
+	})
+
+	// Nest the record's attributes inside of the groups, with the with-attributes before them
+	goas := collectGroupOrAttrs(h.goa)
+	for i := len(goas) - 1; i >= 0; i-- {
+		if goas[i].group != "" {
+			finalAttrs = []slog.Attr{{Key: goas[i].group, Value: slog.GroupValue(finalAttrs...)}}
+			continue
+		}
+		finalAttrs = append(slices.Clip(goas[i].attrs), finalAttrs...)
+	}
+
+	// The builtin keys are already at the root level
+	seen := map[string]struct{}{slog.TimeKey: {}, slog.LevelKey: {}, slog.MessageKey: {}, slog.SourceKey: {}}
+	dupErr := findDuplicate(seen, nil, finalAttrs)
+
+	err := h.next.Handle(ctx, r)
+	if dupErr == nil {
+		return err
+	}
+	if h.panic {
+		panic(dupErr)
+	}
+	return errors.Join(dupErr, err)
+}
+
+// WithGroup returns a new AssertNoDuplicatesHandler whose next handler has the group.
+func (h *AssertNoDuplicatesHandler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	h2.goa = h2.goa.WithGroup(name)
+	h2.next = h2.next.WithGroup(name)
+	return &h2
+}
+
+// WithAttrs returns a new AssertNoDuplicatesHandler whose next handler has the attributes.
+func (h *AssertNoDuplicatesHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.goa = h2.goa.WithAttrs(attrs)
+	h2.next = h2.next.WithAttrs(attrs)
+	return &h2
+}
+
+// findDuplicate returns an error for the first key of the attributes that is
+// already seen, recursively checking each group with its own seen keys.
+// Inline groups are checked as part of their parent, and empty groups are
+// ignored because they are not output.
+func findDuplicate(seen map[string]struct{}, groups []string, attrs []slog.Attr) error {
+	for _, a := range attrs {
+		a.Value = a.Value.Resolve()
+		if a.Equal(slog.Attr{}) {
+			continue
+		}
+		if a.Value.Kind() == slog.KindGroup {
+			if a.Key == "" {
+				if err := findDuplicate(seen, groups, a.Value.Group()); err != nil {
+					return err
+				}
+				continue
+			}
+			if len(a.Value.Group()) == 0 {
+				continue
+			}
+		}
+
+		if _, ok := seen[a.Key]; ok {
+			return fmt.Errorf("%w: %q", ErrDuplicateKey, strings.Join(append(slices.Clip(groups), a.Key), "."))
+		}
+		seen[a.Key] = struct{}{}
+
+		if a.Value.Kind() == slog.KindGroup {
+			if err := findDuplicate(map[string]struct{}{}, append(slices.Clip(groups), a.Key), a.Value.Group()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
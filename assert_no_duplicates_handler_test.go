@@ -0,0 +1,57 @@
+package slogdedup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestAssertNoDuplicatesHandler(t *testing.T) {
+	t.Parallel()
+
+	// A broken ResolveKey, which does not handle conflicts with the builtin keys
+	brokenResolveKey := func(_ []string, key string, _ int) (string, bool) {
+		return key, true
+	}
+
+	tester := &testHandler{}
+	assert := NewAssertNoDuplicatesHandler(tester, nil)
+	h := NewOverwriteHandler(assert, &OverwriteHandlerOptions{ResolveKey: brokenResolveKey})
+
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "unique", 0)
+	r.AddAttrs(slog.String("arg1", "val1"), slog.Group("group1", "arg1", "val1"))
+	if err := h.Handle(context.Background(), r); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	r = slog.NewRecord(time.Time{}, slog.LevelInfo, "duplicate", 0)
+	r.AddAttrs(slog.String("arg1", "val1"), slog.String("msg", "user-msg"))
+	err := h.Handle(context.Background(), r)
+	if !errors.Is(err, ErrDuplicateKey) || err.Error() != `slogdedup: duplicate key: "msg"` {
+		t.Errorf("Expected ErrDuplicateKey for msg, got: %v", err)
+	}
+	if tester.Record.Message != "duplicate" {
+		t.Errorf("Expected the record to still be passed on, got: %s", tester.Record.Message)
+	}
+
+	// Duplicates between the asserting handler's own attributes and the record
+	r = slog.NewRecord(time.Time{}, slog.LevelInfo, "grouped", 0)
+	r.AddAttrs(slog.String("arg2", "val2"))
+	err = NewAssertNoDuplicatesHandler(slog.NewJSONHandler(io.Discard, nil), nil).WithAttrs([]slog.Attr{slog.String("arg2", "with1")}).WithGroup("group1").
+		WithAttrs([]slog.Attr{slog.String("arg2", "with2")}).Handle(context.Background(), r)
+	if !errors.Is(err, ErrDuplicateKey) || err.Error() != `slogdedup: duplicate key: "group1.arg2"` {
+		t.Errorf("Expected ErrDuplicateKey for group1.arg2, got: %v", err)
+	}
+
+	defer func() {
+		if p := recover(); p == nil {
+			t.Error("Expected a panic")
+		}
+	}()
+	r = slog.NewRecord(time.Time{}, slog.LevelInfo, "panic", 0)
+	r.AddAttrs(slog.String("level", "user-level"))
+	_ = NewAssertNoDuplicatesHandler(tester, &AssertNoDuplicatesHandlerOptions{Panic: true}).Handle(context.Background(), r)
+}
@@ -0,0 +1,122 @@
+package slogdedup
+
+import (
+	"modernc.org/b/v2"
+)
+
+// attrStore holds the deduplicated attributes of the root level or of a single
+// group, by key. Its values are either an attribute (slog.Attr), another
+// attrStore for a group, or an appended slice of the former two.
+// Keys are the same key if the store's comparison function returns 0.
+type attrStore interface {
+	// Get returns the value of the key, and true if the key exists.
+	Get(key string) (any, bool)
+
+	// Set sets the value of the key, replacing any existing value.
+	Set(key string, v any)
+
+	// Put calls upd with the existing value of the key, if any, and then sets
+	// the key to the new value returned by upd if write is true. It returns the
+	// existing value, and true if the new value was written.
+	Put(key string, upd func(oldV any, exists bool) (newV any, write bool)) (oldV any, written bool)
+
+	// Delete removes the key, returning true if it existed.
+	Delete(key string) bool
+
+	// Len returns the number of keys.
+	Len() int
+
+	// Range calls f with each key and value, in order of the keys, until f
+	// returns false. The store must not be modified by f.
+	Range(f func(key string, v any) bool)
+
+	// New returns a new empty store of the same kind, for a group, with its
+	// keys compared by keyCompare.
+	New(keyCompare func(a, b string) int) attrStore
+}
+
+// btreeStore is the default attrStore, backed by a btree.
+// Its subtrees are kept in the btree as *b.Tree[string, any], so that the
+// trees used by AppendToTree and AttrsFromTree are plain btrees.
+type btreeStore b.Tree[string, any]
+
+var _ attrStore = &btreeStore{} // Assert conformance with interface
+
+// newBtreeStore returns a new empty btreeStore, with its keys compared by keyCompare.
+func newBtreeStore(keyCompare func(a, b string) int) attrStore {
+	return (*btreeStore)(b.TreeNew[string, any](keyCompare))
+}
+
+// tree returns the underlying btree
+func (s *btreeStore) tree() *b.Tree[string, any] {
+	return (*b.Tree[string, any])(s)
+}
+
+// Get returns the value of the key, and true if the key exists.
+func (s *btreeStore) Get(key string) (any, bool) {
+	v, ok := s.tree().Get(key)
+	return toStore(v), ok
+}
+
+// Set sets the value of the key, replacing any existing value.
+func (s *btreeStore) Set(key string, v any) {
+	s.tree().Set(key, fromStore(v))
+}
+
+// Put calls upd with the existing value of the key, if any, and then sets the
+// key to the new value returned by upd if write is true.
+func (s *btreeStore) Put(key string, upd func(oldV any, exists bool) (newV any, write bool)) (any, bool) {
+	oldV, written := s.tree().Put(key, func(oldV any, exists bool) (any, bool) {
+		newV, write := upd(toStore(oldV), exists)
+		return fromStore(newV), write
+	})
+	return toStore(oldV), written
+}
+
+// Delete removes the key, returning true if it existed.
+func (s *btreeStore) Delete(key string) bool {
+	return s.tree().Delete(key)
+}
+
+// Len returns the number of keys.
+func (s *btreeStore) Len() int {
+	return s.tree().Len()
+}
+
+// Range calls f with each key and value, in order of the keys, until f returns false.
+func (s *btreeStore) Range(f func(key string, v any) bool) {
+	en, emptyErr := s.tree().SeekFirst()
+	if emptyErr != nil {
+		return // Empty (btree only returns an error when empty)
+	}
+	defer en.Close()
+
+	for k, v, err := en.Next(); err == nil; k, v, err = en.Next() {
+		if !f(k, toStore(v)) {
+			return
+		}
+	}
+}
+
+// New returns a new empty btreeStore, with its keys compared by keyCompare.
+func (s *btreeStore) New(keyCompare func(a, b string) int) attrStore {
+	return newBtreeStore(keyCompare)
+}
+
+// toStore converts a subtree read from a btree into an attrStore.
+// All other values are returned as is.
+func toStore(v any) any {
+	if subtree, ok := v.(*b.Tree[string, any]); ok {
+		return (*btreeStore)(subtree)
+	}
+	return v
+}
+
+// fromStore converts a btreeStore into a subtree to write into a btree.
+// All other values, including other kinds of attrStore, are returned as is.
+func fromStore(v any) any {
+	if subtree, ok := v.(*btreeStore); ok {
+		return subtree.tree()
+	}
+	return v
+}
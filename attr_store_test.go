@@ -0,0 +1,107 @@
+package slogdedup
+
+import (
+	"log/slog"
+	"slices"
+	"testing"
+)
+
+// sliceStore is an alternate attrStore that keeps its keys in a sorted slice.
+type sliceStore struct {
+	keyCompare func(a, b string) int
+	keys       []string
+	values     []any
+}
+
+func newSliceStore(keyCompare func(a, b string) int) attrStore {
+	return &sliceStore{keyCompare: keyCompare}
+}
+
+func (s *sliceStore) Get(key string) (any, bool) {
+	if i, ok := slices.BinarySearchFunc(s.keys, key, s.keyCompare); ok {
+		return s.values[i], true
+	}
+	return nil, false
+}
+
+func (s *sliceStore) Set(key string, v any) {
+	s.Put(key, func(any, bool) (any, bool) { return v, true })
+}
+
+func (s *sliceStore) Put(key string, upd func(oldV any, exists bool) (newV any, write bool)) (any, bool) {
+	i, ok := slices.BinarySearchFunc(s.keys, key, s.keyCompare)
+	var oldV any
+	if ok {
+		oldV = s.values[i]
+	}
+	newV, write := upd(oldV, ok)
+	if !write {
+		return oldV, false
+	}
+	if ok {
+		s.values[i] = newV
+	} else {
+		s.keys = slices.Insert(s.keys, i, key)
+		s.values = slices.Insert(s.values, i, newV)
+	}
+	return oldV, true
+}
+
+func (s *sliceStore) Delete(key string) bool {
+	i, ok := slices.BinarySearchFunc(s.keys, key, s.keyCompare)
+	if ok {
+		s.keys = slices.Delete(s.keys, i, i+1)
+		s.values = slices.Delete(s.values, i, i+1)
+	}
+	return ok
+}
+
+func (s *sliceStore) Len() int {
+	return len(s.keys)
+}
+
+func (s *sliceStore) Range(f func(key string, v any) bool) {
+	for i, k := range s.keys {
+		if !f(k, s.values[i]) {
+			return
+		}
+	}
+}
+
+func (s *sliceStore) New(keyCompare func(a, b string) int) attrStore {
+	return newSliceStore(keyCompare)
+}
+
+func TestAttrStore(t *testing.T) {
+	t.Parallel()
+
+	// Swap the store of a handler created by one of the constructors
+	withStore := func(h slog.Handler, newStore func(keyCompare func(a, b string) int) attrStore) slog.Handler {
+		switch h := h.(type) {
+		case *OverwriteHandler:
+			h.newStore = newStore
+		case *IgnoreHandler:
+			h.newStore = newStore
+		case *IncrementHandler:
+			h.newStore = newStore
+		case *AppendHandler:
+			h.newStore = newStore
+		default:
+			t.Fatalf("Unexpected handler type %T", h)
+		}
+		return h
+	}
+
+	for name, handler := range cacheTestHandlers() {
+		for _, cache := range []bool{false, true} {
+			tester := &testHandler{}
+			logComplex(t, handler(tester, cache))
+			expected := tester.String()
+
+			logComplex(t, withStore(handler(tester, cache), newSliceStore))
+			if got := tester.String(); got != expected {
+				t.Errorf("%s cache=%t Expected:\n%s\nGot:\n%s", name, cache, expected, got)
+			}
+		}
+	}
+}
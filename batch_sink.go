@@ -0,0 +1,186 @@
+package slogdedup
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// BatchSinkOptions are options for a NewBatchSink slog.Handler
+type BatchSinkOptions struct {
+	// Size is the number of records after which the batch is flushed
+	// automatically. If less than 1, the batch is only flushed by Flush, or
+	// by Interval if it is set.
+	Size int
+
+	// Interval, if positive, flushes the batch automatically once this long has
+	// passed since the first record was added to it. Errors from these flushes
+	// are not returned anywhere, so the write function should handle its own.
+	Interval time.Duration
+
+	// HandlerOptions are used by the slog.JSONHandler that encodes each record.
+	// The Level is also used to determine which records are batched.
+	HandlerOptions *slog.HandlerOptions
+}
+
+// BatchSink is a terminal slog.Handler that deduplicates all attributes and
+// groups by overwriting older duplicates (see OverwriteHandler), then encodes
+// each record as a JSON object and holds it in a batch. When the batch is
+// flushed, its records are passed to a write function together as a single
+// JSON array, such as for the body of a bulk ingest request.
+// Handlers created by WithAttrs and WithGroup share the same batch.
+type BatchSink struct {
+	handler slog.Handler // Deduplicates, then passes records to the batchHandler
+	batch   *recordBatch
+}
+
+var _ slog.Handler = &BatchSink{} // Assert conformance with interface
+
+// batchHandler is the terminal slog.Handler that encodes each record as JSON
+// and adds it to the batch. It does not deduplicate anything itself, and
+// expects to be placed after one of the dedup middlewares.
+type batchHandler struct {
+	opts  slog.HandlerOptions
+	batch *recordBatch
+}
+
+var _ slog.Handler = &batchHandler{} // Assert conformance with interface
+
+// recordBatch is the batch of encoded records, shared by a BatchSink and all
+// handlers derived from it.
+type recordBatch struct {
+	mu       sync.Mutex
+	flushMu  sync.Mutex // Keeps concurrent flushes from interleaving
+	size     int
+	interval time.Duration
+	timer    *time.Timer
+	records  [][]byte
+	write    func(batch []byte) error
+}
+
+// NewBatchSink creates a terminal slog.Handler that deduplicates all
+// attributes and groups by overwriting older duplicates (see OverwriteHandler),
+// then holds each record as JSON in a batch. When the batch is flushed, write is
+// called with all of its records as a single JSON array, oldest first:
+//
+//	[{"time":"2024-03-21T09:33:25Z","level":"INFO","msg":"hello world","arg1":"val1"},{"time":"2024-03-21T09:33:26Z","level":"INFO","msg":"second"}]
+//
+// The batch is flushed when Flush is called, or automatically according to the
+// Size and Interval options. Write is not called for an empty batch, and is
+// never called concurrently. If opts is nil, the default options are used.
+func NewBatchSink(write func(batch []byte) error, opts *BatchSinkOptions) *BatchSink {
+	if opts == nil {
+		opts = &BatchSinkOptions{}
+	}
+	batch := &recordBatch{size: opts.Size, interval: opts.Interval, write: write}
+	h := &batchHandler{batch: batch}
+	if opts.HandlerOptions != nil {
+		h.opts = *opts.HandlerOptions
+	}
+	return &BatchSink{
+		handler: NewOverwriteHandler(h, nil),
+		batch:   batch,
+	}
+}
+
+// Enabled reports whether the level is at least the minimum level of the
+// HandlerOptions, which defaults to INFO.
+func (h *BatchSink) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+// Handle deduplicates the record and adds it to the batch, flushing the batch
+// if it is full.
+func (h *BatchSink) Handle(ctx context.Context, r slog.Record) error {
+	return h.handler.Handle(ctx, r)
+}
+
+// WithGroup returns a new BatchSink that namespaces any future attributes,
+// sharing the same batch.
+func (h *BatchSink) WithGroup(name string) slog.Handler {
+	return &BatchSink{handler: h.handler.WithGroup(name), batch: h.batch}
+}
+
+// WithAttrs returns a new BatchSink that includes the attributes, sharing the
+// same batch.
+func (h *BatchSink) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &BatchSink{handler: h.handler.WithAttrs(attrs), batch: h.batch}
+}
+
+// Flush passes all records in the batch to the write function as a single
+// JSON array, and returns any error from it.
+func (h *BatchSink) Flush() error {
+	return h.batch.flush()
+}
+
+// Enabled reports whether the level is at least the minimum level of the
+// HandlerOptions, which defaults to INFO.
+func (h *batchHandler) Enabled(_ context.Context, level slog.Level) bool {
+	minLevel := slog.LevelInfo
+	if h.opts.Level != nil {
+		minLevel = h.opts.Level.Level()
+	}
+	return level >= minLevel
+}
+
+// Handle encodes the record as JSON and adds it to the batch, flushing the
+// batch if it is full.
+func (h *batchHandler) Handle(ctx context.Context, r slog.Record) error {
+	buf := &bytes.Buffer{}
+	if err := slog.NewJSONHandler(buf, &h.opts).Handle(ctx, r); err != nil {
+		return err
+	}
+	if h.batch.add(bytes.TrimSuffix(buf.Bytes(), []byte("\n"))) {
+		return h.batch.flush()
+	}
+	return nil
+}
+
+// WithGroup returns a new handler that deduplicates and namespaces any future attributes.
+func (h *batchHandler) WithGroup(name string) slog.Handler {
+	return NewOverwriteHandler(h, nil).WithGroup(name)
+}
+
+// WithAttrs returns a new handler that deduplicates and includes the attributes.
+func (h *batchHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return NewOverwriteHandler(h, nil).WithAttrs(attrs)
+}
+
+// add holds the encoded record, starting the interval timer if it is the
+// first record in the batch, and returns true if the batch is now full.
+func (rb *recordBatch) add(record []byte) bool {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.records = append(rb.records, record)
+	if len(rb.records) == 1 && rb.interval > 0 {
+		rb.timer = time.AfterFunc(rb.interval, func() { _ = rb.flush() })
+	}
+	return rb.size > 0 && len(rb.records) >= rb.size
+}
+
+// flush empties the batch, and passes its records to the write function as a
+// single JSON array.
+func (rb *recordBatch) flush() error {
+	rb.flushMu.Lock()
+	defer rb.flushMu.Unlock()
+
+	rb.mu.Lock()
+	records := rb.records
+	rb.records = nil
+	if rb.timer != nil {
+		rb.timer.Stop()
+		rb.timer = nil
+	}
+	rb.mu.Unlock()
+
+	if len(records) == 0 {
+		return nil
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteByte('[')
+	buf.Write(bytes.Join(records, []byte(",")))
+	buf.WriteByte(']')
+	return rb.write(buf.Bytes())
+}
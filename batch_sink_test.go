@@ -0,0 +1,93 @@
+package slogdedup
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatchSink(t *testing.T) {
+	t.Parallel()
+
+	var batches []string
+	h := NewBatchSink(func(batch []byte) error {
+		batches = append(batches, string(batch))
+		return nil
+	}, &BatchSinkOptions{Size: 3, HandlerOptions: &slog.HandlerOptions{ReplaceAttr: dropTimeReplaceAttr}})
+
+	log := slog.New(h).With("arg1", "with1arg1")
+	for i := 0; i < 3; i++ {
+		log.Info("batched", "arg1", i, "arg2", "main", "arg2", i)
+	}
+
+	// The third record fills the batch
+	expected := `[{"level":"INFO","msg":"batched","arg1":0,"arg2":0},{"level":"INFO","msg":"batched","arg1":1,"arg2":1},{"level":"INFO","msg":"batched","arg1":2,"arg2":2}]`
+	if len(batches) != 1 || batches[0] != expected {
+		t.Fatalf("Expected one batch:\n%s\nGot:\n%s", expected, strings.Join(batches, "\n"))
+	}
+
+	var records []map[string]any
+	if err := json.Unmarshal([]byte(batches[0]), &records); err != nil {
+		t.Errorf("Unable to unmarshal json: %v", err)
+	}
+	if len(records) != 3 {
+		t.Errorf("Expected 3 records; Got: %d", len(records))
+	}
+
+	// Flush sends the remainder, and nothing when empty
+	log.WithGroup("group1").Warn("remainder", "arg1", "val1", "arg1", "val2")
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	expected = `[{"level":"WARN","msg":"remainder","arg1":"with1arg1","group1":{"arg1":"val2"}}]`
+	if len(batches) != 2 || batches[1] != expected {
+		t.Errorf("Expected flushed batch:\n%s\nGot:\n%s", expected, strings.Join(batches, "\n"))
+	}
+}
+
+func TestBatchSinkInterval(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var batches []string
+	h := NewBatchSink(func(batch []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, string(batch))
+		return nil
+	}, &BatchSinkOptions{Interval: 50 * time.Millisecond, HandlerOptions: &slog.HandlerOptions{ReplaceAttr: dropTimeReplaceAttr}})
+
+	log := slog.New(h)
+	log.Info("first")
+	log.Info("second")
+
+	expected := `[{"level":"INFO","msg":"first"},{"level":"INFO","msg":"second"}]`
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		mu.Lock()
+		n := len(batches)
+		mu.Unlock()
+		if n > 0 {
+			break
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(batches) != 1 || batches[0] != expected {
+		t.Errorf("Expected one batch:\n%s\nGot:\n%s", expected, strings.Join(batches, "\n"))
+	}
+}
+
+// dropTimeReplaceAttr removes the builtin time, which is variable
+func dropTimeReplaceAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 && a.Key == slog.TimeKey {
+		return slog.Attr{}
+	}
+	return a
+}
@@ -0,0 +1,54 @@
+package slogdedup
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// callbackHandler is a terminal slog.Handler that passes each record's
+// attributes to a callback function. It does not deduplicate anything itself,
+// and expects to be placed after one of the dedup middlewares.
+type callbackHandler struct {
+	callback func(ctx context.Context, level slog.Level, msg string, attrs []slog.Attr, t time.Time)
+}
+
+var _ slog.Handler = &callbackHandler{} // Assert conformance with interface
+
+// NewCallbackHandler creates a terminal slog.Handler that deduplicates all
+// attributes and groups by overwriting older duplicates (see OverwriteHandler),
+// then calls callback with the record's level, message, time, and its final
+// deduplicated attributes, instead of passing the record to another
+// slog.Handler. This can be used to send logs to a backend that is not a
+// slog.Handler. Attributes inside of groups are still inside of slog.Group's.
+// The attrs slice belongs to the callback, but the callback must be safe to
+// call concurrently. All levels are enabled.
+func NewCallbackHandler(callback func(ctx context.Context, level slog.Level, msg string, attrs []slog.Attr, t time.Time)) slog.Handler {
+	return NewOverwriteHandler(&callbackHandler{callback: callback}, nil)
+}
+
+// Enabled reports true for all levels.
+func (h *callbackHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+// Handle calls the callback with the record and its attributes.
+func (h *callbackHandler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := make([]slog.Attr, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	h.callback(ctx, r.Level, r.Message, attrs, r.Time)
+	return nil
+}
+
+// WithGroup returns a new handler that deduplicates and namespaces any future attributes.
+func (h *callbackHandler) WithGroup(name string) slog.Handler {
+	return NewOverwriteHandler(h, nil).WithGroup(name)
+}
+
+// WithAttrs returns a new handler that deduplicates and includes the attributes.
+func (h *callbackHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return NewOverwriteHandler(h, nil).WithAttrs(attrs)
+}
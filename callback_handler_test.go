@@ -0,0 +1,40 @@
+package slogdedup
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestCallbackHandler(t *testing.T) {
+	t.Parallel()
+
+	var (
+		gotLevel slog.Level
+		gotMsg   string
+		gotAttrs []slog.Attr
+		gotTime  time.Time
+	)
+	log := slog.New(NewCallbackHandler(func(_ context.Context, level slog.Level, msg string, attrs []slog.Attr, t time.Time) {
+		gotLevel, gotMsg, gotAttrs, gotTime = level, msg, attrs, t
+	}))
+
+	log = log.With("arg1", "with1arg1", "msg", "with1msg")
+	log.WithGroup("group1").With("arg2", "with2arg2").Warn("main message", "arg2", "main2arg2", "arg3", "main2arg3")
+
+	if gotLevel != slog.LevelWarn || gotMsg != "main message" || gotTime.IsZero() {
+		t.Errorf("Unexpected record: level=%s msg=%q time=%s", gotLevel, gotMsg, gotTime)
+	}
+
+	expected := slog.GroupValue(
+		slog.String("arg1", "with1arg1"),
+		slog.Group("group1", slog.String("arg2", "main2arg2"), slog.String("arg3", "main2arg3")),
+		slog.String("msg#01", "with1msg"),
+	)
+	if got := slog.GroupValue(gotAttrs...); !got.Equal(expected) {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+
+	checkForDuplicates(t, append(gotAttrs, slog.String(slog.MessageKey, gotMsg)))
+}
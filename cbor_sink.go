@@ -0,0 +1,229 @@
+package slogdedup
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// CBOR major types https://www.rfc-editor.org/rfc/rfc8949.html#section-3.1
+const (
+	cborUint   byte = 0 << 5
+	cborNegInt byte = 1 << 5
+	cborBytes  byte = 2 << 5
+	cborText   byte = 3 << 5
+	cborArray  byte = 4 << 5
+	cborMap    byte = 5 << 5
+	cborTag    byte = 6 << 5
+
+	cborFalse   byte = 0xf4
+	cborTrue    byte = 0xf5
+	cborNull    byte = 0xf6
+	cborFloat64 byte = 0xfb
+
+	cborTagDateTimeString = 0 // Standard date/time string, RFC 3339
+)
+
+// cborHandler is a terminal slog.Handler that writes each record as a single
+// CBOR map (RFC 8949), making the output a CBOR sequence (RFC 8742).
+// It does not deduplicate anything itself, and expects to be placed after one
+// of the dedup middlewares.
+type cborHandler struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+var _ slog.Handler = &cborHandler{} // Assert conformance with interface
+
+// NewCBORSink creates a terminal slog.Handler that deduplicates all attributes
+// and groups by overwriting older duplicates (see OverwriteHandler), then
+// writes the record to w as a CBOR map (RFC 8949).
+// Each record is a separate CBOR data item, so the output is a CBOR sequence (RFC 8742).
+// The map contains the builtin time (as a tagged RFC 3339 string), level, and
+// msg, followed by all other attributes, with groups as nested maps.
+// All levels are enabled.
+func NewCBORSink(w io.Writer) slog.Handler {
+	return NewOverwriteHandler(&cborHandler{mu: &sync.Mutex{}, w: w}, nil)
+}
+
+// Enabled reports true for all levels.
+func (h *cborHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+// Handle writes the record as a single CBOR map.
+func (h *cborHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := make([]slog.Attr, 0, r.NumAttrs()+3)
+	if !r.Time.IsZero() {
+		attrs = append(attrs, slog.Time(slog.TimeKey, r.Time))
+	}
+	attrs = append(attrs, slog.String(slog.LevelKey, r.Level.String()), slog.String(slog.MessageKey, r.Message))
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+
+	buf := &bytes.Buffer{}
+	writeCBORAttrs(buf, attrs)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(buf.Bytes())
+	return err
+}
+
+// WithGroup returns a new handler that deduplicates and namespaces any future attributes.
+func (h *cborHandler) WithGroup(name string) slog.Handler {
+	return NewOverwriteHandler(h, nil).WithGroup(name)
+}
+
+// WithAttrs returns a new handler that deduplicates and includes the attributes.
+func (h *cborHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return NewOverwriteHandler(h, nil).WithAttrs(attrs)
+}
+
+// writeCBORAttrs writes the attributes as a CBOR map, inlining any groups with empty keys.
+func writeCBORAttrs(buf *bytes.Buffer, attrs []slog.Attr) {
+	flat := flattenInlineAttrs(make([]slog.Attr, 0, len(attrs)), attrs)
+	writeCBORHead(buf, cborMap, uint64(len(flat)))
+	for _, a := range flat {
+		writeCBORHead(buf, cborText, uint64(len(a.Key)))
+		buf.WriteString(a.Key)
+		writeCBORValue(buf, a.Value)
+	}
+}
+
+// flattenInlineAttrs resolves the attributes, dropping empty ones and inlining
+// any groups with empty keys.
+func flattenInlineAttrs(dst, attrs []slog.Attr) []slog.Attr {
+	for _, a := range attrs {
+		a.Value = a.Value.Resolve()
+		if a.Equal(slog.Attr{}) {
+			continue
+		}
+		if a.Key == "" && a.Value.Kind() == slog.KindGroup {
+			dst = flattenInlineAttrs(dst, a.Value.Group())
+			continue
+		}
+		dst = append(dst, a)
+	}
+	return dst
+}
+
+// writeCBORValue writes the resolved value as a CBOR data item
+func writeCBORValue(buf *bytes.Buffer, v slog.Value) {
+	switch v.Kind() {
+	case slog.KindString:
+		writeCBORHead(buf, cborText, uint64(len(v.String())))
+		buf.WriteString(v.String())
+	case slog.KindInt64:
+		writeCBORInt(buf, v.Int64())
+	case slog.KindUint64:
+		writeCBORHead(buf, cborUint, v.Uint64())
+	case slog.KindFloat64:
+		buf.WriteByte(cborFloat64)
+		_ = binary.Write(buf, binary.BigEndian, math.Float64bits(v.Float64()))
+	case slog.KindBool:
+		if v.Bool() {
+			buf.WriteByte(cborTrue)
+		} else {
+			buf.WriteByte(cborFalse)
+		}
+	case slog.KindDuration:
+		writeCBORInt(buf, int64(v.Duration()))
+	case slog.KindTime:
+		writeCBORHead(buf, cborTag, cborTagDateTimeString)
+		s := v.Time().Format(time.RFC3339Nano)
+		writeCBORHead(buf, cborText, uint64(len(s)))
+		buf.WriteString(s)
+	case slog.KindGroup:
+		writeCBORAttrs(buf, v.Group())
+	case slog.KindLogValuer:
+		writeCBORValue(buf, v.Resolve())
+	default:
+		writeCBORAny(buf, v.Any())
+	}
+}
+
+// writeCBORAny writes the arbitrary value as a CBOR data item.
+// Slices and maps (such as those created by the AppendHandler) become arrays and maps,
+// and anything else unknown is written as a string.
+func writeCBORAny(buf *bytes.Buffer, v any) {
+	switch x := v.(type) {
+	case nil:
+		buf.WriteByte(cborNull)
+	case []byte:
+		writeCBORHead(buf, cborBytes, uint64(len(x)))
+		buf.Write(x)
+	case []any:
+		writeCBORHead(buf, cborArray, uint64(len(x)))
+		for _, elem := range x {
+			writeCBORAny(buf, elem)
+		}
+	case []string:
+		writeCBORHead(buf, cborArray, uint64(len(x)))
+		for _, elem := range x {
+			writeCBORAny(buf, elem)
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		writeCBORHead(buf, cborMap, uint64(len(keys)))
+		for _, k := range keys {
+			writeCBORHead(buf, cborText, uint64(len(k)))
+			buf.WriteString(k)
+			writeCBORAny(buf, x[k])
+		}
+	case error:
+		writeCBORAny(buf, x.Error())
+	case fmt.Stringer:
+		writeCBORAny(buf, x.String())
+	default:
+		if sv := slog.AnyValue(v); sv.Kind() != slog.KindAny {
+			writeCBORValue(buf, sv)
+			return
+		}
+		s := fmt.Sprint(v)
+		writeCBORHead(buf, cborText, uint64(len(s)))
+		buf.WriteString(s)
+	}
+}
+
+// writeCBORInt writes a signed integer as either a CBOR unsigned or negative integer
+func writeCBORInt(buf *bytes.Buffer, i int64) {
+	if i >= 0 {
+		writeCBORHead(buf, cborUint, uint64(i))
+		return
+	}
+	writeCBORHead(buf, cborNegInt, uint64(-1-i))
+}
+
+// writeCBORHead writes the initial byte and argument of a CBOR data item,
+// using the shortest encoding possible.
+func writeCBORHead(buf *bytes.Buffer, major byte, n uint64) {
+	switch {
+	case n < 24:
+		buf.WriteByte(major | byte(n))
+	case n <= math.MaxUint8:
+		buf.Write([]byte{major | 24, byte(n)})
+	case n <= math.MaxUint16:
+		buf.WriteByte(major | 25)
+		_ = binary.Write(buf, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		buf.WriteByte(major | 26)
+		_ = binary.Write(buf, binary.BigEndian, uint32(n))
+	default:
+		buf.WriteByte(major | 27)
+		_ = binary.Write(buf, binary.BigEndian, n)
+	}
+}
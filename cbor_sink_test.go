@@ -0,0 +1,153 @@
+package slogdedup
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCBORSink(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	log := slog.New(NewCBORSink(buf))
+
+	log = log.With("arg1", "with1arg1", "neg", -300, slog.Group("", "inlined", true))
+	log.WithGroup("group1").Warn("main message",
+		"arg1", "main1arg1", "arg1", "main1arg2", "float", 1.5, "bytes", []byte("hi"), "dur", time.Second,
+		"at", time.Date(2023, 9, 29, 13, 0, 59, 0, time.UTC), "err", errors.New("oops"), "nil", nil,
+		slog.Group("sub", "big", uint64(70000)))
+
+	reader := bytes.NewReader(buf.Bytes())
+	decoded, err := decodeCBOR(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reader.Len() != 0 {
+		t.Errorf("Expected a single CBOR data item; %d bytes remaining", reader.Len())
+	}
+
+	record, ok := decoded.(map[string]any)
+	if !ok {
+		t.Fatalf("Expected a CBOR map; Got: %#v", decoded)
+	}
+	if _, ok := record[slog.TimeKey].(time.Time); !ok {
+		t.Errorf("Expected a tagged time; Got: %#v", record[slog.TimeKey])
+	}
+	delete(record, slog.TimeKey)
+
+	jBytes, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"arg1":"with1arg1","group1":{"arg1":"main1arg2","at":"2023-09-29T13:00:59Z","bytes":"aGk=","dur":1000000000,"err":"oops","float":1.5,"nil":null,"sub":{"big":70000}},"inlined":true,"level":"WARN","msg":"main message","neg":-300}`
+	if string(jBytes) != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, string(jBytes))
+	}
+}
+
+// decodeCBOR is a minimal CBOR decoder, supporting only what the CBOR sink writes
+func decodeCBOR(r *bytes.Reader) (any, error) {
+	initial, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	major, info := initial&0xe0, initial&0x1f
+
+	if major == 0xe0 {
+		switch initial {
+		case cborFalse:
+			return false, nil
+		case cborTrue:
+			return true, nil
+		case cborNull:
+			return nil, nil
+		case cborFloat64:
+			var bits uint64
+			err = binary.Read(r, binary.BigEndian, &bits)
+			return math.Float64frombits(bits), err
+		default:
+			return nil, fmt.Errorf("unsupported simple value %x", initial)
+		}
+	}
+
+	var n uint64
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info == 24:
+		var v uint8
+		err = binary.Read(r, binary.BigEndian, &v)
+		n = uint64(v)
+	case info == 25:
+		var v uint16
+		err = binary.Read(r, binary.BigEndian, &v)
+		n = uint64(v)
+	case info == 26:
+		var v uint32
+		err = binary.Read(r, binary.BigEndian, &v)
+		n = uint64(v)
+	case info == 27:
+		err = binary.Read(r, binary.BigEndian, &n)
+	default:
+		return nil, fmt.Errorf("unsupported additional info %d", info)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborUint:
+		return n, nil
+	case cborNegInt:
+		return -1 - int64(n), nil
+	case cborBytes, cborText:
+		bs := make([]byte, n)
+		if _, err = r.Read(bs); err != nil {
+			return nil, err
+		}
+		if major == cborBytes {
+			return bs, nil
+		}
+		return string(bs), nil
+	case cborArray:
+		arr := make([]any, 0, n)
+		for i := uint64(0); i < n; i++ {
+			v, err := decodeCBOR(r)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	case cborMap:
+		m := make(map[string]any, n)
+		for i := uint64(0); i < n; i++ {
+			k, err := decodeCBOR(r)
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeCBOR(r)
+			if err != nil {
+				return nil, err
+			}
+			m[k.(string)] = v
+		}
+		return m, nil
+	case cborTag:
+		v, err := decodeCBOR(r)
+		if err != nil || n != cborTagDateTimeString {
+			return v, err
+		}
+		return time.Parse(time.RFC3339Nano, v.(string))
+	default:
+		return nil, fmt.Errorf("unsupported major type %x", major)
+	}
+}
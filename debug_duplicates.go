@@ -0,0 +1,96 @@
+package slogdedup
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// duplicateDebugger writes a line describing each collision to a writer.
+// It is shared by a handler and all handlers derived from it, so that lines
+// from concurrent records are not interleaved.
+type duplicateDebugger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// newDuplicateDebugger returns a duplicateDebugger, or nil if w is nil.
+func newDuplicateDebugger(w io.Writer) *duplicateDebugger {
+	if w == nil {
+		return nil
+	}
+	return &duplicateDebugger{w: w}
+}
+
+// duplicateLine is the JSON line written for each collision.
+type duplicateLine struct {
+	Path    string `json:"path"`
+	Kept    any    `json:"kept"`
+	Dropped any    `json:"dropped"`
+}
+
+// write writes a JSON line for each collision, with the path to the key as a
+// JSON pointer, such as "/group1/arg1", and the kept and dropped values.
+// All lines for a record are written together. Safe to call on a nil
+// duplicateDebugger.
+func (d *duplicateDebugger) write(collisions []collision) {
+	if d == nil || len(collisions) == 0 {
+		return
+	}
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	for _, c := range collisions {
+		line := duplicateLine{
+			Path:    jsonPointer(c.keyPath),
+			Kept:    debugValue(c.kept),
+			Dropped: debugValue(c.dropped),
+		}
+		if err := enc.Encode(line); err != nil {
+			// Values that can't be marshaled are written as strings instead
+			line.Kept, line.Dropped = debugString(c.kept), debugString(c.dropped)
+			_ = enc.Encode(line)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, _ = d.w.Write(buf.Bytes())
+}
+
+// jsonPointer returns the key path as a JSON pointer (RFC 6901)
+func jsonPointer(keyPath []string) string {
+	var sb strings.Builder
+	for _, key := range keyPath {
+		sb.WriteByte('/')
+		sb.WriteString(strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1"))
+	}
+	return sb.String()
+}
+
+// debugValue returns the value of an attribute, or a subtree as a map
+func debugValue(v any) any {
+	switch val := v.(type) {
+	case slog.Attr:
+		return val.Value.Any()
+	case attrStore:
+		return buildGroupMap(buildAttrs(val, nil, AppendedGroupMap))
+	default:
+		return v
+	}
+}
+
+// debugString returns the value of an attribute, or a subtree, as a string
+func debugString(v any) string {
+	switch val := v.(type) {
+	case slog.Attr:
+		return val.Value.String()
+	case attrStore:
+		return slog.GroupValue(buildAttrs(val, nil, AppendedGroupMap)...).String()
+	default:
+		return ""
+	}
+}
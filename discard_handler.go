@@ -0,0 +1,51 @@
+package slogdedup
+
+import (
+	"context"
+	"log/slog"
+)
+
+// DiscardHandler is a terminal slog.Handler that discards every record.
+// Because Enabled always returns false, slog.Logger skips the middlewares in
+// front of it without doing any work, which makes it useful for benchmarks or
+// disabling logging, while keeping the rest of the pipeline in place.
+type DiscardHandler struct{}
+
+var _ slog.Handler = &DiscardHandler{} // Assert conformance with interface
+
+// NewDiscardHandler creates a DiscardHandler slog.Handler that discards every
+// record and reports all levels as disabled:
+//
+//	slog.SetDefault(slog.New(slogdedup.NewOverwriteHandler(slogdedup.NewDiscardHandler(), nil)))
+func NewDiscardHandler() *DiscardHandler {
+	return &DiscardHandler{}
+}
+
+// Enabled returns false, as all records are discarded.
+func (h *DiscardHandler) Enabled(context.Context, slog.Level) bool {
+	return false
+}
+
+// Handle discards the record.
+func (h *DiscardHandler) Handle(context.Context, slog.Record) error {
+	return nil
+}
+
+// WithAttrs returns the same DiscardHandler.
+func (h *DiscardHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return h
+}
+
+// WithGroup returns the same DiscardHandler.
+func (h *DiscardHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// nextOrDiscard returns next, or a DiscardHandler if next is nil, so that a
+// nil next handler does not panic when logging.
+func nextOrDiscard(next slog.Handler) slog.Handler {
+	if next == nil {
+		return NewDiscardHandler()
+	}
+	return next
+}
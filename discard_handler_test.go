@@ -0,0 +1,59 @@
+package slogdedup
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestNilNextHandler(t *testing.T) {
+	t.Parallel()
+
+	handlers := map[string]slog.Handler{
+		"overwrite":          NewOverwriteHandler(nil, nil),
+		"ignore":             NewIgnoreHandler(nil, nil),
+		"increment":          NewIncrementHandler(nil, nil),
+		"append":             NewAppendHandler(nil, nil),
+		"drop-repeat":        NewDropRepeatHandler(nil),
+		"kind-group":         NewKindGroupHandler(nil),
+		"level-count":        NewLevelCountHandler(nil, func(slog.Level) {}),
+		"prune-empty-groups": NewPruneEmptyGroupsHandler(nil),
+		"ring-buffer":        NewRingBufferHandler(nil, 1),
+		"schema":             NewSchemaHandler(nil, nil),
+		"middleware":         NewOverwriteMiddleware(nil)(nil),
+	}
+
+	for name, h := range handlers {
+		if h.Enabled(context.Background(), slog.LevelError) {
+			t.Errorf("%s Expected a nil next handler to be disabled", name)
+		}
+
+		// Call Handle directly, because slog.Logger skips disabled handlers
+		log := slog.New(h).With("arg1", "val1").WithGroup("group1")
+		err := log.Handler().Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "main message", 0))
+		if err != nil {
+			t.Errorf("%s Expected no error; Got: %v", name, err)
+		}
+	}
+}
+
+func TestDiscardHandler(t *testing.T) {
+	t.Parallel()
+
+	discard := NewDiscardHandler()
+	if discard.Enabled(context.Background(), slog.LevelError) {
+		t.Error("Expected the discard handler to be disabled")
+	}
+
+	log := slog.New(NewLevelCountHandler(discard, func(slog.Level) { t.Error("Expected no records to be counted") }))
+	log.With("arg1", "val1").WithGroup("group1").Error("main message", "arg2", "val2")
+
+	h := discard.WithAttrs([]slog.Attr{slog.String("arg1", "val1")}).WithGroup("group1")
+	if err := h.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelError, "main message", 0)); err != nil {
+		t.Errorf("Expected no error; Got: %v", err)
+	}
+	if h != slog.Handler(discard) {
+		t.Error("Expected WithAttrs and WithGroup to return the same discard handler")
+	}
+}
@@ -0,0 +1,145 @@
+package slogdedup
+
+import (
+	"context"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// DropRepeatHandler is a slog.Handler middleware that drops any record that is
+// an exact repeat of the record before it, ignoring the time, like syslog.
+// When a streak of repeats ends, a record with the message
+// "last message repeated N times" is passed to the next handler, with the
+// level of the repeated record, before the new record.
+// Records are compared by a fingerprint of their level, message, and
+// attributes, so put this handler after one of the deduplicating handlers.
+// Handlers created by WithAttrs and WithGroup share the same previous record,
+// but records logged through different handlers are never repeats.
+// It is safe for concurrent use, but concurrent records may be interleaved
+// with the repeated message record.
+type DropRepeatHandler struct {
+	next   slog.Handler
+	shared *repeatState
+	scope  uint64 // Identifies the WithAttrs and WithGroup of this handler
+}
+
+var _ slog.Handler = &DropRepeatHandler{} // Assert conformance with interface
+
+// repeatState is the previous record, shared by a DropRepeatHandler and all
+// handlers derived from it.
+type repeatState struct {
+	mu       sync.Mutex
+	scopes   atomic.Uint64
+	prevHash uint64
+	prevNext slog.Handler
+	prevLvl  slog.Level
+	repeats  int
+}
+
+// NewDropRepeatMiddleware creates a DropRepeatHandler slog.Handler middleware
+// that conforms to [github.com/samber/slog-multi.Middleware] interface.
+// It can be used with slogmulti methods such as Pipe to easily setup a pipeline of slog handlers:
+//
+//	slog.SetDefault(slog.New(slogmulti.
+//		Pipe(slogdedup.NewOverwriteMiddleware(&slogdedup.OverwriteHandlerOptions{})).
+//		Pipe(slogdedup.NewDropRepeatMiddleware()).
+//		Handler(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{})),
+//	))
+func NewDropRepeatMiddleware() func(slog.Handler) slog.Handler {
+	return func(next slog.Handler) slog.Handler {
+		return NewDropRepeatHandler(
+			next,
+		)
+	}
+}
+
+// NewDropRepeatHandler creates a DropRepeatHandler slog.Handler middleware
+// that drops records that are exact repeats of the previous record, and then
+// reports how many were dropped when the streak ends.
+// If next is nil, all records are discarded instead of panicking.
+func NewDropRepeatHandler(next slog.Handler) *DropRepeatHandler {
+	return &DropRepeatHandler{
+		next:   nextOrDiscard(next),
+		shared: &repeatState{},
+	}
+}
+
+// Enabled reports whether the next handler handles records at the given level.
+// The handler ignores records whose level is lower.
+func (h *DropRepeatHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle drops the record if it is a repeat of the previous record. Otherwise,
+// it reports any repeats of the previous record, then passes the record to the
+// next handler.
+func (h *DropRepeatHandler) Handle(ctx context.Context, r slog.Record) error {
+	hash := h.fingerprint(r)
+
+	h.shared.mu.Lock()
+	if h.shared.prevNext != nil && hash == h.shared.prevHash {
+		h.shared.repeats++
+		h.shared.mu.Unlock()
+		return nil
+	}
+	repeats, repeatNext, repeatLvl := h.shared.repeats, h.shared.prevNext, h.shared.prevLvl
+	h.shared.prevHash, h.shared.prevNext, h.shared.prevLvl, h.shared.repeats = hash, h.next, r.Level, 0
+	h.shared.mu.Unlock()
+
+	if repeats > 0 {
+		repeated := slog.NewRecord(r.Time, repeatLvl, fmt.Sprintf("last message repeated %d times", repeats), 0)
+		if err := repeatNext.Handle(ctx, repeated); err != nil {
+			return err
+		}
+	}
+	return h.next.Handle(ctx, r)
+}
+
+// WithGroup returns a new DropRepeatHandler whose next handler has the group.
+func (h *DropRepeatHandler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	h2.next = h2.next.WithGroup(name)
+	h2.scope = h.shared.scopes.Add(1)
+	return &h2
+}
+
+// WithAttrs returns a new DropRepeatHandler whose next handler has the attributes.
+func (h *DropRepeatHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.next = h2.next.WithAttrs(attrs)
+	h2.scope = h.shared.scopes.Add(1)
+	return &h2
+}
+
+// fingerprint returns a hash of the handler's scope, and the record's level,
+// message, and attributes.
+func (h *DropRepeatHandler) fingerprint(r slog.Record) uint64 {
+	hasher := fnv.New64a()
+	fmt.Fprintf(hasher, "%d\x00%s\x00%s\x00", h.scope, r.Level, r.Message)
+	r.Attrs(func(a slog.Attr) bool {
+		writeAttrHash(hasher, a)
+		return true
+	})
+	return hasher.Sum64()
+}
+
+// writeAttrHash writes the key and value of the attribute to the hash,
+// including all attributes inside of it if it is a group.
+func writeAttrHash(h hash.Hash64, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	fmt.Fprintf(h, "%s=", a.Key)
+	if a.Value.Kind() == slog.KindGroup {
+		fmt.Fprint(h, "{")
+		for _, ga := range a.Value.Group() {
+			writeAttrHash(h, ga)
+		}
+		fmt.Fprint(h, "}")
+	} else {
+		fmt.Fprint(h, a.Value.String())
+	}
+	fmt.Fprint(h, "\x00")
+}
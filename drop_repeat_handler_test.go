@@ -0,0 +1,54 @@
+package slogdedup
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+)
+
+func TestDropRepeatHandler(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	sink := slog.NewJSONHandler(buf, &slog.HandlerOptions{ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return a
+	}})
+	h := NewOverwriteHandler(NewDropRepeatHandler(sink), nil)
+
+	log := slog.New(h).With("arg1", "val1")
+	for i := 0; i < 3; i++ {
+		log.Warn("repeat", "arg2", "val2", "arg2", "val2")
+	}
+	log.Info("repeat", "arg2", "val3")
+	log.Info("repeat", "arg2", "val3")
+	log.With("arg3", "val3").Info("repeat", "arg2", "val3")
+
+	expected := `{"level":"WARN","msg":"repeat","arg1":"val1","arg2":"val2"}
+{"level":"WARN","msg":"last message repeated 2 times"}
+{"level":"INFO","msg":"repeat","arg1":"val1","arg2":"val3"}
+{"level":"INFO","msg":"last message repeated 1 times"}
+{"level":"INFO","msg":"repeat","arg1":"val1","arg2":"val3","arg3":"val3"}
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, buf.String())
+	}
+
+	// Used directly, records from different loggers are never repeats
+	buf.Reset()
+	direct := slog.New(NewDropRepeatHandler(sink))
+	direct.With("arg1", "val1").Info("direct")
+	direct.With("arg1", "val2").Info("direct")
+	direct.Info("direct")
+	direct.Info("direct")
+
+	expected = `{"level":"INFO","msg":"direct","arg1":"val1"}
+{"level":"INFO","msg":"direct","arg1":"val2"}
+{"level":"INFO","msg":"direct"}
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, buf.String())
+	}
+}
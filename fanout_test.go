@@ -0,0 +1,131 @@
+package slogdedup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// fanoutHandler passes each record to all of its handlers, the same way as
+// github.com/samber/slog-multi.Fanout does.
+type fanoutHandler []slog.Handler
+
+func (h fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, next := range h {
+		if next.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h fanoutHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, next := range h {
+		if next.Enabled(ctx, r.Level) {
+			errs = append(errs, next.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h fanoutHandler) WithGroup(name string) slog.Handler {
+	h2 := make(fanoutHandler, len(h))
+	for i, next := range h {
+		h2[i] = next.WithGroup(name)
+	}
+	return h2
+}
+
+func (h fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := make(fanoutHandler, len(h))
+	for i, next := range h {
+		h2[i] = next.WithAttrs(attrs)
+	}
+	return h2
+}
+
+func TestFanout(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		middleware func(next slog.Handler) slog.Handler
+		expected1  string
+		expected2  string
+	}{
+		{
+			name:       "overwrite",
+			middleware: NewOverwriteMiddleware(&OverwriteHandlerOptions{CommonOptions: CommonOptions{TextOrder: true, CacheWithAttrs: true}}),
+			expected1:  `{"level":"INFO","msg":"main message","ARG1":"with1","group1":{"ARG2":"main2","SECRET":"hidden","ARG1":"main1"}}`,
+			expected2:  `{"level":"INFO","msg":"main message","arg1":"with1","group1":{"arg2":"main2","arg1":"main1"}}`,
+		},
+		{
+			name:       "ignore",
+			middleware: NewIgnoreMiddleware(&IgnoreHandlerOptions{CommonOptions: CommonOptions{TextOrder: true}}),
+			expected1:  `{"level":"INFO","msg":"main message","ARG1":"with1","group1":{"ARG2":"with2","SECRET":"hidden","ARG1":"main1"}}`,
+			expected2:  `{"level":"INFO","msg":"main message","arg1":"with1","group1":{"arg2":"with2","arg1":"main1"}}`,
+		},
+		{
+			name:       "increment",
+			middleware: NewIncrementMiddleware(&IncrementHandlerOptions{CommonOptions: CommonOptions{TextOrder: true}}),
+			expected1:  `{"level":"INFO","msg":"main message","ARG1":"with1","group1":{"ARG2":"with2","SECRET":"hidden","ARG2#01":"main2","ARG1":"main1"}}`,
+			expected2:  `{"level":"INFO","msg":"main message","arg1":"with1","group1":{"arg2":"with2","arg2#01":"main2","arg1":"main1"}}`,
+		},
+		{
+			name:       "append",
+			middleware: NewAppendMiddleware(&AppendHandlerOptions{CommonOptions: CommonOptions{TextOrder: true, CacheWithAttrs: true}}),
+			expected1:  `{"level":"INFO","msg":"main message","ARG1":"with1","group1":{"ARG2":["with2","main2"],"SECRET":"hidden","ARG1":"main1"}}`,
+			expected2:  `{"level":"INFO","msg":"main message","arg1":"with1","group1":{"arg2":["with2","main2"],"arg1":"main1"}}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		// Each branch has its own downstream ReplaceAttr, but the same middleware and options
+		buf1, buf2 := &bytes.Buffer{}, &bytes.Buffer{}
+		upper := func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			if len(groups) > 0 || (a.Key != slog.LevelKey && a.Key != slog.MessageKey) {
+				a.Key = strings.ToUpper(a.Key)
+			}
+			return a
+		}
+		redact := func(groups []string, a slog.Attr) slog.Attr {
+			if (a.Key == slog.TimeKey && len(groups) == 0) || a.Key == "secret" {
+				return slog.Attr{}
+			}
+			return a
+		}
+
+		log := slog.New(fanoutHandler{
+			testCase.middleware(slog.NewJSONHandler(buf1, &slog.HandlerOptions{ReplaceAttr: upper})),
+			testCase.middleware(slog.NewJSONHandler(buf2, &slog.HandlerOptions{ReplaceAttr: redact})),
+		})
+		log = log.With("arg1", "with1").WithGroup("group1").With("arg2", "with2", "secret", "hidden")
+
+		// Log twice, to make sure neither branch's groups and attributes were changed by the other
+		for i := 0; i < 2; i++ {
+			buf1.Reset()
+			buf2.Reset()
+			log.Info("main message", "arg2", "main2", "arg1", "main1")
+
+			if s := strings.TrimSpace(buf1.String()); s != testCase.expected1 {
+				t.Errorf("%s %d Expected:\n%s\nGot:\n%s", testCase.name, i, testCase.expected1, s)
+			}
+			if s := strings.TrimSpace(buf2.String()); s != testCase.expected2 {
+				t.Errorf("%s %d Expected:\n%s\nGot:\n%s", testCase.name, i, testCase.expected2, s)
+			}
+		}
+	}
+	// The shared options must not be modified
+	opts := &OverwriteHandlerOptions{CommonOptions: CommonOptions{TextOrder: true}}
+	NewOverwriteMiddleware(opts)(nil)
+	if opts.KeyCompare != nil || opts.ResolveKey != nil || opts.OrderMode != OrderSorted {
+		t.Errorf("Options were modified: %+v", opts)
+	}
+}
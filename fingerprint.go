@@ -0,0 +1,73 @@
+package slogdedup
+
+import (
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"log/slog"
+)
+
+// fingerprinter adds a fingerprint of the deduplicated record to the record.
+type fingerprinter struct {
+	key    string
+	hasher func() hash.Hash64
+}
+
+// newFingerprinter returns a fingerprinter, or nil if the key is empty.
+// The hasher defaults to 64-bit FNV-1a if nil.
+func newFingerprinter(key string, hasher func() hash.Hash64) *fingerprinter {
+	if key == "" {
+		return nil
+	}
+	if hasher == nil {
+		hasher = fnv.New64a
+	}
+	return &fingerprinter{key: key, hasher: hasher}
+}
+
+// add puts the fingerprint of the level, message, and the attributes in the
+// tree into the root of the tree, replacing any attribute or group with the
+// same key. Safe to call on a nil fingerprinter.
+func (f *fingerprinter) add(state *handleState, uniq attrStore, r slog.Record) {
+	if f == nil {
+		return
+	}
+	uniq.Delete(f.key)
+
+	h := f.hasher()
+	fmt.Fprintf(h, "%s\x00%s\x00", r.Level, r.Message)
+	writeTreeHash(h, uniq)
+
+	uniq.Set(f.key, slog.String(f.key, fmt.Sprintf("%016x", h.Sum64())))
+	state.order.touch(uniq, f.key)
+}
+
+// writeTreeHash writes all keys and values in the tree to the hash, in sorted
+// order, so that the hash does not depend on the OrderMode.
+func writeTreeHash(h hash.Hash64, uniq attrStore) {
+	uniq.Range(func(k string, i any) bool {
+		fmt.Fprintf(h, "%s=", k)
+		writeValueHash(h, i)
+		fmt.Fprint(h, "\x00")
+		return true
+	})
+}
+
+// writeValueHash writes a single value from a tree to the hash
+func writeValueHash(h hash.Hash64, v any) {
+	switch v := v.(type) {
+	case slog.Attr:
+		fmt.Fprint(h, v.Value.String())
+	case attrStore:
+		fmt.Fprint(h, "{")
+		writeTreeHash(h, v)
+		fmt.Fprint(h, "}")
+	case appended:
+		fmt.Fprint(h, "[")
+		for _, sliceVal := range v {
+			writeValueHash(h, sliceVal)
+			fmt.Fprint(h, "\x00")
+		}
+		fmt.Fprint(h, "]")
+	}
+}
@@ -0,0 +1,90 @@
+package slogdedup
+
+import (
+	"context"
+	"hash"
+	"hash/crc64"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+func crc64Hasher() hash.Hash64 {
+	return crc64.New(crc64.MakeTable(crc64.ECMA))
+}
+
+func TestFingerprintKey(t *testing.T) {
+	t.Parallel()
+
+	fingerprint := func(tester *testHandler) string {
+		var fp string
+		tester.Record.Attrs(func(a slog.Attr) bool {
+			if a.Key == "fp" {
+				fp = a.Value.String()
+			}
+			return true
+		})
+		return fp
+	}
+
+	for _, hasher := range []func() hash.Hash64{nil, fnv.New64a, crc64Hasher} {
+		var fps []string
+		for _, orderMode := range []OrderMode{OrderSorted, OrderInsertion} {
+			tester := &testHandler{}
+			log := slog.New(NewOverwriteHandler(tester, &OverwriteHandlerOptions{
+				CommonOptions: CommonOptions{
+					FingerprintKey: "fp",
+					Hasher:         hasher,
+					SequenceKey:    "seq",
+					OrderMode:      orderMode,
+				},
+			})).With("arg1", "val1", "fp", "user")
+
+			// The same record, logged in a different order, with overwritten duplicates
+			log.Info("main message", "arg2", "val2", slog.Group("group1", "arg3", 3))
+			fps = append(fps, fingerprint(tester))
+			log.Info("main message", slog.Group("group1", "arg3", 3), "arg2", "overwritten", "arg2", "val2")
+			fps = append(fps, fingerprint(tester))
+
+			// Different records
+			log.Info("other message", "arg2", "val2", slog.Group("group1", "arg3", 3))
+			fps = append(fps, fingerprint(tester))
+			log.Warn("main message", "arg2", "val2", slog.Group("group1", "arg3", 3))
+			fps = append(fps, fingerprint(tester))
+			log.Info("main message", "arg2", "val2", slog.Group("group1", "arg3", "3"), "arg4", "val4")
+			fps = append(fps, fingerprint(tester))
+		}
+
+		if len(fps[0]) != 16 {
+			t.Errorf("Expected a 16 character hex fingerprint; Got: %q", fps[0])
+		}
+		for i := 1; i < len(fps); i++ {
+			if same := fps[i] == fps[0]; same != (i%5 < 2) {
+				t.Errorf("Fingerprint %d (%s) compared to %s; Expected same: %t", i, fps[i], fps[0], !same)
+			}
+		}
+	}
+}
+
+func BenchmarkFingerprintKey(b *testing.B) {
+	for _, hasher := range []struct {
+		name   string
+		hasher func() hash.Hash64
+	}{
+		{name: "fnv64a", hasher: fnv.New64a},
+		{name: "crc64", hasher: crc64Hasher},
+	} {
+		b.Run(hasher.name, func(b *testing.B) {
+			log := slog.New(NewOverwriteHandler(slog.NewJSONHandler(io.Discard, nil), &OverwriteHandlerOptions{CommonOptions: CommonOptions{FingerprintKey: "fp", Hasher: hasher.hasher}}))
+			log = log.With("arg1", "val1", "arg2", 2, slog.Group("group1", "arg3", true, "arg4", 4.5))
+
+			ctx := context.Background()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				log.InfoContext(ctx, "main message", "arg5", "val5", "arg6", "val6")
+			}
+		})
+	}
+}
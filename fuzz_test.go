@@ -0,0 +1,154 @@
+package slogdedup
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// fuzzKeys are the keys used by fuzzAttrs, chosen to collide with each other,
+// with the builtins, and with incremented and disambiguated keys.
+var fuzzKeys = []string{"", "a", "b", "a#01", "a#group", "msg", "time", "level", "source", "_truncated"}
+
+// fuzzValuer is a LogValuer that resolves to its value, which can be a group
+// or another valuer.
+type fuzzValuer struct {
+	v slog.Value
+}
+
+func (v fuzzValuer) LogValue() slog.Value {
+	return v.v
+}
+
+// fuzzAttrs decodes the bytes into a slice of attributes, with nested groups.
+// Each byte is an instruction, and some instructions use the following byte as
+// the key. It is not meant to be efficient, only to cover unusual structures.
+func fuzzAttrs(data []byte, depth int) (attrs []slog.Attr, rest []byte) {
+	key := func() string {
+		if len(data) == 0 {
+			return "a"
+		}
+		k := fuzzKeys[int(data[0])%len(fuzzKeys)]
+		data = data[1:]
+		return k
+	}
+
+	for len(data) > 0 {
+		op := data[0]
+		data = data[1:]
+		switch op % 10 {
+		case 0:
+			attrs = append(attrs, slog.String(key(), "str"))
+		case 1:
+			attrs = append(attrs, slog.Int(key(), int(op)))
+		case 2:
+			attrs = append(attrs, slog.Bool(key(), op%2 == 0))
+		case 3:
+			attrs = append(attrs, slog.Any(key(), nil))
+		case 4:
+			// The remaining kinds, and a valuer that resolves to the zero value
+			values := []slog.Value{slog.Float64Value(float64(op)), slog.Uint64Value(uint64(op)),
+				slog.DurationValue(time.Duration(op)), slog.TimeValue(time.Unix(int64(op), 0)),
+				slog.AnyValue([]byte{op}), slog.AnyValue(fuzzValuer{})}
+			attrs = append(attrs, slog.Attr{Key: key(), Value: values[int(op/10)%len(values)]})
+		case 5:
+			attrs = append(attrs, slog.Group(key())) // Empty group
+		case 6:
+			// Open a group, until the matching close instruction
+			k := key()
+			if depth >= 5 {
+				continue
+			}
+			var group []slog.Attr
+			group, data = fuzzAttrs(data, depth+1)
+			attrs = append(attrs, slog.Attr{Key: k, Value: slog.GroupValue(group...)})
+		case 7:
+			if depth > 0 {
+				return attrs, data // Close the group
+			}
+		case 8:
+			// A valuer that resolves to a group, or to another valuer
+			k := key()
+			if op%2 == 0 {
+				attrs = append(attrs, slog.Any(k, fuzzValuer{slog.GroupValue(slog.String(key(), "valuer"))}))
+			} else {
+				attrs = append(attrs, slog.Any(k, fuzzValuer{slog.AnyValue(fuzzValuer{slog.StringValue("valuer")})}))
+			}
+		case 9:
+			attrs = append(attrs, slog.Attr{}) // Empty attribute
+		}
+	}
+	return attrs, data
+}
+
+// fuzzHandlers returns constructors for each handler, with a variety of
+// options that change how the tree is built.
+func fuzzHandlers() map[string]func(next slog.Handler) slog.Handler {
+	return map[string]func(next slog.Handler) slog.Handler{
+		"overwrite": func(next slog.Handler) slog.Handler {
+			return NewOverwriteHandler(next, nil)
+		},
+		"overwrite-options": func(next slog.Handler) slog.Handler {
+			return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{OrderMode: OrderInsertion, UnifyGroupSources: true, CacheWithAttrs: true, ShortCircuitUnique: true}, TypeDisambiguate: true, WarnOnGroupLoss: true})
+		},
+		"ignore": func(next slog.Handler) slog.Handler {
+			return NewIgnoreHandler(next, nil)
+		},
+		"ignore-options": func(next slog.Handler) slog.Handler {
+			return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{OrderMode: OrderInsertionStableDedup, MaxNodes: 20, InlineCollisionMode: DedupIncrement}, CollectAllKey: "_all"})
+		},
+		"increment": func(next slog.Handler) slog.Handler {
+			return NewIncrementHandler(next, nil)
+		},
+		"increment-options": func(next slog.Handler) slog.Handler {
+			return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{UnifyGroupSources: true, CacheWithAttrs: true, CollapseSingletonGroups: true}, ReserveIncrementSuffix: true})
+		},
+		"append": func(next slog.Handler) slog.Handler {
+			return NewAppendHandler(next, nil)
+		},
+		"append-options": func(next slog.Handler) slog.Handler {
+			return NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{UnifyGroupSources: true, RootCollisionMode: DedupOverwrite, ShortCircuitUnique: true}, TypeDisambiguate: true})
+		},
+	}
+}
+
+func FuzzHandlers(f *testing.F) {
+	f.Add([]byte{}, []byte{})
+	f.Add([]byte{0, 1, 0, 1}, []byte{1, 1, 6, 1, 0, 1, 7, 0, 2})
+	f.Add([]byte{6, 0, 0, 1, 7}, []byte{6, 0, 0, 1, 7, 6, 1, 5, 2, 7, 0, 1})
+	f.Add([]byte{8, 1, 1, 9, 5, 4}, []byte{0, 5, 0, 4, 6, 4, 0, 1, 7, 0, 3, 6, 3, 8, 1, 2})
+	f.Add([]byte{5, 1, 3, 1}, []byte{6, 1, 6, 1, 6, 1, 0, 2, 7, 7, 7, 18, 1, 1})
+
+	f.Fuzz(func(t *testing.T, withData, recordData []byte) {
+		withAttrs, _ := fuzzAttrs(withData, 0)
+		recordAttrs, _ := fuzzAttrs(recordData, 0)
+
+		var groupName string
+		if len(withData) > 0 {
+			groupName = fuzzKeys[int(withData[0])%len(fuzzKeys)]
+		}
+
+		for name, handler := range fuzzHandlers() {
+			tester := &testHandler{}
+			log := slog.New(handler(tester)).With(attrsToArgs(withAttrs)...)
+			if groupName != "" {
+				log = log.WithGroup(groupName).With(attrsToArgs(withAttrs)...)
+			}
+			log.Info("fuzz", attrsToArgs(recordAttrs)...)
+
+			if _, err := tester.MarshalJSON(); err != nil {
+				t.Errorf("%s unable to marshal json: %v", name, err)
+			}
+			checkRecordForDuplicates(t, tester.Record)
+		}
+	})
+}
+
+// attrsToArgs converts the attributes into arguments for a logger method
+func attrsToArgs(attrs []slog.Attr) []any {
+	args := make([]any, len(attrs))
+	for i, a := range attrs {
+		args[i] = a
+	}
+	return args
+}
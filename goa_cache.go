@@ -0,0 +1,132 @@
+package slogdedup
+
+import (
+	"slices"
+	"sync"
+)
+
+// openGroup is a group that was opened with WithGroup, whose subtree is put
+// into its parent only after the record's attributes have been added, so that
+// empty groups can still be ignored.
+type openGroup struct {
+	parent attrStore
+	uniq   attrStore
+	key    string
+	groups []string // Groups leading to and including this group
+	merged bool     // True if uniq is already in the parent
+}
+
+// goaTemplate is the attribute tree of a handler's groups and with-attributes,
+// before any record's attributes have been added.
+type goaTemplate struct {
+	state *handleState
+	uniq  attrStore
+}
+
+// goaCache lazily creates and holds the goaTemplate for a handler.
+// Each call to WithAttrs or WithGroup must create a new goaCache.
+type goaCache struct {
+	once     sync.Once
+	template *goaTemplate
+}
+
+// newGoaCache returns a new goaCache, or nil if caching is not enabled.
+func newGoaCache(enabled bool) *goaCache {
+	if !enabled {
+		return nil
+	}
+	return &goaCache{}
+}
+
+// load returns the template, creating it with create the first time.
+func (c *goaCache) load(create func() *goaTemplate) *goaTemplate {
+	c.once.Do(func() {
+		c.template = create()
+	})
+	return c.template
+}
+
+// clone returns a deep copy of the template's state and tree, which can then
+// have a record's attributes added to it. The innermost open group, or the
+// root tree if there are none, is where the record's attributes belong.
+func (t *goaTemplate) clone(keyCompareFor func(groups []string) func(a, b string) int) (*handleState, attrStore) {
+	clones := map[attrStore]attrStore{}
+	uniq := cloneTree(t.uniq, keyCompareFor, nil, clones)
+
+	state := &handleState{
+		err:        t.state.err,
+		dropped:    t.state.dropped,
+		collisions: slices.Clone(t.state.collisions),
+		nodes:      t.state.nodes,
+		truncated:  t.state.truncated,
+		groupDepth: t.state.groupDepth,
+		openGroups: make([]openGroup, len(t.state.openGroups)),
+	}
+	for i, og := range t.state.openGroups {
+		og.parent = clones[og.parent]
+		og.uniq = cloneTree(og.uniq, keyCompareFor, og.groups, clones)
+		state.openGroups[i] = og
+	}
+	state.order = t.state.order.clone(clones)
+	return state, uniq
+}
+
+// deferGroup adds the group to the open groups and returns true, if deferring
+// groups. Otherwise it returns false.
+func (s *handleState) deferGroup(parent, uniq attrStore, key string, groups []string, merged bool) bool {
+	if !s.deferGroups {
+		return false
+	}
+	s.openGroups = append(s.openGroups, openGroup{parent: parent, uniq: uniq, key: key, groups: groups, merged: merged})
+	return true
+}
+
+// innermost returns the tree and groups that the record's attributes belong in.
+func (s *handleState) innermost(uniq attrStore) (attrStore, []string) {
+	if len(s.openGroups) == 0 {
+		return uniq, nil
+	}
+	og := s.openGroups[len(s.openGroups)-1]
+	return og.uniq, og.groups
+}
+
+// closeGroups puts each non-empty open group into its parent, from the
+// innermost group to the outermost, using putGroup.
+func (s *handleState) closeGroups(putGroup func(state *handleState, uniq attrStore, groups []string, key string, uniqGroup attrStore)) {
+	for i := len(s.openGroups) - 1; i >= 0; i-- {
+		og := s.openGroups[i]
+		if !og.merged && og.uniq.Len() > 0 {
+			putGroup(s, og.parent, og.groups[:len(og.groups)-1], og.key, og.uniq)
+		}
+	}
+}
+
+// cloneTree returns a deep copy of the tree, with all subtrees also copied.
+// Clones are recorded so that each subtree is only copied once.
+func cloneTree(uniq attrStore, keyCompareFor func(groups []string) func(a, b string) int, groups []string, clones map[attrStore]attrStore) attrStore {
+	if c, ok := clones[uniq]; ok {
+		return c
+	}
+	c := uniq.New(keyCompareFor(groups))
+	clones[uniq] = c
+
+	uniq.Range(func(k string, i any) bool {
+		switch v := i.(type) {
+		case attrStore:
+			c.Set(k, cloneTree(v, keyCompareFor, append(slices.Clip(groups), k), clones))
+		case appended:
+			slice := make(appended, len(v))
+			for j, sliceVal := range v {
+				if subtree, ok := sliceVal.(attrStore); ok {
+					sliceVal = cloneTree(subtree, keyCompareFor, append(slices.Clip(groups), k), clones)
+				}
+				slice[j] = sliceVal
+			}
+			c.Set(k, slice)
+		default:
+			c.Set(k, v)
+		}
+		return true
+	})
+	return c
+}
@@ -0,0 +1,111 @@
+package slogdedup
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// cacheTestHandlers returns constructors for each handler with a variety of
+// options, which create the handler with and without CacheWithAttrs.
+func cacheTestHandlers() map[string]func(next slog.Handler, cache bool) slog.Handler {
+	return map[string]func(next slog.Handler, cache bool) slog.Handler{
+		"overwrite": func(next slog.Handler, cache bool) slog.Handler {
+			return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{CacheWithAttrs: cache}})
+		},
+		"overwrite-insertion-unify": func(next slog.Handler, cache bool) slog.Handler {
+			return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{CacheWithAttrs: cache, OrderMode: OrderInsertion, UnifyGroupSources: true}})
+		},
+		"overwrite-allow": func(next slog.Handler, cache bool) slog.Handler {
+			return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{CacheWithAttrs: cache}, AllowKeys: []string{"arg1", "group1.arg3", "group1.main1group3"}})
+		},
+		"ignore": func(next slog.Handler, cache bool) slog.Handler {
+			return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{CacheWithAttrs: cache}})
+		},
+		"ignore-stable-collect": func(next slog.Handler, cache bool) slog.Handler {
+			return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{CacheWithAttrs: cache, OrderMode: OrderInsertionStableDedup}, CollectAllKey: "_all"})
+		},
+		"increment": func(next slog.Handler, cache bool) slog.Handler {
+			return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{CacheWithAttrs: cache}})
+		},
+		"increment-unify": func(next slog.Handler, cache bool) slog.Handler {
+			return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{CacheWithAttrs: cache, UnifyGroupSources: true}})
+		},
+		"append": func(next slog.Handler, cache bool) slog.Handler {
+			return NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{CacheWithAttrs: cache}})
+		},
+		"append-insertion-unify": func(next slog.Handler, cache bool) slog.Handler {
+			return NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{CacheWithAttrs: cache, OrderMode: OrderInsertion, UnifyGroupSources: true}})
+		},
+		"append-root-increment": func(next slog.Handler, cache bool) slog.Handler {
+			return NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{CacheWithAttrs: cache, RootCollisionMode: DedupIncrement}})
+		},
+	}
+}
+
+func TestCacheWithAttrs(t *testing.T) {
+	t.Parallel()
+
+	for name, handler := range cacheTestHandlers() {
+		var expected, got []string
+		for _, cache := range []bool{false, true} {
+			tester := &testHandler{}
+			log := slog.New(handler(tester, cache)).With("arg1", "with1", slog.Group("group1", "arg1", "inline"), "group2", "with1")
+			log2 := log.WithGroup("group1").With("arg2", "with2", "arg1", "with2")
+			log3 := log2.WithGroup("group2")
+
+			// Log multiple times from each logger, to make sure the cached tree is not modified
+			var outputs []string
+			for i := 0; i < 2; i++ {
+				log.Info("main message", "arg1", "main1")
+				outputs = append(outputs, tester.String())
+				log2.Info("main message", "arg2", "main2", slog.Group("group2", "arg1", "main2"))
+				outputs = append(outputs, tester.String())
+				log3.Info("main message", "arg3", "main3")
+				outputs = append(outputs, tester.String())
+				log3.Info("main message")
+				outputs = append(outputs, tester.String())
+			}
+
+			logComplex(t, handler(tester, cache))
+			outputs = append(outputs, tester.String())
+
+			if cache {
+				got = outputs
+			} else {
+				expected = outputs
+			}
+		}
+
+		for i := range expected {
+			if got[i] != expected[i] {
+				t.Errorf("%s %d Expected:\n%s\nGot:\n%s", name, i, expected[i], got[i])
+			}
+		}
+	}
+}
+
+func BenchmarkCacheWithAttrs(b *testing.B) {
+	for _, cache := range []bool{false, true} {
+		name := "uncached"
+		if cache {
+			name = "cached"
+		}
+		b.Run(name, func(b *testing.B) {
+			log := slog.New(NewOverwriteHandler(slog.NewJSONHandler(io.Discard, nil), &OverwriteHandlerOptions{CommonOptions: CommonOptions{CacheWithAttrs: cache}}))
+			for i := 0; i < 20; i++ {
+				log = log.With(strings.Repeat("k", i+1), i, "arg1", i, slog.Group("group1", "arg1", i, "arg2", i))
+			}
+			log = log.WithGroup("request").With("id", "abc123")
+
+			ctx := context.Background()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				log.InfoContext(ctx, "main message", "arg1", i, "arg2", "val2")
+			}
+		})
+	}
+}
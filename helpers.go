@@ -1,11 +1,16 @@
 package slogdedup
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"strings"
-
-	"modernc.org/b/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
 )
 
 // IncrementIfBuiltinKeyConflict is a ResolveKey function that will, if there is
@@ -35,6 +40,20 @@ func KeepIfBuiltinKeyConflict(_ []string, key string, index int) (string, bool)
 	return incrementKeyName(key, index), true // Keep all
 }
 
+// PrefixRootKeys returns a ResolveKey function that prepends the prefix to
+// every root level (not in a group) attribute and group key, such as a tenant
+// id, leaving the keys inside of groups unchanged. Like
+// IncrementIfBuiltinKeyConflict, if a prefixed key would still conflict with
+// one of the built-in keys, "#01" is added to the end of it.
+func PrefixRootKeys(prefix string) func(groups []string, key string, index int) (string, bool) {
+	return func(groups []string, key string, index int) (string, bool) {
+		if len(groups) == 0 {
+			key = prefix + key
+		}
+		return IncrementIfBuiltinKeyConflict(groups, key, index)
+	}
+}
+
 // doesBuiltinKeyConflict returns true if the key conflicts with the builtin keys.
 // This will only be called on all root level (not in a group) attribute keys.
 func doesBuiltinKeyConflict(key string) bool {
@@ -44,13 +63,38 @@ func doesBuiltinKeyConflict(key string) bool {
 	return false
 }
 
+// ErrBuiltinKeyConflict is returned by Handle when strict builtins are enabled
+// and a root level attribute or group key conflicts with a builtin key.
+var ErrBuiltinKeyConflict = errors.New("slogdedup: key conflicts with a builtin key")
+
 // incrementKeyName adds a count onto the key name after the first seen.
 // Example: keyname, keyname#01, keyname#02, keyname#03
 func incrementKeyName(key string, index int) string {
 	if index == 0 {
 		return key
 	}
-	return fmt.Sprintf("%s#%02d", key, index)
+	return formatIncrement(key, index)
+}
+
+// formatIncrement adds the increment suffix with the number onto the key name.
+// Example: keyname#00, keyname#01
+func formatIncrement(key string, number int) string {
+	return fmt.Sprintf("%s#%02d", key, number)
+}
+
+// hasIncrementSuffix returns true if the key ends with a suffix that could have
+// been added by incrementKeyName, such as "#01".
+func hasIncrementSuffix(key string) bool {
+	i := strings.LastIndexByte(key, '#')
+	if i < 0 || len(key)-i-1 < 2 {
+		return false
+	}
+	for _, c := range key[i+1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
 }
 
 // CaseSensitiveCmp is a case-sensitive comparison and ordering function that orders by byte values
@@ -77,29 +121,326 @@ func CaseInsensitiveCmp(a, b string) int {
 	return -1
 }
 
+// AlphanumericCmp is a comparison and ordering function that compares keys
+// after removing all characters that are not letters or digits, and lowercasing
+// them, so that keys such as "user-id", "user_id", and "userId" are the same key.
+// The form of the key that is output is the first one seen, except with the
+// OverwriteHandler, which outputs the last attribute along with its key.
+// It orders by the byte values of the stripped keys.
+func AlphanumericCmp(a, b string) int {
+	return CaseSensitiveCmp(alphanumericKey(a), alphanumericKey(b))
+}
+
+// alphanumericKey returns the key lowercased, with all characters that are
+// not letters or digits removed.
+func alphanumericKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, key)
+}
+
 // appended is a type that exists to allow us to differentiate between a log attribute that is a slice or any's ([]any),
 // versus when we are appending to the key so that it becomes a slice. Only used with the AppendHandler.
 type appended []any
 
+// subtreeFor returns a new subtree to hold the attributes of a group with the key.
+// If unify is true and the key already holds a subtree, that existing subtree is
+// returned instead, along with true, so that the group's attributes are merged into it.
+// If the key holds a slice of appended values, the last subtree in it is used.
+func subtreeFor(uniq attrStore, key string, keyCompare func(a, b string) int, unify bool) (attrStore, bool) {
+	if unify {
+		if v, ok := uniq.Get(key); ok {
+			switch existing := v.(type) {
+			case attrStore:
+				return existing, true
+			case appended:
+				for i := len(existing) - 1; i >= 0; i-- {
+					if subtree, ok := existing[i].(attrStore); ok {
+						return subtree, true
+					}
+				}
+			}
+		}
+	}
+	return uniq.New(keyCompare), false
+}
+
+// GroupKeySuffix is appended to the key of a group that has the same key as
+// an attribute that is not a group, when TypeDisambiguate is enabled.
+const GroupKeySuffix = "#group"
+
+// isGroupValue returns true if the value in the tree is a subtree, or is a
+// slice of appended subtrees.
+func isGroupValue(v any) bool {
+	switch val := v.(type) {
+	case attrStore:
+		return true
+	case appended:
+		if len(val) > 0 {
+			_, ok := val[0].(attrStore)
+			return ok
+		}
+	}
+	return false
+}
+
+// disambiguateGroupKey returns the key to put a group under, which is the key
+// with GroupKeySuffix if the key already holds an attribute that is not a
+// group, otherwise the key itself.
+func disambiguateGroupKey(uniq attrStore, key string) string {
+	if existing, ok := uniq.Get(key); ok && !isGroupValue(existing) {
+		return key + GroupKeySuffix
+	}
+	return key
+}
+
+// moveGroupAside moves the group held by the key, if any, to the key with
+// GroupKeySuffix, so that an attribute that is not a group can be put under
+// the key without colliding with it. Does nothing if the suffixed key is
+// already taken.
+func moveGroupAside(state *handleState, uniq attrStore, key string) {
+	existing, ok := uniq.Get(key)
+	if !ok || !isGroupValue(existing) {
+		return
+	}
+	if _, taken := uniq.Get(key + GroupKeySuffix); taken {
+		return
+	}
+	uniq.Delete(key)
+	uniq.Set(key+GroupKeySuffix, existing)
+	state.order.touch(uniq, key+GroupKeySuffix)
+}
+
+// handleState holds any state that is local to a single call to Handle,
+// and is passed down while creating the attribute tree.
+type handleState struct {
+	order *attrOrder // nil if sorted
+	err   error      // first error encountered, if any
+
+	// If deferGroups is true, groups opened with WithGroup are collected in
+	// openGroups instead of being put into their parent, so that the tree can
+	// be cached before the record's attributes are added.
+	deferGroups bool
+	openGroups  []openGroup
+	dropped     bool // true if the record's attributes are to be dropped
+
+	collisions []collision // keys that collided, if tracing or debugging
+
+	nodes     int  // number of attributes and groups processed
+	truncated bool // true if nodes exceeded the maximum
+
+	groupDepth int // deepest number of groups opened with WithGroup
+
+	inlineLevel int // one more than the group depth of the inlined group being resolved, or 0 if none
+}
+
+// TruncatedKey is the key of the attribute added to the root level of a record
+// when the MaxNodes option is exceeded.
+const TruncatedKey = "_truncated"
+
+// visit counts an attribute or group as processed, and returns false if the
+// count exceeds maxNodes, when maxNodes is positive.
+func (s *handleState) visit(maxNodes int) bool {
+	if maxNodes <= 0 {
+		return true
+	}
+	s.nodes++
+	if s.nodes > maxNodes {
+		s.truncated = true
+		return false
+	}
+	return true
+}
+
+// openGroup records that a group was opened with WithGroup, at the depth.
+func (s *handleState) openGroup(depth int) {
+	s.groupDepth = max(s.groupDepth, depth)
+}
+
+// addGroupDepth puts the deepest number of groups opened with WithGroup into
+// the root of the tree under the key, replacing any attribute or group with
+// the same key. Does nothing if the key is empty.
+func (s *handleState) addGroupDepth(uniq attrStore, key string) {
+	if key == "" {
+		return
+	}
+	uniq.Set(key, slog.Int(key, s.groupDepth))
+	s.order.touch(uniq, key)
+}
+
+// addTruncated adds the truncated attribute to the root of the tree, if the
+// maximum number of nodes was exceeded.
+func (s *handleState) addTruncated(uniq attrStore) {
+	if !s.truncated {
+		return
+	}
+	uniq.Set(TruncatedKey, slog.Bool(TruncatedKey, true))
+	s.order.touch(uniq, TruncatedKey)
+}
+
+// newAttrTree returns a new handleState and an empty tree for the root level,
+// created by newStore
+func newAttrTree(newStore func(keyCompare func(a, b string) int) attrStore, orderMode OrderMode, keyCompare func(a, b string) int, keyOrder func(a, b string) int) (*handleState, attrStore) {
+	return &handleState{order: newAttrOrder(orderMode, keyCompare, keyOrder)}, newStore(keyCompare)
+}
+
+// estimateTreeSize returns the sum of the lengths of all keys and values in
+// the tree, with the values as strings.
+func estimateTreeSize(uniq attrStore) int {
+	var size int
+	uniq.Range(func(k string, i any) bool {
+		size += len(k) + estimateValueSize(i)
+		return true
+	})
+	return size
+}
+
+// estimateValueSize returns the length of a single value from a tree as a string.
+func estimateValueSize(v any) int {
+	switch v := v.(type) {
+	case slog.Attr:
+		return len(v.Value.String())
+	case attrStore:
+		return estimateTreeSize(v)
+	case appended:
+		var size int
+		for _, sliceVal := range v {
+			size += estimateValueSize(sliceVal)
+		}
+		return size
+	default:
+		return 0
+	}
+}
+
+// countAttrs returns the number of attributes, including those inside of
+// groups, but not including the groups themselves.
+func countAttrs(attrs []slog.Attr) int {
+	var count int
+	for _, a := range attrs {
+		if a.Value.Kind() == slog.KindGroup {
+			count += countAttrs(a.Value.Group())
+		} else {
+			count++
+		}
+	}
+	return count
+}
+
+// resolveMessage passes the message through resolveKey as if it were a root
+// level attribute with the "msg" key. It returns the message to keep on the
+// record, and the attribute the message was relocated to, if any. The message
+// is dropped if resolveKey drops the key, and kept on the record if the key is
+// unchanged or if an attribute already has the resolved key.
+func resolveMessage(uniq attrStore, resolveKey func(groups []string, key string, index int) (string, bool), msg string) (string, []slog.Attr) {
+	key, keep := resolveKey(nil, slog.MessageKey, 0)
+	if !keep {
+		return "", nil
+	}
+	if key == slog.MessageKey || key == "" {
+		return msg, nil
+	}
+	if _, exists := uniq.Get(key); exists {
+		return msg, nil
+	}
+	return "", []slog.Attr{slog.String(key, msg)}
+}
+
+// resolveKeyIn resolves an attribute key or a group name within the group path.
+// All handlers resolve attribute keys and group names through this function,
+// whether the group came from WithGroup or slog.Group, so that ResolveKey is
+// called the same way for both. The InlineCollisionMode or RootCollisionMode
+// collider is used if either applies. Otherwise, if resolveIncrementKey is not
+// nil (only for the IncrementHandler), it increments the index until the key is
+// unused, or else ResolveKey is called with an index of 0.
+func resolveKeyIn(state *handleState, uniq attrStore, groups []string, key string, root, inline *collider, resolveKey func(groups []string, key string, index int) (string, bool), resolveIncrementKey func(uniq attrStore, groups []string, key string) (string, bool)) (string, bool) {
+	if c := state.colliderFor(groups, root, inline); c != nil {
+		return c.resolve(uniq, groups, key)
+	}
+	if resolveIncrementKey != nil {
+		return resolveIncrementKey(uniq, groups, key)
+	}
+	return resolveKey(groups, key, 0)
+}
+
+// newCollapseSeparator returns the separator to collapse singleton groups with,
+// defaulting to a dot, or an empty string if not enabled.
+func newCollapseSeparator(enabled bool, sep string) string {
+	if !enabled {
+		return ""
+	}
+	if sep == "" {
+		return "."
+	}
+	return sep
+}
+
+// collapseSingletonGroups rewrites each group with exactly one attribute into
+// that attribute, with the group key and attribute key joined by the separator,
+// such as "req.id". Nested groups are collapsed first, so that a chain of
+// singleton groups becomes a single key. A group is left alone if another
+// attribute at the same level already has the collapsed key.
+// The attributes must already be deduplicated. The slice is modified in place.
+func collapseSingletonGroups(attrs []slog.Attr, sep string) []slog.Attr {
+	keys := make(map[string]struct{}, len(attrs))
+	for _, a := range attrs {
+		keys[a.Key] = struct{}{}
+	}
+	for i, a := range attrs {
+		if a.Value.Kind() != slog.KindGroup {
+			continue
+		}
+		group := collapseSingletonGroups(a.Value.Group(), sep)
+		if len(group) != 1 {
+			attrs[i] = slog.Attr{Key: a.Key, Value: slog.GroupValue(group...)}
+			continue
+		}
+		collapsedKey := a.Key + sep + group[0].Key
+		if _, exists := keys[collapsedKey]; exists {
+			attrs[i] = slog.Attr{Key: a.Key, Value: slog.GroupValue(group...)}
+			continue
+		}
+		keys[collapsedKey] = struct{}{}
+		attrs[i] = slog.Attr{Key: collapsedKey, Value: group[0].Value}
+	}
+	return attrs
+}
+
 // buildAttrs converts the deduplicated map back into an attribute array,
-// with any subtrees converted into slog.Group's
-func buildAttrs(uniq *b.Tree[string, any]) []slog.Attr {
-	en, emptyErr := uniq.SeekFirst()
-	if emptyErr != nil {
-		return nil // Empty (btree only returns an error when empty)
+// with any subtrees converted into slog.Group's.
+// If order is not nil, the attributes are put into insertion order.
+func buildAttrs(uniq attrStore, order *attrOrder, groupFormat AppendedGroupFormat) []slog.Attr {
+	return buildAttrsIn(uniq, order, groupFormat, false, false)
+}
+
+// buildAppendedAttrs is buildAttrs for the AppendHandler. If sortSlices is
+// true, the slices of appended values nested within appended groups are sorted
+// by compareValues. Only the slices built here are sorted, never the values
+// that were logged, which may belong to the user.
+func buildAppendedAttrs(uniq attrStore, order *attrOrder, groupFormat AppendedGroupFormat, sortSlices bool) []slog.Attr {
+	return buildAttrsIn(uniq, order, groupFormat, sortSlices, false)
+}
+
+// buildAttrsIn builds the attributes of the level of the tree, with inAppended
+// true if the level is within a group that has been appended into a slice.
+func buildAttrsIn(uniq attrStore, order *attrOrder, groupFormat AppendedGroupFormat, sortSlices, inAppended bool) []slog.Attr {
+	if uniq.Len() == 0 {
+		return nil
 	}
-	defer en.Close()
 
 	// Iterate through all values in the map, add to slice
 	attrs := make([]slog.Attr, 0, uniq.Len())
-	for k, i, err := en.Next(); err == nil; k, i, err = en.Next() {
+	uniq.Range(func(k string, i any) bool {
 		// Values will either be an attribute, a subtree, or a specially appended slice of the former two
 		switch v := i.(type) {
 		case slog.Attr:
 			attrs = append(attrs, v)
-		case *b.Tree[string, any]:
+		case attrStore:
 			// Convert subtree into a group
-			attrs = append(attrs, slog.Attr{Key: k, Value: slog.GroupValue(buildAttrs(v)...)})
+			attrs = append(attrs, slog.Attr{Key: k, Value: slog.GroupValue(buildAttrsIn(v, order, groupFormat, sortSlices, inAppended)...)})
 		case appended:
 			// This case only happens in the AppendHandler
 			anys := make([]any, 0, len(v))
@@ -107,18 +448,29 @@ func buildAttrs(uniq *b.Tree[string, any]) []slog.Attr {
 				switch sliceV := sliceVal.(type) {
 				case slog.Attr:
 					anys = append(anys, sliceV.Value.Any())
-				case *b.Tree[string, any]:
-					// Convert subtree into a map (because having a Group Attribute within a slice doesn't render)
-					anys = append(anys, buildGroupMap(buildAttrs(sliceV)))
+				case attrStore:
+					// Convert subtree into a map or AppendedGroup (because having a Group Attribute within a slice doesn't render)
+					group := buildAttrsIn(sliceV, order, groupFormat, sortSlices, true)
+					if groupFormat == AppendedGroupAttrs {
+						anys = append(anys, AppendedGroup(group))
+					} else {
+						anys = append(anys, buildGroupMap(group))
+					}
 				default:
 					panic("unexpected type in attribute map")
 				}
 			}
+			// The top level slices stay in order, oldest first
+			if sortSlices && inAppended {
+				slices.SortStableFunc(anys, compareValues)
+			}
 			attrs = append(attrs, slog.Any(k, anys))
 		default:
 			panic("unexpected type in attribute map")
 		}
-	}
+		return true
+	})
+	order.sort(uniq, attrs)
 	return attrs
 }
 
@@ -144,6 +496,7 @@ type groupOrAttrs struct {
 	group string        // group name if non-empty
 	attrs []slog.Attr   // attrs if non-empty
 	next  *groupOrAttrs // parent
+	depth int           // number of links in the chain, including this one
 }
 
 // WithGroup returns a new groupOrAttrs that includes the given group, and links to the old groupOrAttrs.
@@ -156,6 +509,7 @@ func (g *groupOrAttrs) WithGroup(name string) *groupOrAttrs {
 	return &groupOrAttrs{
 		group: name,
 		next:  g,
+		depth: g.chainDepth() + 1,
 	}
 }
 
@@ -168,7 +522,157 @@ func (g *groupOrAttrs) WithAttrs(attrs []slog.Attr) *groupOrAttrs {
 	return &groupOrAttrs{
 		attrs: attrs,
 		next:  g,
+		depth: g.chainDepth() + 1,
+	}
+}
+
+// chainDepth returns the number of links in the groupOrAttrs chain.
+// Safe to call on a nil groupOrAttrs.
+func (g *groupOrAttrs) chainDepth() int {
+	if g == nil {
+		return 0
+	}
+	return g.depth
+}
+
+// goaDepthGuard logs a single warning to the next handler the first time a
+// handler is used with a groupOrAttrs chain deeper than the max. This usually
+// indicates a misuse such as calling logger = logger.With(...) in a loop.
+// Each handler has its own guard, so every logger warns once.
+type goaDepthGuard struct {
+	max  int
+	once sync.Once
+}
+
+// newGoaDepthGuard returns a guard, or nil if maxDepth is not positive.
+func newGoaDepthGuard(maxDepth int) *goaDepthGuard {
+	if maxDepth <= 0 {
+		return nil
+	}
+	return &goaDepthGuard{max: maxDepth}
+}
+
+// derive returns a new guard with the same max for a derived handler, which
+// has not yet logged the warning. Safe to call on a nil goaDepthGuard.
+func (g *goaDepthGuard) derive() *goaDepthGuard {
+	if g == nil {
+		return nil
+	}
+	return &goaDepthGuard{max: g.max}
+}
+
+// check logs the warning to next if the chain is too deep and the warning
+// has not already been logged. Safe to call on a nil goaDepthGuard.
+func (g *goaDepthGuard) check(ctx context.Context, next slog.Handler, goa *groupOrAttrs) {
+	if g == nil || goa.chainDepth() <= g.max {
+		return
+	}
+	g.once.Do(func() {
+		if !next.Enabled(ctx, slog.LevelWarn) {
+			return
+		}
+		r := slog.NewRecord(time.Now(), slog.LevelWarn, "slogdedup: logger With/WithGroup chain exceeded MaxGoaDepth", 0)
+		r.AddAttrs(slog.Int("depth", goa.chainDepth()), slog.Int("max_depth", g.max))
+		_ = next.Handle(ctx, r)
+	})
+}
+
+// sequencer adds a monotonically increasing sequence number to each record.
+// It is shared by a handler and all handlers derived from it.
+type sequencer struct {
+	key string
+	n   atomic.Uint64
+}
+
+// newSequencer returns a sequencer, or nil if the key is empty.
+func newSequencer(key string) *sequencer {
+	if key == "" {
+		return nil
+	}
+	return &sequencer{key: key}
+}
+
+// add puts the next sequence number into the root of the tree, replacing any
+// attribute or group with the same key. Safe to call on a nil sequencer.
+func (s *sequencer) add(state *handleState, uniq attrStore) {
+	if s == nil {
+		return
+	}
+	uniq.Set(s.key, slog.Uint64(s.key, s.n.Add(1)))
+	state.order.touch(uniq, s.key)
+}
+
+// addModeTag puts the name of the handler's deduplication mode into the root
+// of the tree under the key, replacing any attribute or group with the same
+// key. Does nothing if the key is empty.
+func addModeTag(state *handleState, uniq attrStore, key string, mode DedupMode) {
+	if key == "" {
+		return
+	}
+	uniq.Set(key, slog.String(key, mode.String()))
+	state.order.touch(uniq, key)
+}
+
+// ensureKeys puts each attribute into the root of the tree, if no attribute or
+// group with the same key is already there.
+func ensureKeys(state *handleState, uniq attrStore, attrs []slog.Attr) {
+	for _, a := range attrs {
+		if _, ok := uniq.Get(a.Key); !ok {
+			uniq.Set(a.Key, a)
+			state.order.touch(uniq, a.Key)
+		}
+	}
+}
+
+// addBuiltins puts the record's time, level, and message into the root of the
+// tree as attributes, replacing any with the same keys, and returns the record
+// with its time and message cleared. The level is a string, so that
+// ReplaceAttrSortedBuiltins can tell it apart from the record's builtin level.
+// A zero time is set to the current time if ensureTime is true, and otherwise
+// left out, as is an empty message.
+func addBuiltins(state *handleState, uniq attrStore, r slog.Record, ensureTime bool) slog.Record {
+	if r.Time.IsZero() && ensureTime {
+		r.Time = time.Now()
+	}
+	builtins := []slog.Attr{slog.String(slog.LevelKey, r.Level.String())}
+	if !r.Time.IsZero() {
+		builtins = append(builtins, slog.Time(slog.TimeKey, r.Time))
+	}
+	if r.Message != "" {
+		builtins = append(builtins, slog.String(slog.MessageKey, r.Message))
+	}
+	for _, a := range builtins {
+		uniq.Set(a.Key, a)
+		state.order.touch(uniq, a.Key)
+	}
+	r.Time = time.Time{}
+	r.Message = ""
+	return r
+}
+
+// moveBuiltinsLast moves the root time, level, and message attributes put there
+// by addBuiltins to the end of the attributes, in that order.
+func moveBuiltinsLast(attrs []slog.Attr) []slog.Attr {
+	var builtins [3]slog.Attr
+	others := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		switch a.Key {
+		case slog.TimeKey:
+			builtins[0] = a
+		case slog.LevelKey:
+			builtins[1] = a
+		case slog.MessageKey:
+			builtins[2] = a
+		default:
+			others = append(others, a)
+		}
+	}
+	for _, a := range builtins {
+		if a.Key != "" {
+			others = append(others, a)
+		}
 	}
+	return others
 }
 
 // collectGroupOrAttrs unrolls all individual groupOrAttrs and collects them into a slice, ordered from oldest to newest
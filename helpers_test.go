@@ -4,7 +4,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -203,3 +207,1171 @@ func checkForDuplicates(t *testing.T, attrs []slog.Attr) {
 		}
 	}
 }
+
+// captureHandler is a terminal handler that keeps every record it handles
+type captureHandler struct {
+	mu      sync.Mutex
+	Records []slog.Record
+}
+
+func (h *captureHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *captureHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.Records = append(h.Records, r)
+	return nil
+}
+
+func (h *captureHandler) WithGroup(string) slog.Handler {
+	panic("shouldn't be called")
+}
+
+func (h *captureHandler) WithAttrs([]slog.Attr) slog.Handler {
+	panic("shouldn't be called")
+}
+
+func TestMaxGoaDepth(t *testing.T) {
+	t.Parallel()
+
+	capture := &captureHandler{}
+	log := slog.New(NewOverwriteHandler(capture, &OverwriteHandlerOptions{CommonOptions: CommonOptions{MaxGoaDepth: 100}}))
+
+	log.Info("shallow")
+	for i := 0; i < 1000; i++ {
+		log = log.With("loop", i)
+	}
+	log.Info("deep1")
+	log.Info("deep2")
+	log.WithGroup("group1").Info("deep3")
+
+	var warnings, records int
+	for _, r := range capture.Records {
+		if r.Level == slog.LevelWarn {
+			warnings++
+			if r.Message != "slogdedup: logger With/WithGroup chain exceeded MaxGoaDepth" {
+				t.Errorf("Unexpected warning message: %s", r.Message)
+			}
+			continue
+		}
+		records++
+	}
+	// The deep logger warns once, and the logger derived from it warns once
+	if warnings != 2 || records != 4 {
+		t.Errorf("Expected 2 warnings and 4 records; Got %d warnings and %d records", warnings, records)
+	}
+}
+
+func TestMaxGoaDepth_LevelRouter(t *testing.T) {
+	t.Parallel()
+
+	next := &captureHandler{}
+	warn := &captureHandler{}
+	log := slog.New(NewOverwriteHandler(next, &OverwriteHandlerOptions{
+		CommonOptions: CommonOptions{MaxGoaDepth: 2},
+		LevelRouter: func(level slog.Level) slog.Handler {
+			if level >= slog.LevelWarn {
+				return warn
+			}
+			return nil
+		},
+	}))
+
+	log = log.With("arg1", 1).With("arg2", 2).With("arg3", 3)
+	log.Info("deep1")
+	log.Info("deep2")
+
+	// The warning is routed like a record of its level
+	if len(next.Records) != 2 || len(warn.Records) != 1 {
+		t.Fatalf("Expected 2 records and 1 warning; Got %d records and %d warnings", len(next.Records), len(warn.Records))
+	}
+	if warn.Records[0].Message != "slogdedup: logger With/WithGroup chain exceeded MaxGoaDepth" {
+		t.Errorf("Unexpected warning message: %s", warn.Records[0].Message)
+	}
+}
+
+func TestUnifyGroupSources(t *testing.T) {
+	t.Parallel()
+
+	// The same group key is created both by a slog.Group attribute and by WithGroup
+	logUnify := func(h slog.Handler) {
+		log := slog.New(h).With(slog.Group("group1", "arg1", "inline", "arg2", "inline"))
+		log.WithGroup("group1").Info("unify", "arg2", "withGroup", "arg3", "withGroup")
+	}
+
+	tests := []struct {
+		name       string
+		middleware func(next slog.Handler, unify bool) slog.Handler
+		expected   string
+		unified    string
+	}{
+		{
+			name: "overwrite",
+			middleware: func(next slog.Handler, unify bool) slog.Handler {
+				return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{UnifyGroupSources: unify}})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"unify","group1":{"arg2":"withGroup","arg3":"withGroup"}}`,
+			unified:  `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"unify","group1":{"arg1":"inline","arg2":"withGroup","arg3":"withGroup"}}`,
+		},
+		{
+			name: "ignore",
+			middleware: func(next slog.Handler, unify bool) slog.Handler {
+				return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{UnifyGroupSources: unify}})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"unify","group1":{"arg1":"inline","arg2":"inline"}}`,
+			unified:  `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"unify","group1":{"arg1":"inline","arg2":"inline","arg3":"withGroup"}}`,
+		},
+		{
+			name: "increment",
+			middleware: func(next slog.Handler, unify bool) slog.Handler {
+				return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{UnifyGroupSources: unify}})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"unify","group1":{"arg1":"inline","arg2":"inline"},"group1#01":{"arg2":"withGroup","arg3":"withGroup"}}`,
+			unified:  `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"unify","group1":{"arg1":"inline","arg2":"inline","arg2#01":"withGroup","arg3":"withGroup"}}`,
+		},
+		{
+			name: "append",
+			middleware: func(next slog.Handler, unify bool) slog.Handler {
+				return NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{UnifyGroupSources: unify}})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"unify","group1":[{"arg1":"inline","arg2":"inline"},{"arg2":"withGroup","arg3":"withGroup"}]}`,
+			unified:  `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"unify","group1":{"arg1":"inline","arg2":["inline","withGroup"],"arg3":"withGroup"}}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		for _, unify := range []bool{false, true} {
+			tester := &testHandler{}
+			logUnify(testCase.middleware(tester, unify))
+
+			jBytes, err := tester.MarshalJSON()
+			if err != nil {
+				t.Errorf("Unable to marshal json: %v", err)
+				continue
+			}
+			jStr := strings.TrimSpace(string(jBytes))
+
+			expected := testCase.expected
+			if unify {
+				expected = testCase.unified
+			}
+			if jStr != expected {
+				t.Errorf("%s unify=%t Expected:\n%s\nGot:\n%s", testCase.name, unify, expected, jStr)
+			}
+
+			checkRecordForDuplicates(t, tester.Record)
+		}
+	}
+}
+
+func TestKeyCompareForGroup(t *testing.T) {
+	t.Parallel()
+
+	// Only the headers group is case-insensitive
+	keyCompareForGroup := func(groups []string) func(a, b string) int {
+		if len(groups) == 1 && groups[0] == "headers" {
+			return CaseInsensitiveCmp
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name     string
+		handler  func(next slog.Handler) slog.Handler
+		expected string
+	}{
+		{
+			name: "overwrite",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{KeyCompareForGroup: keyCompareForGroup}})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"compare","Arg1":"val1","arg1":"val2","headers":{"content-type":"text"}}`,
+		},
+		{
+			name: "ignore",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{KeyCompareForGroup: keyCompareForGroup}})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"compare","Arg1":"val1","arg1":"val2","headers":{"Content-Type":"json"}}`,
+		},
+		{
+			name: "increment",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{KeyCompareForGroup: keyCompareForGroup}})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"compare","Arg1":"val1","arg1":"val2","headers":{"Content-Type":"json","content-type#01":"text"}}`,
+		},
+		{
+			name: "append",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{KeyCompareForGroup: keyCompareForGroup}})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"compare","Arg1":"val1","arg1":"val2","headers":{"Content-Type":["json","text"]}}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		log := slog.New(testCase.handler(tester)).With("Arg1", "val1", "arg1", "val2")
+		log.Info("compare", slog.Group("headers", "Content-Type", "json", "content-type", "text"))
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != testCase.expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, testCase.expected, jStr)
+		}
+
+		checkRecordForDuplicates(t, tester.Record)
+	}
+}
+
+func TestAlphanumericCmp(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		handler  func(next slog.Handler) slog.Handler
+		expected string
+	}{
+		{
+			name: "overwrite",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewOverwriteHandler(next, &OverwriteHandlerOptions{KeyCompare: AlphanumericCmp})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"compare","user":"name","userId":3}`,
+		},
+		{
+			name: "ignore",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIgnoreHandler(next, &IgnoreHandlerOptions{KeyCompare: AlphanumericCmp})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"compare","user":"name","user-id":1}`,
+		},
+		{
+			name: "increment",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIncrementHandler(next, &IncrementHandlerOptions{KeyCompare: AlphanumericCmp})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"compare","user":"name","user-id":1,"user_id#01":2,"userId#02":3}`,
+		},
+		{
+			name: "append",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewAppendHandler(next, &AppendHandlerOptions{KeyCompare: AlphanumericCmp})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"compare","user":"name","user-id":[1,2,3]}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		log := slog.New(testCase.handler(tester)).With("user-id", 1)
+		log.Info("compare", "user_id", 2, "userId", 3, "user", "name")
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != testCase.expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, testCase.expected, jStr)
+		}
+
+		checkRecordForDuplicates(t, tester.Record)
+	}
+}
+
+func TestSequenceKey(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		handler func(next slog.Handler) slog.Handler
+	}{
+		{
+			name: "overwrite",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{SequenceKey: "seq"}})
+			},
+		},
+		{
+			name: "ignore",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{SequenceKey: "seq"}})
+			},
+		},
+		{
+			name: "increment",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{SequenceKey: "seq"}})
+			},
+		},
+		{
+			name: "append",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{SequenceKey: "seq"}})
+			},
+		},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		log := slog.New(testCase.handler(tester))
+
+		// Derived loggers share the same count, and user attributes are replaced
+		for i, logger := range []*slog.Logger{log, log.With("seq", "user"), log.WithGroup("group1"), log} {
+			logger.Info("sequence", "arg1", "val1")
+
+			expected := fmt.Sprintf(`{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"sequence","arg1":"val1","seq":%d}`, i+1)
+			if i == 2 {
+				expected = fmt.Sprintf(`{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"sequence","group1":{"arg1":"val1"},"seq":%d}`, i+1)
+			}
+
+			jBytes, err := tester.MarshalJSON()
+			if err != nil {
+				t.Errorf("Unable to marshal json: %v", err)
+				continue
+			}
+			jStr := strings.TrimSpace(string(jBytes))
+
+			if jStr != expected {
+				t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, expected, jStr)
+			}
+
+			checkRecordForDuplicates(t, tester.Record)
+		}
+	}
+}
+
+func TestMaxNodes(t *testing.T) {
+	t.Parallel()
+
+	// Create a huge nested structure
+	nested := slog.String("leaf", "val")
+	for i := 0; i < 1000; i++ {
+		nested = slog.Group(fmt.Sprintf("level%d", i), "arg", i, nested)
+	}
+
+	tests := []struct {
+		name    string
+		handler func(next slog.Handler) slog.Handler
+	}{
+		{
+			name: "overwrite",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{MaxNodes: 8}})
+			},
+		},
+		{
+			name: "ignore",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{MaxNodes: 8}})
+			},
+		},
+		{
+			name: "increment",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{MaxNodes: 8}})
+			},
+		},
+		{
+			name: "append",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{MaxNodes: 8}})
+			},
+		},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		log := slog.New(testCase.handler(tester)).With("arg1", "val1").WithGroup("group1")
+
+		// Under the budget
+		log.Info("nodes", "arg2", "val2")
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"nodes","arg1":"val1","group1":{"arg2":"val2"}}`
+		if jStr != expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, expected, jStr)
+		}
+
+		// Over the budget
+		log.Info("nodes", "arg2", "val2", nested, "arg3", "val3")
+
+		jBytes, err = tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr = strings.TrimSpace(string(jBytes))
+
+		expected = `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"nodes","_truncated":true,"arg1":"val1","group1":{"arg2":"val2","level999":{"arg":999,"level998":{"arg":998}}}}`
+		if jStr != expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, expected, jStr)
+		}
+
+		checkRecordForDuplicates(t, tester.Record)
+	}
+}
+
+func TestClone(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		handler func(next slog.Handler) (slog.Handler, slog.Handler)
+	}{
+		{
+			name: "overwrite",
+			handler: func(next slog.Handler) (slog.Handler, slog.Handler) {
+				h := NewOverwriteHandler(next, nil).WithAttrs([]slog.Attr{slog.String("arg1", "val1")}).(*OverwriteHandler)
+				return h, h.Clone()
+			},
+		},
+		{
+			name: "ignore",
+			handler: func(next slog.Handler) (slog.Handler, slog.Handler) {
+				h := NewIgnoreHandler(next, nil).WithAttrs([]slog.Attr{slog.String("arg1", "val1")}).(*IgnoreHandler)
+				return h, h.Clone()
+			},
+		},
+		{
+			name: "increment",
+			handler: func(next slog.Handler) (slog.Handler, slog.Handler) {
+				h := NewIncrementHandler(next, nil).WithAttrs([]slog.Attr{slog.String("arg1", "val1")}).(*IncrementHandler)
+				return h, h.Clone()
+			},
+		},
+		{
+			name: "append",
+			handler: func(next slog.Handler) (slog.Handler, slog.Handler) {
+				h := NewAppendHandler(next, nil).WithAttrs([]slog.Attr{slog.String("arg1", "val1")}).(*AppendHandler)
+				return h, h.Clone()
+			},
+		},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		h, clone := testCase.handler(tester)
+
+		// Diverge the original and the clone
+		log := slog.New(h).WithGroup("group1").With("arg2", "orig")
+		logClone := slog.New(clone).With("arg2", "clone")
+
+		expectations := []struct {
+			log      *slog.Logger
+			expected string
+		}{
+			{log: slog.New(h), expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"clone","arg1":"val1","arg3":"val3"}`},
+			{log: log, expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"clone","arg1":"val1","group1":{"arg2":"orig","arg3":"val3"}}`},
+			{log: logClone, expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"clone","arg1":"val1","arg2":"clone","arg3":"val3"}`},
+			{log: slog.New(clone), expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"clone","arg1":"val1","arg3":"val3"}`},
+		}
+
+		for _, e := range expectations {
+			e.log.Info("clone", "arg3", "val3")
+
+			jBytes, err := tester.MarshalJSON()
+			if err != nil {
+				t.Errorf("Unable to marshal json: %v", err)
+				continue
+			}
+			jStr := strings.TrimSpace(string(jBytes))
+
+			if jStr != e.expected {
+				t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, e.expected, jStr)
+			}
+
+			checkRecordForDuplicates(t, tester.Record)
+		}
+	}
+}
+
+func TestResolveKeyGroupNames(t *testing.T) {
+	t.Parallel()
+
+	// ResolveKey must never see the empty names of inlined groups
+	resolveKey := func(groups []string, key string, index int) (string, bool) {
+		if key == "" {
+			t.Errorf("ResolveKey called with empty key in groups %v", groups)
+			return "empty", true
+		}
+		return IncrementIfBuiltinKeyConflict(groups, key, index)
+	}
+
+	tests := []struct {
+		name     string
+		handler  func(next slog.Handler) slog.Handler
+		expected string
+	}{
+		{
+			name: "overwrite",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewOverwriteHandler(next, &OverwriteHandlerOptions{ResolveKey: resolveKey})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"group names","group1":{"arg1":"val1"},"inlined":"yes"}`,
+		},
+		{
+			name: "ignore",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIgnoreHandler(next, &IgnoreHandlerOptions{ResolveKey: resolveKey})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"group names","group1":"scalar","inlined":"yes"}`,
+		},
+		{
+			name: "increment",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIncrementHandler(next, &IncrementHandlerOptions{ResolveKey: resolveKey})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"group names","group1":"scalar","group1#01":{"arg1":"val1"},"inlined":"yes"}`,
+		},
+		{
+			name: "append",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewAppendHandler(next, &AppendHandlerOptions{ResolveKey: resolveKey})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"group names","group1":["scalar",{"arg1":"val1"}],"inlined":"yes"}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		// A group name colliding with a prior scalar must be handled the same,
+		// whether the group came from WithGroup or from slog.Group
+		for _, viaWithGroup := range []bool{true, false} {
+			tester := &testHandler{}
+			log := slog.New(testCase.handler(tester)).With("group1", "scalar", slog.Group("", "inlined", "yes"))
+			if viaWithGroup {
+				log.WithGroup("group1").Info("group names", "arg1", "val1")
+			} else {
+				log.Info("group names", slog.Group("group1", "arg1", "val1"))
+			}
+
+			jBytes, err := tester.MarshalJSON()
+			if err != nil {
+				t.Errorf("Unable to marshal json: %v", err)
+				continue
+			}
+			jStr := strings.TrimSpace(string(jBytes))
+
+			if jStr != testCase.expected {
+				t.Errorf("%s viaWithGroup=%t Expected:\n%s\nGot:\n%s", testCase.name, viaWithGroup, testCase.expected, jStr)
+			}
+		}
+	}
+}
+
+func TestPrefixRootKeys(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		prefix   string
+		expected string
+	}{
+		{
+			name:     "tenant",
+			prefix:   "acme.",
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"prefix","acme.arg1":"val1","acme.group1":{"arg1":"main1","msg":"main1"},"acme.msg":"main1"}`,
+		},
+		{
+			name:     "empty",
+			prefix:   "",
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"prefix","arg1":"val1","group1":{"arg1":"main1","msg":"main1"},"msg#01":"main1"}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		h := NewOverwriteHandler(tester, &OverwriteHandlerOptions{ResolveKey: PrefixRootKeys(testCase.prefix)})
+		log := slog.New(h).With("arg1", "val1")
+		log.Info("prefix", "msg", "main1", slog.Group("group1", "arg1", "main1", "msg", "main1"))
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != testCase.expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, testCase.expected, jStr)
+		}
+
+		checkRecordForDuplicates(t, tester.Record)
+	}
+}
+
+func TestTimer(t *testing.T) {
+	t.Parallel()
+
+	var calls int
+	timer := func(d time.Duration) {
+		calls++
+		if d < 0 {
+			t.Errorf("Expected a non-negative duration; Got: %v", d)
+		}
+	}
+
+	handlers := []slog.Handler{
+		NewOverwriteHandler(&testHandler{}, &OverwriteHandlerOptions{CommonOptions: CommonOptions{Timer: timer}}),
+		NewIgnoreHandler(&testHandler{}, &IgnoreHandlerOptions{CommonOptions: CommonOptions{Timer: timer}}),
+		NewIncrementHandler(&testHandler{}, &IncrementHandlerOptions{CommonOptions: CommonOptions{Timer: timer}}),
+		NewAppendHandler(&testHandler{}, &AppendHandlerOptions{CommonOptions: CommonOptions{Timer: timer}}),
+	}
+
+	for _, h := range handlers {
+		log := slog.New(h).With("arg1", "val1").WithGroup("group1")
+		log.Info("timer", "arg1", "val2")
+		log.Info("timer", "arg1", "val3")
+	}
+
+	if calls != 2*len(handlers) {
+		t.Errorf("Expected %d calls; Got: %d", 2*len(handlers), calls)
+	}
+}
+
+func TestSizeObserver(t *testing.T) {
+	t.Parallel()
+
+	var size int
+	observer := func(bytes int) {
+		size = bytes
+	}
+
+	tests := []struct {
+		name    string
+		handler func(next slog.Handler) slog.Handler
+	}{
+		{
+			name: "overwrite",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{SizeObserver: observer}})
+			},
+		},
+		{
+			name: "ignore",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{SizeObserver: observer}})
+			},
+		},
+		{
+			name: "increment",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{SizeObserver: observer}})
+			},
+		},
+		{
+			name: "append",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{SizeObserver: observer}})
+			},
+		},
+	}
+
+	for _, testCase := range tests {
+		size = 0
+		tester := &testHandler{}
+		logComplex(t, testCase.handler(tester))
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+
+		// The estimate excludes the time, level, quotes, and punctuation, so
+		// it should be somewhat smaller than the actual JSON
+		if size < len(jBytes)/2 || size >= len(jBytes) {
+			t.Errorf("%s Expected size between %d and %d; Got: %d", testCase.name, len(jBytes)/2, len(jBytes), size)
+		}
+	}
+}
+
+func TestAttrCountObserver(t *testing.T) {
+	t.Parallel()
+
+	var level slog.Level
+	var count int
+	observer := func(l slog.Level, c int) {
+		level, count = l, c
+	}
+
+	tests := []struct {
+		name     string
+		handler  func(next slog.Handler) slog.Handler
+		expected int
+	}{
+		{
+			name: "overwrite",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{AttrCountObserver: observer}})
+			},
+			expected: 4, // arg1, arg2, group1.arg1, group1.arg2
+		},
+		{
+			name: "ignore",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{AttrCountObserver: observer}})
+			},
+			expected: 4, // arg1, arg2, group1.arg1, group1.arg2
+		},
+		{
+			name: "increment",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{AttrCountObserver: observer}})
+			},
+			expected: 5, // arg1, arg1#01, arg2, group1.arg1, group1.arg2
+		},
+		{
+			name: "append",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{AttrCountObserver: observer}})
+			},
+			expected: 4, // arg1 (slice), arg2, group1.arg1, group1.arg2
+		},
+	}
+
+	for _, testCase := range tests {
+		level, count = 0, 0
+		log := slog.New(testCase.handler(&testHandler{})).With("arg1", "with1", "arg2", "with1")
+		log.Warn("count", "arg1", "main1", slog.Group("group1", "arg1", "main1", "arg2", "main1"), slog.Group("empty"))
+
+		if level != slog.LevelWarn || count != testCase.expected {
+			t.Errorf("%s Expected: %s %d; Got: %s %d", testCase.name, slog.LevelWarn, testCase.expected, level, count)
+		}
+	}
+}
+
+func TestResolveMessage(t *testing.T) {
+	t.Parallel()
+
+	resolveKey := func(groups []string, key string, index int) (string, bool) {
+		if len(groups) == 0 && key == slog.MessageKey {
+			return "summary", true
+		}
+		return IncrementIfBuiltinKeyConflict(groups, key, index)
+	}
+
+	tests := []struct {
+		name    string
+		handler func(next slog.Handler) slog.Handler
+	}{
+		{"overwrite", func(next slog.Handler) slog.Handler {
+			return NewOverwriteHandler(next, &OverwriteHandlerOptions{ResolveKey: resolveKey, CommonOptions: CommonOptions{ResolveMessage: true}})
+		}},
+		{"ignore", func(next slog.Handler) slog.Handler {
+			return NewIgnoreHandler(next, &IgnoreHandlerOptions{ResolveKey: resolveKey, CommonOptions: CommonOptions{ResolveMessage: true}})
+		}},
+		{"increment", func(next slog.Handler) slog.Handler {
+			return NewIncrementHandler(next, &IncrementHandlerOptions{ResolveKey: resolveKey, CommonOptions: CommonOptions{ResolveMessage: true}})
+		}},
+		{"append", func(next slog.Handler) slog.Handler {
+			return NewAppendHandler(next, &AppendHandlerOptions{ResolveKey: resolveKey, CommonOptions: CommonOptions{ResolveMessage: true}})
+		}},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		log := slog.New(testCase.handler(tester)).With("arg1", "val1").WithGroup("group1")
+		log.Info("main message", "msg", "val2")
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"","summary":"main message","arg1":"val1","group1":{"msg":"val2"}}`
+		if jStr != expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, expected, jStr)
+		}
+	}
+
+	// Without the option, the message is left alone
+	tester := &testHandler{}
+	slog.New(NewOverwriteHandler(tester, &OverwriteHandlerOptions{ResolveKey: resolveKey})).Info("main message")
+	if tester.Record.Message != "main message" {
+		t.Errorf("Expected the message to be unchanged; Got: %q", tester.Record.Message)
+	}
+}
+
+func TestModeTagKey(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		handler func(next slog.Handler) slog.Handler
+	}{
+		{"overwrite", func(next slog.Handler) slog.Handler {
+			return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{ModeTagKey: "_dedup_mode"}})
+		}},
+		{"ignore", func(next slog.Handler) slog.Handler {
+			return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{ModeTagKey: "_dedup_mode"}})
+		}},
+		{"increment", func(next slog.Handler) slog.Handler {
+			return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{ModeTagKey: "_dedup_mode"}})
+		}},
+		{"append", func(next slog.Handler) slog.Handler {
+			return NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{ModeTagKey: "_dedup_mode"}})
+		}},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		// The user's own attribute with the same key must not replace the tag
+		log := slog.New(testCase.handler(tester)).With("_dedup_mode", "user")
+		log.Info("mode", "arg1", "val1")
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"mode","_dedup_mode":"` + testCase.name + `","arg1":"val1"}`
+		if jStr != expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, expected, jStr)
+		}
+	}
+}
+
+func TestCollapseSingletonGroups(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		opts     *OverwriteHandlerOptions
+		expected string
+	}{
+		{
+			name:     "dot",
+			opts:     &OverwriteHandlerOptions{CommonOptions: CommonOptions{CollapseSingletonGroups: true}},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"collapse","a":{"b.c":1},"a.b.c":2,"http":{"method":"GET","path":"/"},"req.id":5,"user.profile.name":"bob"}`,
+		},
+		{
+			name:     "separator",
+			opts:     &OverwriteHandlerOptions{CommonOptions: CommonOptions{CollapseSingletonGroups: true, CollapseSeparator: "_"}},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"collapse","a_b_c":1,"a.b.c":2,"http":{"method":"GET","path":"/"},"req_id":5,"user_profile_name":"bob"}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		log := slog.New(NewOverwriteHandler(tester, testCase.opts)).With(slog.Group("req", "id", 4))
+		log.Info("collapse",
+			slog.Group("req", "id", 5),
+			slog.Group("http", "method", "GET", "path", "/"),
+			slog.Group("user", slog.Group("profile", "name", "bob")),
+			slog.Group("a", slog.Group("b", "c", 1)),
+			"a.b.c", 2, // Collides with the collapsed key, so "a" is not collapsed
+		)
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != testCase.expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, testCase.expected, jStr)
+		}
+	}
+}
+
+func TestTypeDisambiguate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		handler  func(next slog.Handler) slog.Handler
+		expected string
+	}{
+		{
+			name: "overwrite",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewOverwriteHandler(next, &OverwriteHandlerOptions{TypeDisambiguate: true})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"disambiguate","group1":{"arg1":"main1","arg1#group":{"arg2":"main2"}},"user":"y","user#group":{"id":2}}`,
+		},
+		{
+			name: "ignore",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIgnoreHandler(next, &IgnoreHandlerOptions{TypeDisambiguate: true})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"disambiguate","group1":{"arg1":"main1","arg1#group":{"arg2":"main2"}},"user":"x","user#group":{"id":1}}`,
+		},
+		{
+			name: "append",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewAppendHandler(next, &AppendHandlerOptions{TypeDisambiguate: true})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"disambiguate","group1":{"arg1":"main1","arg1#group":{"arg2":"main2"}},"user":["x","y"],"user#group":[{"id":1},{"id":2}]}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		log := slog.New(testCase.handler(tester)).With("user", "x", slog.Group("user", "id", 1))
+		log.Info("disambiguate", slog.Group("user", "id", 2), "user", "y", slog.Group("group1", slog.Group("arg1", "arg2", "main2"), "arg1", "main1"))
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != testCase.expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, testCase.expected, jStr)
+		}
+	}
+}
+
+func TestGroupDepthKey(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		handler func(next slog.Handler) slog.Handler
+	}{
+		{"overwrite", func(next slog.Handler) slog.Handler {
+			return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{GroupDepthKey: "_depth"}})
+		}},
+		{"ignore", func(next slog.Handler) slog.Handler {
+			return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{GroupDepthKey: "_depth", CacheWithAttrs: true}})
+		}},
+		{"increment", func(next slog.Handler) slog.Handler {
+			return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{GroupDepthKey: "_depth"}})
+		}},
+		{"append", func(next slog.Handler) slog.Handler {
+			return NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{GroupDepthKey: "_depth", CacheWithAttrs: true}})
+		}},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		log := slog.New(testCase.handler(tester))
+		log.Info("depth", "arg1", "val1")
+
+		expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"depth","_depth":0,"arg1":"val1"}`
+		checkGroupDepth(t, testCase.name, tester, expected)
+
+		// Groups in attributes are not counted
+		log.WithGroup("group1").With("arg1", "val1").WithGroup("group2").Info("depth", slog.Group("group3", "arg2", "val2"))
+
+		expected = `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"depth","_depth":2,"group1":{"arg1":"val1","group2":{"group3":{"arg2":"val2"}}}}`
+		checkGroupDepth(t, testCase.name, tester, expected)
+	}
+}
+
+func checkGroupDepth(t *testing.T, name string, tester *testHandler, expected string) {
+	t.Helper()
+	jBytes, err := tester.MarshalJSON()
+	if err != nil {
+		t.Errorf("Unable to marshal json: %v", err)
+		return
+	}
+	if jStr := strings.TrimSpace(string(jBytes)); jStr != expected {
+		t.Errorf("%s Expected:\n%s\nGot:\n%s", name, expected, jStr)
+	}
+}
+
+func TestEnsureKeys(t *testing.T) {
+	t.Parallel()
+
+	ensure := []slog.Attr{slog.Any("trace_id", nil), slog.String("env", "unknown")}
+	tests := []struct {
+		name    string
+		handler func(next slog.Handler) slog.Handler
+	}{
+		{"overwrite", func(next slog.Handler) slog.Handler {
+			return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{EnsureKeys: ensure}})
+		}},
+		{"ignore", func(next slog.Handler) slog.Handler {
+			return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{EnsureKeys: ensure, OrderMode: OrderInsertion}})
+		}},
+		{"increment", func(next slog.Handler) slog.Handler {
+			return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{EnsureKeys: ensure}})
+		}},
+		{"append", func(next slog.Handler) slog.Handler {
+			return NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{EnsureKeys: ensure, ShortCircuitUnique: true}})
+		}},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		log := slog.New(testCase.handler(tester)).With("env", "prod")
+		log.Info("main message", "arg1", "val1")
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		// The missing trace_id appears as null, while the existing env is kept
+		expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","arg1":"val1","env":"prod","trace_id":null}`
+		if testCase.name == "ignore" {
+			expected = `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","env":"prod","arg1":"val1","trace_id":null}`
+		}
+		if jStr != expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, expected, jStr)
+		}
+	}
+}
+
+func TestSampleFunc(t *testing.T) {
+	t.Parallel()
+
+	for _, before := range []bool{false, true} {
+		var sampledAttrs []int
+		sampler := func(r slog.Record) bool {
+			sampledAttrs = append(sampledAttrs, r.NumAttrs())
+			return r.Level >= slog.LevelError
+		}
+
+		tests := []struct {
+			name    string
+			handler func(next slog.Handler) slog.Handler
+		}{
+			{"overwrite", func(next slog.Handler) slog.Handler {
+				return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{SampleFunc: sampler, SampleBeforeDedup: before}})
+			}},
+			{"ignore", func(next slog.Handler) slog.Handler {
+				return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{SampleFunc: sampler, SampleBeforeDedup: before}})
+			}},
+			{"increment", func(next slog.Handler) slog.Handler {
+				return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{SampleFunc: sampler, SampleBeforeDedup: before}})
+			}},
+			{"append", func(next slog.Handler) slog.Handler {
+				return NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{SampleFunc: sampler, SampleBeforeDedup: before, ShortCircuitUnique: true}})
+			}},
+		}
+
+		for _, testCase := range tests {
+			sampledAttrs = nil
+			capture := &captureHandler{}
+			log := slog.New(testCase.handler(capture)).With("arg1", "with1", "arg2", "with2")
+			log.Info("dropped", "arg1", "main1")
+			log.Error("kept", "arg1", "main1")
+
+			if len(capture.Records) != 1 || capture.Records[0].Message != "kept" {
+				t.Errorf("%s before=%t Expected only the error record to be kept; Got: %v", testCase.name, before, capture.Records)
+				continue
+			}
+
+			// Before deduplication, the sampler only sees the record's own attributes
+			expected := []int{1, 1}
+			if !before {
+				expected = []int{capture.Records[0].NumAttrs(), capture.Records[0].NumAttrs()}
+			}
+			if !slices.Equal(sampledAttrs, expected) {
+				t.Errorf("%s before=%t Expected sampled attribute counts %v; Got: %v", testCase.name, before, expected, sampledAttrs)
+			}
+		}
+	}
+}
+
+func TestRequestIDFromContext(t *testing.T) {
+	t.Parallel()
+
+	type requestIDContextKey struct{}
+	requestID := func(ctx context.Context) string {
+		id, _ := ctx.Value(requestIDContextKey{}).(string)
+		return id
+	}
+
+	tests := []struct {
+		name     string
+		handler  func(next slog.Handler) slog.Handler
+		args     []any
+		expected string
+	}{
+		{
+			name: "overwrite",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{RequestIDFromContext: requestID}})
+			},
+			args:     []any{"arg1", "val1"},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","arg1":"val1","request_id":"abc123"}`,
+		},
+		{
+			name: "increment-key",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{RequestIDFromContext: requestID, RequestIDKey: "req"}})
+			},
+			args:     []any{"arg1", "val1", "req", "user"},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","arg1":"val1","req":"abc123","req#01":"user"}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		log := slog.New(testCase.handler(tester))
+		ctx := context.WithValue(context.Background(), requestIDContextKey{}, "abc123")
+		log.InfoContext(ctx, "main message", testCase.args...)
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != testCase.expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, testCase.expected, jStr)
+		}
+
+		// Without a request id, nothing is added
+		log.Info("main message", "arg1", "val1")
+		if tester.Record.NumAttrs() != 1 {
+			t.Errorf("%s Expected no request id without one in the context", testCase.name)
+		}
+	}
+}
+
+func TestDropTime(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	next := slog.NewJSONHandler(buf, nil)
+	handlers := []slog.Handler{
+		NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{DropTime: true, EnsureTime: true}}),
+		NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{DropTime: true}}),
+		NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{DropTime: true, SortBuiltins: true}}),
+		NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{DropTime: true, ShortCircuitUnique: true}}),
+	}
+
+	for _, h := range handlers {
+		buf.Reset()
+		slog.New(h).Info("main message", "arg1", "val1")
+
+		var m map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+			t.Fatalf("Unable to unmarshal json: %v", err)
+		}
+		if _, ok := m[slog.TimeKey]; ok || m["arg1"] != "val1" {
+			t.Errorf("Expected no time field; Got: %s", buf.String())
+		}
+	}
+}
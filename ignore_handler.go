@@ -4,13 +4,16 @@ import (
 	"context"
 	"log/slog"
 	"slices"
-
-	"modernc.org/b/v2"
+	"strings"
+	"time"
 )
 
 // IgnoreHandlerOptions are options for a IgnoreHandler
 type IgnoreHandlerOptions struct {
-	// Comparison function to determine if two keys are equal
+	// Comparison function to determine if two keys are equal. Keys that compare
+	// as 0 are the same key, and are deduplicated. Unless KeyOrder is set, it
+	// also sorts the keys when OrderMode is OrderSorted, so it must be a
+	// consistent ordering of all keys. Defaults to CaseSensitiveCmp.
 	KeyCompare func(a, b string) int
 
 	// Function that will be called on each attribute and group, to determine
@@ -23,17 +26,53 @@ type IgnoreHandlerOptions struct {
 	//
 	// ResolveKey will not be called for the built-in fields on slog.Record
 	// (ie: time, level, msg, and source).
+	//
+	// ResolveKey is called on group names exactly as on attribute keys,
+	// regardless of whether the group came from WithGroup or slog.Group, but it
+	// is not called on the empty names of inlined groups.
+	// The index argument is 0 for this handler, unless RootCollisionMode or
+	// InlineCollisionMode is DedupIncrement.
 	ResolveKey func(groups []string, key string, _ int) (string, bool)
+
+	// CommonOptions are the options shared by all the handlers
+	CommonOptions
+
+	// TypeDisambiguate, if true, keeps both a group and an attribute that is not
+	// a group when they have the same key, instead of deduplicating them, by
+	// adding GroupKeySuffix to the group's key. For example, "user" keeps the
+	// attribute, while "user#group" holds the group. Groups and other attributes
+	// are still deduplicated among themselves.
+	TypeDisambiguate bool
+
+	// CollectAllKey, if not empty, is a suffix used to create an additional
+	// sidecar attribute for every duplicated key. The first value is still
+	// kept under the original key, so existing dashboards keep working, while
+	// the sidecar key holds an array of all values logged under that key,
+	// from oldest to newest. For example, if set to "#all", logging "arg1"
+	// twice results in "arg1" and "arg1#all".
+	// The suffix is added to the key after it has been resolved. A sidecar
+	// never replaces an attribute or group logged under the same key: if the
+	// key is already used, the values are not collected, and an attribute or
+	// group logged later under the key of a sidecar replaces the sidecar.
+	CollectAllKey string
+
+	// Prefer, if not nil, is called when an attribute has the same key as an
+	// existing attribute, instead of ignoring the newer attribute. It returns
+	// the value to keep, which enables max, min, or priority semantics, such as
+	// keeping the most severe of several levels. The kept value stays at the
+	// position of the existing attribute. It is only called when both the
+	// existing and the newer attributes are not groups.
+	Prefer func(existing, incoming slog.Value) slog.Value
 }
 
 // IgnoreHandler is a slog.Handler middleware that will deduplicate all attributes and
 // groups by ignoring any newer attributes or groups with the same string key as an older attribute.
 // It passes the final record and attributes off to the next handler when finished.
 type IgnoreHandler struct {
-	next       slog.Handler
-	goa        *groupOrAttrs
-	keyCompare func(a, b string) int
-	resolveKey func(groups []string, key string, _ int) (string, bool)
+	handlerConfig
+	cache         *goaCache
+	collectAllKey string
+	prefer        func(existing, incoming slog.Value) slog.Value
 }
 
 var _ slog.Handler = &IgnoreHandler{} // Assert conformance with interface
@@ -47,6 +86,10 @@ var _ slog.Handler = &IgnoreHandler{} // Assert conformance with interface
 //		Pipe(slogdedup.NewIgnoreMiddleware(&slogdedup.IgnoreHandlerOptions{})).
 //		Handler(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{})),
 //	))
+//
+// The middleware and its options can be shared, such as by the branches of a
+// slogmulti.Fanout, because each handler keeps its own groups and attributes,
+// and the options are never modified.
 func NewIgnoreMiddleware(options *IgnoreHandlerOptions) func(slog.Handler) slog.Handler {
 	return func(next slog.Handler) slog.Handler {
 		return NewIgnoreHandler(
@@ -60,22 +103,83 @@ func NewIgnoreMiddleware(options *IgnoreHandlerOptions) func(slog.Handler) slog.
 // groups by ignoring any newer attributes or groups with the same string key as an older attribute.
 // It passes the final record and attributes off to the next handler when finished.
 // If opts is nil, the default options are used.
+// If next is nil, all records are discarded instead of panicking.
 func NewIgnoreHandler(next slog.Handler, opts *IgnoreHandlerOptions) *IgnoreHandler {
 	if opts == nil {
 		opts = &IgnoreHandlerOptions{}
+	} else {
+		// Copy the options, so that options shared between handlers are not modified
+		copied := *opts
+		opts = &copied
 	}
 	if opts.KeyCompare == nil {
 		opts.KeyCompare = CaseSensitiveCmp
 	}
+	if opts.TextOrder && opts.OrderMode == OrderSorted {
+		opts.OrderMode = OrderInsertionStableDedup
+	}
+	if opts.HashOrder && opts.KeyOrder == nil {
+		opts.KeyOrder = HashKeyOrder
+	}
+	if opts.DropTime {
+		opts.EnsureTime = false
+	}
+	if opts.RequestIDKey == "" {
+		opts.RequestIDKey = "request_id"
+	}
 	if opts.ResolveKey == nil {
 		opts.ResolveKey = IncrementIfBuiltinKeyConflict
 	}
 
-	return &IgnoreHandler{
-		next:       next,
-		keyCompare: opts.KeyCompare,
-		resolveKey: opts.ResolveKey,
+	resolveKey := withReservedKeys(opts.ReservedOutputKeys, withPrometheusKeys(opts.SanitizeKeysPrometheus, withKeyMap(opts.KeyMap, opts.ResolveKey)))
+
+	h := &IgnoreHandler{
+		handlerConfig: handlerConfig{
+			next:               nextOrDiscard(next),
+			keyCompare:         opts.KeyCompare,
+			keyCompareForGroup: opts.KeyCompareForGroup,
+			keyOrder:           opts.KeyOrder,
+			resolveKey:         resolveKey,
+			orderMode:          opts.OrderMode,
+			depthGuard:         newGoaDepthGuard(opts.MaxGoaDepth),
+			unifyGroups:        opts.UnifyGroupSources,
+			typeDisambiguate:   opts.TypeDisambiguate,
+			rootCollider:       newCollider(opts.RootCollisionMode, resolveKey),
+			inlineCollider:     newCollider(opts.InlineCollisionMode, resolveKey),
+			ensureTime:         opts.EnsureTime,
+			dropTime:           opts.DropTime,
+			ensureKeys:         opts.EnsureKeys,
+			sortBuiltins:       opts.SortBuiltins || opts.BuiltinsLast,
+			builtinsLast:       opts.BuiltinsLast,
+			sample:             opts.SampleFunc,
+			sampleBeforeDedup:  opts.SampleBeforeDedup,
+			requestID:          opts.RequestIDFromContext,
+			requestIDKey:       opts.RequestIDKey,
+			sequence:           newSequencer(opts.SequenceKey),
+			modeTagKey:         opts.ModeTagKey,
+			groupDepthKey:      opts.GroupDepthKey,
+			fingerprint:        newFingerprinter(opts.FingerprintKey, opts.Hasher),
+			newStore:           newBtreeStore,
+			maxNodes:           opts.MaxNodes,
+			dedupSlices:        opts.DedupSliceValues,
+			splitKeys:          opts.SplitKeys,
+			timer:              opts.Timer,
+			sizeObserver:       opts.SizeObserver,
+			attrCountObserver:  opts.AttrCountObserver,
+			resolveMessage:     opts.ResolveMessage && !opts.SortBuiltins && !opts.BuiltinsLast,
+			largeValues:        newLargeValueMover(opts.LargeValueThreshold, opts.LargeValueGroup),
+			collapseSeparator:  newCollapseSeparator(opts.CollapseSingletonGroups, opts.CollapseSeparator),
+			parallelThreshold:  opts.ParallelThreshold,
+		},
+		cache:         newGoaCache(opts.CacheWithAttrs),
+		collectAllKey: opts.CollectAllKey,
+		prefer:        opts.Prefer,
 	}
+
+	// Records can only skip building the tree if no options need it
+	shortCircuit := opts.ShortCircuitUnique && h.canShortCircuit()
+	h.unique = newUniqueScanner(shortCircuit, resolveKey, opts.KeyCompare, opts.KeyCompareForGroup, opts.KeyOrder, opts.OrderMode)
+	return h
 }
 
 // Enabled reports whether the next handler handles records at the given level.
@@ -86,29 +190,98 @@ func (h *IgnoreHandler) Enabled(ctx context.Context, level slog.Level) bool {
 
 // Handle de-duplicates all attributes and groups, then passes the new set of attributes to the next handler.
 func (h *IgnoreHandler) Handle(ctx context.Context, r slog.Record) error {
+	var start time.Time
+	if h.timer != nil {
+		start = time.Now()
+	}
+
+	// Drop records that are not sampled, before paying to deduplicate them
+	if h.sample != nil && h.sampleBeforeDedup && !h.sample(r) {
+		return nil
+	}
+	if h.dropTime {
+		r.Time = time.Time{}
+	}
+
+	// Warn once if this logger has an excessively deep chain of With/WithGroup calls
+	h.depthGuard.check(ctx, h.next, h.goa)
+
 	// The final set of attributes on the record, is basically the same as a final With-Attributes groupOrAttrs.
 	// So collect all final attributes and turn them into a groupOrAttrs so that it can be handled the same.
-	finalAttrs := make([]slog.Attr, 0, r.NumAttrs())
+	finalAttrs := make([]slog.Attr, 0, r.NumAttrs()+1)
+	if h.requestID != nil {
+		if id := h.requestID(ctx); id != "" {
+			finalAttrs = append(finalAttrs, slog.String(h.requestIDKey, id))
+		}
+	}
 	r.Attrs(func(a slog.Attr) bool {
 		finalAttrs = append(finalAttrs, a)
 		return true
 	})
-	goas := collectGroupOrAttrs(h.goa, &groupOrAttrs{attrs: finalAttrs})
+	resolveParallel(finalAttrs, h.parallelThreshold, slog.Value.Resolve)
 
-	// Resolve groups and with-attributes
-	uniq := b.TreeNew[string, any](h.keyCompare)
-	h.createAttrTree(uniq, goas, nil)
+	// Skip building the tree if no keys are duplicated
+	if attrs, ok := h.unique.attrs(collectGroupOrAttrs(h.goa, &groupOrAttrs{attrs: finalAttrs})); ok {
+		return h.forward(ctx, r, r.Message, attrs, start)
+	}
 
+	// Resolve groups and with-attributes, followed by the final attributes
+	state, uniq := h.createRecordTree(finalAttrs)
+	h.largeValues.move(state, uniq, h.keyCompareFor)
+	state.addTruncated(uniq)
+	h.fingerprint.add(state, uniq, r)
+	h.sequence.add(state, uniq)
+	addModeTag(state, uniq, h.modeTagKey, DedupIgnore)
+	state.addGroupDepth(uniq, h.groupDepthKey)
+	ensureKeys(state, uniq, h.ensureKeys)
+	if h.sortBuiltins {
+		r = addBuiltins(state, uniq, r, h.ensureTime)
+	}
+	if h.sizeObserver != nil {
+		h.sizeObserver(len(r.Message) + estimateTreeSize(uniq))
+	}
+
+	// Optionally relocate the message, as if it were a root level attribute
+	msg, msgAttrs := r.Message, []slog.Attr(nil)
+	if h.resolveMessage {
+		msg, msgAttrs = resolveMessage(uniq, h.resolveKey, r.Message)
+	}
+
+	// Add deduplicated attributes back in
+	attrs := append(msgAttrs, buildAttrs(uniq, state.order, AppendedGroupMap)...)
+	if h.builtinsLast {
+		attrs = moveBuiltinsLast(attrs)
+	}
+	if h.collapseSeparator != "" {
+		attrs = collapseSingletonGroups(attrs, h.collapseSeparator)
+	}
+	return h.forward(ctx, r, msg, attrs, start)
+}
+
+// forward creates a new record with the message and the deduplicated
+// attributes, and passes it off to the next handler.
+func (h *IgnoreHandler) forward(ctx context.Context, r slog.Record, msg string, attrs []slog.Attr, start time.Time) error {
 	// Add all attributes to new record (because old record has all the old attributes)
 	newR := &slog.Record{
 		Time:    r.Time,
 		Level:   r.Level,
-		Message: r.Message,
+		Message: msg,
 		PC:      r.PC,
 	}
+	if h.ensureTime && !h.sortBuiltins && newR.Time.IsZero() {
+		newR.Time = time.Now()
+	}
 
-	// Add deduplicated attributes back in
-	newR.AddAttrs(buildAttrs(uniq)...)
+	if h.attrCountObserver != nil {
+		h.attrCountObserver(newR.Level, countAttrs(attrs))
+	}
+	newR.AddAttrs(attrs...)
+	if h.timer != nil {
+		h.timer(time.Since(start))
+	}
+	if h.sample != nil && !h.sampleBeforeDedup && !h.sample(*newR) {
+		return nil
+	}
 	return h.next.Handle(ctx, *newR)
 }
 
@@ -117,6 +290,8 @@ func (h *IgnoreHandler) Handle(ctx context.Context, r slog.Record) error {
 func (h *IgnoreHandler) WithGroup(name string) slog.Handler {
 	h2 := *h
 	h2.goa = h2.goa.WithGroup(name)
+	h2.cache = newGoaCache(h.cache != nil)
+	h2.depthGuard = h.depthGuard.derive()
 	return &h2
 }
 
@@ -124,86 +299,247 @@ func (h *IgnoreHandler) WithGroup(name string) slog.Handler {
 func (h *IgnoreHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
 	h2 := *h
 	h2.goa = h2.goa.WithAttrs(attrs)
+	h2.cache = newGoaCache(h.cache != nil)
+	h2.depthGuard = h.depthGuard.derive()
+	return &h2
+}
+
+// Clone returns a new IgnoreHandler with the same next handler, options, groups, and attributes as h.
+// The clone is independent of h, so calling WithAttrs or WithGroup on either one does not affect the other,
+// which is useful for building divergent trees of loggers from a single configured handler.
+func (h *IgnoreHandler) Clone() *IgnoreHandler {
+	h2 := *h
+	h2.cache = newGoaCache(h.cache != nil)
+	h2.depthGuard = h.depthGuard.derive()
 	return &h2
 }
 
+// WithMode returns a new handler that deduplicates according to the mode,
+// such as an AppendHandler for DedupAppend, keeping this handler's groups,
+// attributes, and the options that all handlers share. Options that are
+// specific to an IgnoreHandler are dropped, and those specific to the new handler
+// are left at their defaults. Returns this handler if the mode is
+// DedupDefault or DedupIgnore, and panics if the mode is unknown.
+func (h *IgnoreHandler) WithMode(mode DedupMode) slog.Handler {
+	if mode == DedupDefault || mode == DedupIgnore {
+		return h
+	}
+	return newHandlerWithMode(h.handlerConfig, h.cache != nil, mode)
+}
+
 // createAttrTree recursively goes through all groupOrAttrs, resolving their attributes and creating subtrees as
 // necessary, adding the results to the map
-func (h *IgnoreHandler) createAttrTree(uniq *b.Tree[string, any], goas []*groupOrAttrs, groups []string) {
+func (h *IgnoreHandler) createAttrTree(state *handleState, uniq attrStore, goas []*groupOrAttrs, groups []string) {
 	if len(goas) == 0 {
 		return
 	}
 
 	// If a group is encountered, create a subtree for that group and all groupOrAttrs after it
 	if goas[0].group != "" {
-		if key, ok := h.resolveKey(groups, goas[0].group, 0); ok {
-			uniqGroup := b.TreeNew[string, any](h.keyCompare)
-			h.createAttrTree(uniqGroup, goas[1:], append(slices.Clip(groups), key))
-			// Ignore empty groups, otherwise put subtree into the map
-			if uniqGroup.Len() > 0 {
-				// Put calls func(oldValue, true) if key already exists, or func(oldValue, false) if it doesn't.
-				// Then expects us to return (newValue, true) if replacing the oldValue, or (whatever, false) if not.
-				uniq.Put(key, func(oldValue any, exists bool) (any, bool) {
-					if exists {
-						return nil, false
-					}
-					return uniqGroup, true
-				})
+		if !state.visit(h.maxNodes) {
+			return // Over the budget, so drop the group and everything after it
+		}
+		if key, ok := h.resolveKeyIn(state, uniq, groups, goas[0].group); ok {
+			groupPath := append(slices.Clip(groups), key)
+			state.openGroup(len(groupPath))
+			uniqGroup, merged := subtreeFor(uniq, key, h.keyCompareFor(groupPath), h.unifyGroups)
+			deferred := state.deferGroup(uniq, uniqGroup, key, groupPath, merged)
+			h.createAttrTree(state, uniqGroup, goas[1:], groupPath)
+			// Ignore empty, merged, and deferred groups, otherwise put subtree into the map
+			if !deferred && !merged && uniqGroup.Len() > 0 {
+				h.putGroup(state, uniq, groups, key, uniqGroup)
 			}
 			return
 		}
 	}
 
 	// Otherwise, set all attributes for this groupOrAttrs, and then call again for remaining groupOrAttrs's
-	h.resolveValues(uniq, goas[0].attrs, groups)
-	h.createAttrTree(uniq, goas[1:], groups)
+	h.resolveValues(state, uniq, goas[0].attrs, groups)
+	h.createAttrTree(state, uniq, goas[1:], groups)
 }
 
 // resolveValues iterates through the attributes, resolving them and putting them into the map.
 // If a group is encountered (as an attribute), it will be separately resolved and added as a subtree.
 // Since attributes are ordered from oldest to newest, it ignores keys if they already exist.
-func (h *IgnoreHandler) resolveValues(uniq *b.Tree[string, any], attrs []slog.Attr, groups []string) {
+func (h *IgnoreHandler) resolveValues(state *handleState, uniq attrStore, attrs []slog.Attr, groups []string) {
 	var ok bool
 	for _, a := range attrs {
 		a.Value = a.Value.Resolve()
 		if a.Equal(slog.Attr{}) {
 			continue // Ignore empty attributes, and keep iterating
 		}
+		if !state.visit(h.maxNodes) {
+			return // Over the budget, so drop all remaining attributes
+		}
+
+		// Groups with empty keys are inlined, and have no key to resolve
+		if a.Key == "" && a.Value.Kind() == slog.KindGroup {
+			state.inline(groups, func() { h.resolveValues(state, uniq, a.Value.Group(), groups) })
+			continue
+		}
+
+		a.Value = splitValue(a.Value, h.splitKeys[a.Key])
+		a.Value = dedupSliceValue(a.Value, h.dedupSlices)
 
 		// Default situation: resolve the key and put it into the map
-		a.Key, ok = h.resolveKey(groups, a.Key, 0)
+		a.Key, ok = h.resolveKeyIn(state, uniq, groups, a.Key)
 		if !ok {
 			continue
 		}
 
+		if a.Value.Kind() != slog.KindGroup && h.typeDisambiguate {
+			moveGroupAside(state, uniq, a.Key)
+		}
+
+		if c := state.colliderFor(groups, h.rootCollider, h.inlineCollider); c != nil && a.Value.Kind() != slog.KindGroup {
+			c.put(state, uniq, a.Key, a)
+			continue
+		}
+
 		if a.Value.Kind() != slog.KindGroup {
-			uniq.Put(a.Key, func(oldValue any, exists bool) (any, bool) {
-				if exists {
+			if existing, written := uniq.Put(a.Key, func(oldValue any, exists bool) (any, bool) {
+				if exists && !h.isSidecar(a.Key, oldValue) {
 					return nil, false
 				}
 				return a, true
-			})
+			}); written {
+				state.order.touch(uniq, a.Key)
+			} else {
+				h.collectAll(state, uniq, a.Key, existing, a)
+				h.preferValue(uniq, existing, a)
+			}
 			continue
 		}
 
-		// Groups with empty keys are inlined
+		// Groups whose keys were resolved to empty are also inlined
 		if a.Key == "" {
-			h.resolveValues(uniq, a.Value.Group(), groups)
+			state.inline(groups, func() { h.resolveValues(state, uniq, a.Value.Group(), groups) })
 			continue
 		}
 
 		// Create a subtree for this group
-		uniqGroup := b.TreeNew[string, any](h.keyCompare)
-		h.resolveValues(uniqGroup, a.Value.Group(), append(slices.Clip(groups), a.Key))
+		uniqGroup, merged := subtreeFor(uniq, a.Key, h.keyCompareFor(append(slices.Clip(groups), a.Key)), h.unifyGroups)
+		h.resolveValues(state, uniqGroup, a.Value.Group(), append(slices.Clip(groups), a.Key))
 
-		// Ignore empty groups, otherwise put subtree into the map
-		if uniqGroup.Len() > 0 {
-			uniq.Put(a.Key, func(oldValue any, exists bool) (any, bool) {
-				if exists {
-					return nil, false
-				}
-				return uniqGroup, true
-			})
+		// Ignore empty and merged groups, otherwise put subtree into the map
+		if !merged && uniqGroup.Len() > 0 {
+			h.putGroup(state, uniq, groups, a.Key, uniqGroup)
+		}
+	}
+}
+
+// collectAll appends the ignored newer value to the sidecar attribute for the
+// key, creating the sidecar with the existing value if needed.
+// Does nothing unless CollectAllKey is set.
+func (h *IgnoreHandler) collectAll(state *handleState, uniq attrStore, key string, existing, ignored any) {
+	if h.collectAllKey == "" || h.isSidecar(key, existing) {
+		return
+	}
+	sidecarKey := key + h.collectAllKey
+	// Put calls func(oldValue, true) if key already exists, or func(oldValue, false) if it doesn't.
+	// Then expects us to return (newValue, true) if replacing the oldValue, or (whatever, false) if not.
+	if _, written := uniq.Put(sidecarKey, func(oldValue any, exists bool) (any, bool) {
+		if !exists {
+			if values, ok := existing.(appended); ok {
+				// The existing values were appended by a DedupAppend collision mode
+				return append(slices.Clone(values), ignored), true
+			}
+			return appended{existing, ignored}, true
 		}
+		if slice, ok := oldValue.(appended); ok {
+			return append(slice, ignored), true
+		}
+		return nil, false // The key is used by another attribute or group, which is kept
+	}); written {
+		state.order.touch(uniq, sidecarKey)
+	}
+}
+
+// isSidecar returns true if the value in the tree is a sidecar created by
+// collectAll. Sidecars are identified by their key ending in collectAllKey,
+// because a DedupAppend collision mode also puts appended values in the tree.
+func (h *IgnoreHandler) isSidecar(key string, v any) bool {
+	if h.collectAllKey == "" || len(key) <= len(h.collectAllKey) || !strings.HasSuffix(key, h.collectAllKey) {
+		return false
+	}
+	_, ok := v.(appended)
+	return ok
+}
+
+// preferValue replaces the value of the existing attribute with the value
+// returned by Prefer. Does nothing unless Prefer is set, or if the existing
+// value is a group.
+func (h *IgnoreHandler) preferValue(uniq attrStore, existing any, incoming slog.Attr) {
+	if h.prefer == nil {
+		return
+	}
+	if existingAttr, ok := existing.(slog.Attr); ok {
+		existingAttr.Value = h.prefer(existingAttr.Value, incoming.Value)
+		uniq.Set(incoming.Key, existingAttr)
+	}
+}
+
+// resolveKeyIn resolves the attribute key or group name within the group path.
+func (h *IgnoreHandler) resolveKeyIn(state *handleState, uniq attrStore, groups []string, key string) (string, bool) {
+	return resolveKeyIn(state, uniq, groups, key, h.rootCollider, h.inlineCollider, h.resolveKey, nil)
+}
+
+// keyCompareFor returns the function to compare keys within the group path,
+// which is nil for the root level.
+func (h *IgnoreHandler) keyCompareFor(groups []string) func(a, b string) int {
+	if h.keyCompareForGroup != nil {
+		if keyCompare := h.keyCompareForGroup(groups); keyCompare != nil {
+			return keyCompare
+		}
+	}
+	return h.keyCompare
+}
+
+// putGroup puts the subtree of the group into the map, unless the key already exists.
+func (h *IgnoreHandler) putGroup(state *handleState, uniq attrStore, groups []string, key string, uniqGroup attrStore) {
+	if h.typeDisambiguate {
+		key = disambiguateGroupKey(uniq, key)
+	}
+	if c := state.colliderFor(groups, h.rootCollider, h.inlineCollider); c != nil {
+		c.put(state, uniq, key, uniqGroup)
+		return
+	}
+	// Put calls func(oldValue, true) if key already exists, or func(oldValue, false) if it doesn't.
+	// Then expects us to return (newValue, true) if replacing the oldValue, or (whatever, false) if not.
+	if existing, written := uniq.Put(key, func(oldValue any, exists bool) (any, bool) {
+		if exists && !h.isSidecar(key, oldValue) {
+			return nil, false
+		}
+		return uniqGroup, true
+	}); written {
+		state.order.touch(uniq, key)
+	} else {
+		h.collectAll(state, uniq, key, existing, uniqGroup)
+	}
+}
+
+// createRecordTree resolves the groups and with-attributes, followed by the final attributes, into a new tree.
+// If caching, the tree of the groups and with-attributes is only created once, and then cloned for each record.
+func (h *IgnoreHandler) createRecordTree(finalAttrs []slog.Attr) (*handleState, attrStore) {
+	if h.cache == nil {
+		goas := collectGroupOrAttrs(h.goa, &groupOrAttrs{attrs: finalAttrs})
+		state, uniq := newAttrTree(h.newStore, h.orderMode, h.keyCompareFor(nil), h.keyOrder)
+		h.createAttrTree(state, uniq, goas, nil)
+		return state, uniq
+	}
+
+	template := h.cache.load(func() *goaTemplate {
+		state, uniq := newAttrTree(h.newStore, h.orderMode, h.keyCompareFor(nil), h.keyOrder)
+		state.deferGroups = true
+		h.createAttrTree(state, uniq, collectGroupOrAttrs(h.goa), nil)
+		return &goaTemplate{state: state, uniq: uniq}
+	})
+
+	state, uniq := template.clone(h.keyCompareFor)
+	if !state.dropped {
+		inner, groups := state.innermost(uniq)
+		h.resolveValues(state, inner, finalAttrs, groups)
 	}
+	state.closeGroups(h.putGroup)
+	return state, uniq
 }
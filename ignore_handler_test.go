@@ -96,3 +96,204 @@ func TestIgnoreHandler_ResolveBuiltinKeyConflict(t *testing.T) {
 
 	checkRecordForDuplicates(t, tester.Record)
 }
+
+/*
+	{
+	  "time": "2023-09-29T13:00:59Z",
+	  "level": "INFO",
+	  "msg": "collect all",
+	  "arg1": "val1",
+	  "arg1#all": ["val1", "val2", "val3"],
+	  "arg2": "val1",
+	  "group1": {
+	    "arg1": "val1",
+	    "arg1#all": ["val1", {"sub": "val2"}]
+	  }
+	}
+*/
+func TestIgnoreHandler_CollectAllKey(t *testing.T) {
+	t.Parallel()
+
+	tester := &testHandler{}
+	h := NewIgnoreHandler(tester, &IgnoreHandlerOptions{CollectAllKey: "#all"})
+
+	log := slog.New(h).With("arg1", "val1", "arg2", "val1")
+	log.Info("collect all", "arg1", "val2", "arg1", "val3", slog.Group("group1", "arg1", "val1", slog.Group("arg1", "sub", "val2")))
+
+	jBytes, err := tester.MarshalJSON()
+	if err != nil {
+		t.Errorf("Unable to marshal json: %v", err)
+	}
+	jStr := strings.TrimSpace(string(jBytes))
+
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"collect all","arg1":"val1","arg1#all":["val1","val2","val3"],"arg2":"val1","group1":{"arg1":"val1","arg1#all":["val1",{"sub":"val2"}]}}`
+	if jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+
+	checkRecordForDuplicates(t, tester.Record)
+}
+
+func TestIgnoreHandler_CollectAllKeyInlineAppend(t *testing.T) {
+	t.Parallel()
+
+	tester := &testHandler{}
+	h := NewIgnoreHandler(tester, &IgnoreHandlerOptions{CommonOptions: CommonOptions{InlineCollisionMode: DedupAppend}, CollectAllKey: "#all"})
+
+	// The values appended by the inline collision mode are not a sidecar, so the
+	// last arg1 is ignored and collected instead of replacing them
+	log := slog.New(h)
+	log.Info("collect all", "arg1", "val1", slog.Group("", "arg1", "val2"), "arg1", "val3")
+
+	jBytes, err := tester.MarshalJSON()
+	if err != nil {
+		t.Errorf("Unable to marshal json: %v", err)
+	}
+	jStr := strings.TrimSpace(string(jBytes))
+
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"collect all","arg1":["val1","val2"],"arg1#all":["val1","val2","val3"]}`
+	if jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+
+	checkRecordForDuplicates(t, tester.Record)
+}
+
+func TestIgnoreHandler_CollectAllKeyCollision(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		args     []any
+		expected string
+	}{
+		{
+			name:     "user key first",
+			args:     []any{"arg1#all", "user", "arg1", "val1", "arg1", "val2"},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"collect all","arg1":"val1","arg1#all":"user"}`,
+		},
+		{
+			name:     "user key last",
+			args:     []any{"arg1", "val1", "arg1", "val2", "arg1#all", "user", "arg1", "val3"},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"collect all","arg1":"val1","arg1#all":"user"}`,
+		},
+		{
+			name:     "user group",
+			args:     []any{"arg1", "val1", slog.Group("arg1#all", "sub", "user"), "arg1", "val2"},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"collect all","arg1":"val1","arg1#all":{"sub":"user"}}`,
+		},
+		{
+			name:     "resolved sidecar key",
+			args:     []any{"msg", "val1", "msg", "val2"},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"collect all","msg#01":"val1","msg#01#all":["val1","val2"]}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		log := slog.New(NewIgnoreHandler(tester, &IgnoreHandlerOptions{CollectAllKey: "#all"}))
+		log.Info("collect all", testCase.args...)
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != testCase.expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, testCase.expected, jStr)
+		}
+
+		checkRecordForDuplicates(t, tester.Record)
+	}
+}
+
+/*
+	{
+	  "time": "2023-09-29T13:00:59Z",
+	  "level": "INFO",
+	  "msg": "prefer",
+	  "arg1": "val3",
+	  "arg2": "val1",
+	  "group1": {
+	    "arg1": "zzz",
+	    "arg2": {"sub": "val2"}
+	  }
+	}
+*/
+func TestIgnoreHandler_Prefer(t *testing.T) {
+	t.Parallel()
+
+	// Keep the lexically largest string
+	prefer := func(existing, incoming slog.Value) slog.Value {
+		if incoming.String() > existing.String() {
+			return incoming
+		}
+		return existing
+	}
+
+	tester := &testHandler{}
+	h := NewIgnoreHandler(tester, &IgnoreHandlerOptions{Prefer: prefer, CommonOptions: CommonOptions{OrderMode: OrderInsertion}})
+
+	log := slog.New(h).With("arg1", "val1", "arg2", "val1")
+	log.Info("prefer", "arg1", "val3", "arg1", "val2", slog.Group("group1", "arg1", "zzz", "arg1", "aaa", slog.Group("arg2", "sub", "val2"), "arg2", "val3"))
+
+	jBytes, err := tester.MarshalJSON()
+	if err != nil {
+		t.Errorf("Unable to marshal json: %v", err)
+	}
+	jStr := strings.TrimSpace(string(jBytes))
+
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"prefer","arg1":"val3","arg2":"val1","group1":{"arg1":"zzz","arg2":{"sub":"val2"}}}`
+	if jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+
+	checkRecordForDuplicates(t, tester.Record)
+}
+
+func TestIgnoreHandler_OrderMode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		mode     OrderMode
+		expected string
+	}{
+		{
+			name:     "sorted",
+			mode:     OrderSorted,
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"order","arg1":"main1arg1","arg2":"with1arg2","group1":{"alpha":6,"beta":5},"zed":1}`,
+		},
+		{
+			name:     "insertion",
+			mode:     OrderInsertion,
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"order","zed":1,"arg2":"with1arg2","arg1":"main1arg1","group1":{"beta":5,"alpha":6}}`,
+		},
+		{
+			name:     "insertion stable dedup",
+			mode:     OrderInsertionStableDedup,
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"order","zed":1,"arg2":"with1arg2","arg1":"main1arg1","group1":{"beta":5,"alpha":6}}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		log := slog.New(NewIgnoreHandler(tester, &IgnoreHandlerOptions{CommonOptions: CommonOptions{OrderMode: testCase.mode}})).With("zed", 1, "arg2", "with1arg2")
+		log.Info("order", "arg1", "main1arg1", "zed", 2, slog.Group("group1", "beta", 5, "alpha", 6), "arg2", "main1arg2")
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != testCase.expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, testCase.expected, jStr)
+		}
+
+		checkRecordForDuplicates(t, tester.Record)
+	}
+}
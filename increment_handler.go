@@ -4,13 +4,16 @@ import (
 	"context"
 	"log/slog"
 	"slices"
-
-	"modernc.org/b/v2"
+	"strings"
+	"time"
 )
 
 // IncrementHandlerOptions are options for a IncrementHandler
 type IncrementHandlerOptions struct {
-	// Comparison function to determine if two keys are equal
+	// Comparison function to determine if two keys are equal. Keys that compare
+	// as 0 are the same key, and are deduplicated. Unless KeyOrder is set, it
+	// also sorts the keys when OrderMode is OrderSorted, so it must be a
+	// consistent ordering of all keys. Defaults to CaseSensitiveCmp.
 	KeyCompare func(a, b string) int
 
 	// Function that will be called on each attribute and group, to determine
@@ -23,23 +26,64 @@ type IncrementHandlerOptions struct {
 	// If the key is at the root level (groups is empty) and conflicts with a
 	// builtin key on the slog.Record object (time, level, msg, source), the
 	// index should be incremented before calculating the modified key string.
+	// If it returns the same key for the next index, such as when it ignores
+	// the index, the key is incremented the default way instead ("arg1#01"),
+	// so that no value is dropped.
 	//
 	// The first argument is a list of currently open groups that contain the
 	// Attr. It must not be retained or modified.
 	//
 	// ResolveKey will not be called for the built-in fields on slog.Record
 	// (ie: time, level, msg, and source).
+	//
+	// ResolveKey is called on group names exactly as on attribute keys,
+	// regardless of whether the group came from WithGroup or slog.Group, but it
+	// is not called on the empty names of inlined groups.
+	// The index is incremented for group names the same way as for attributes.
 	ResolveKey func(groups []string, key string, index int) (string, bool)
+
+	// CommonOptions are the options shared by all the handlers
+	CommonOptions
+
+	// ReserveIncrementSuffix determines what happens to keys that already end
+	// with an increment suffix, such as "foo#01", which could be confused with
+	// the keys generated when incrementing "foo". Keys are never overwritten.
+	//
+	// If false (the default), the keys coexist with the generated keys, and
+	// whichever key comes first is kept as-is, while any later key is
+	// incremented further. For example, "foo", "foo#01", "foo" become "foo",
+	// "foo#01", "foo#02", while "foo", "foo", "foo#01" become "foo", "foo#01",
+	// "foo#01#01".
+	//
+	// If true, the increment suffixes are reserved for generated keys, and
+	// keys that already end with one are always incremented, regardless of
+	// the order. For example, "foo", "foo#01", "foo" become "foo", "foo#01#01",
+	// "foo#01".
+	ReserveIncrementSuffix bool
+
+	// IncrementFirst, if true, adds an increment suffix to the first occurrence
+	// of every key too, so that all keys are uniform. For example, "foo", "foo"
+	// become "foo#00", "foo#01".
+	IncrementFirst bool
+
+	// IncrementStart is the number in the first increment suffix, which is on
+	// the first occurrence of a key if IncrementFirst is true, otherwise on the
+	// second. Defaults to 0 if IncrementFirst is true, otherwise 1.
+	// If either option is changed from its default, ResolveKey is only called
+	// with an index of 0, and the suffixes are added to the key it returns. If
+	// it only added an increment suffix, as IncrementIfBuiltinKeyConflict does
+	// for a key such as "msg", the suffixes replace that one, so the first
+	// user "msg" becomes "msg#00" instead of "msg#01#00".
+	IncrementStart int
 }
 
 // IncrementHandler is a slog.Handler middleware that will deduplicate all attributes and
 // groups by incrementing/modifying their key names.
 // It passes the final record and attributes off to the next handler when finished.
 type IncrementHandler struct {
-	next                slog.Handler
-	goa                 *groupOrAttrs
-	keyCompare          func(a, b string) int
-	resolveIncrementKey func(uniq *b.Tree[string, any], groups []string, key string) (string, bool)
+	handlerConfig
+	resolveIncrementKey func(uniq attrStore, groups []string, key string) (string, bool)
+	cache               *goaCache
 }
 
 var _ slog.Handler = &IncrementHandler{} // Assert conformance with interface
@@ -53,6 +97,10 @@ var _ slog.Handler = &IncrementHandler{} // Assert conformance with interface
 //		Pipe(slogdedup.NewIncrementMiddleware(&slogdedup.IncrementHandlerOptions{})).
 //		Handler(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{})),
 //	))
+//
+// The middleware and its options can be shared, such as by the branches of a
+// slogmulti.Fanout, because each handler keeps its own groups and attributes,
+// and the options are never modified.
 func NewIncrementMiddleware(options *IncrementHandlerOptions) func(slog.Handler) slog.Handler {
 	return func(next slog.Handler) slog.Handler {
 		return NewIncrementHandler(
@@ -66,22 +114,89 @@ func NewIncrementMiddleware(options *IncrementHandlerOptions) func(slog.Handler)
 // groups by incrementing/modifying their key names.
 // It passes the final record and attributes off to the next handler when finished.
 // If opts is nil, the default options are used.
+// If next is nil, all records are discarded instead of panicking.
 func NewIncrementHandler(next slog.Handler, opts *IncrementHandlerOptions) *IncrementHandler {
 	if opts == nil {
 		opts = &IncrementHandlerOptions{}
+	} else {
+		// Copy the options, so that options shared between handlers are not modified
+		copied := *opts
+		opts = &copied
 	}
 	if opts.KeyCompare == nil {
 		opts.KeyCompare = CaseSensitiveCmp
 	}
+	if opts.TextOrder && opts.OrderMode == OrderSorted {
+		opts.OrderMode = OrderInsertionStableDedup
+	}
+	if opts.HashOrder && opts.KeyOrder == nil {
+		opts.KeyOrder = HashKeyOrder
+	}
+	if opts.DropTime {
+		opts.EnsureTime = false
+	}
+	if opts.RequestIDKey == "" {
+		opts.RequestIDKey = "request_id"
+	}
 	if opts.ResolveKey == nil {
 		opts.ResolveKey = IncrementIfBuiltinKeyConflict
 	}
+	if opts.IncrementStart == 0 && !opts.IncrementFirst {
+		opts.IncrementStart = 1
+	}
+
+	// The increment suffixes are added after the key is resolved, so the output
+	// rewriting is kept separate, to be applied after them too
+	baseResolveKey := withKeyMap(opts.KeyMap, opts.ResolveKey)
+	rewriteKey := rewriteResolvedKey(opts.SanitizeKeysPrometheus, opts.ReservedOutputKeys)
+	resolveKey := withReservedKeys(opts.ReservedOutputKeys, withPrometheusKeys(opts.SanitizeKeysPrometheus, baseResolveKey))
 
-	return &IncrementHandler{
-		next:                next,
-		keyCompare:          opts.KeyCompare,
-		resolveIncrementKey: resolveIncrementKeyClosure(opts.ResolveKey),
+	h := &IncrementHandler{
+		handlerConfig: handlerConfig{
+			next:               nextOrDiscard(next),
+			keyCompare:         opts.KeyCompare,
+			keyCompareForGroup: opts.KeyCompareForGroup,
+			keyOrder:           opts.KeyOrder,
+			resolveKey:         resolveKey,
+			orderMode:          opts.OrderMode,
+			depthGuard:         newGoaDepthGuard(opts.MaxGoaDepth),
+			unifyGroups:        opts.UnifyGroupSources,
+			rootCollider:       newCollider(opts.RootCollisionMode, resolveKey),
+			inlineCollider:     newCollider(opts.InlineCollisionMode, resolveKey),
+			ensureTime:         opts.EnsureTime,
+			dropTime:           opts.DropTime,
+			ensureKeys:         opts.EnsureKeys,
+			sortBuiltins:       opts.SortBuiltins || opts.BuiltinsLast,
+			builtinsLast:       opts.BuiltinsLast,
+			sample:             opts.SampleFunc,
+			sampleBeforeDedup:  opts.SampleBeforeDedup,
+			requestID:          opts.RequestIDFromContext,
+			requestIDKey:       opts.RequestIDKey,
+			sequence:           newSequencer(opts.SequenceKey),
+			modeTagKey:         opts.ModeTagKey,
+			groupDepthKey:      opts.GroupDepthKey,
+			fingerprint:        newFingerprinter(opts.FingerprintKey, opts.Hasher),
+			newStore:           newBtreeStore,
+			maxNodes:           opts.MaxNodes,
+			dedupSlices:        opts.DedupSliceValues,
+			splitKeys:          opts.SplitKeys,
+			timer:              opts.Timer,
+			sizeObserver:       opts.SizeObserver,
+			attrCountObserver:  opts.AttrCountObserver,
+			resolveMessage:     opts.ResolveMessage && !opts.SortBuiltins && !opts.BuiltinsLast,
+			largeValues:        newLargeValueMover(opts.LargeValueThreshold, opts.LargeValueGroup),
+			collapseSeparator:  newCollapseSeparator(opts.CollapseSingletonGroups, opts.CollapseSeparator),
+			parallelThreshold:  opts.ParallelThreshold,
+		},
+		resolveIncrementKey: resolveIncrementKeyClosure(baseResolveKey, rewriteKey, opts.ReserveIncrementSuffix, opts.IncrementFirst, opts.IncrementStart),
+		cache:               newGoaCache(opts.CacheWithAttrs),
 	}
+
+	// Records can only skip building the tree if no options need it
+	shortCircuit := opts.ShortCircuitUnique && h.canShortCircuit() &&
+		!opts.ReserveIncrementSuffix && !opts.IncrementFirst && opts.IncrementStart == 1
+	h.unique = newUniqueScanner(shortCircuit, resolveKey, opts.KeyCompare, opts.KeyCompareForGroup, opts.KeyOrder, opts.OrderMode)
+	return h
 }
 
 // Enabled reports whether the next handler handles records at the given level.
@@ -92,29 +207,98 @@ func (h *IncrementHandler) Enabled(ctx context.Context, level slog.Level) bool {
 
 // Handle de-duplicates all attributes and groups, then passes the new set of attributes to the next handler.
 func (h *IncrementHandler) Handle(ctx context.Context, r slog.Record) error {
+	var start time.Time
+	if h.timer != nil {
+		start = time.Now()
+	}
+
+	// Drop records that are not sampled, before paying to deduplicate them
+	if h.sample != nil && h.sampleBeforeDedup && !h.sample(r) {
+		return nil
+	}
+	if h.dropTime {
+		r.Time = time.Time{}
+	}
+
+	// Warn once if this logger has an excessively deep chain of With/WithGroup calls
+	h.depthGuard.check(ctx, h.next, h.goa)
+
 	// The final set of attributes on the record, is basically the same as a final With-Attributes groupOrAttrs.
 	// So collect all final attributes and turn them into a groupOrAttrs so that it can be handled the same.
-	finalAttrs := make([]slog.Attr, 0, r.NumAttrs())
+	finalAttrs := make([]slog.Attr, 0, r.NumAttrs()+1)
+	if h.requestID != nil {
+		if id := h.requestID(ctx); id != "" {
+			finalAttrs = append(finalAttrs, slog.String(h.requestIDKey, id))
+		}
+	}
 	r.Attrs(func(a slog.Attr) bool {
 		finalAttrs = append(finalAttrs, a)
 		return true
 	})
-	goas := collectGroupOrAttrs(h.goa, &groupOrAttrs{attrs: finalAttrs})
+	resolveParallel(finalAttrs, h.parallelThreshold, slog.Value.Resolve)
 
-	// Resolve groups and with-attributes
-	uniq := b.TreeNew[string, any](h.keyCompare)
-	h.createAttrTree(uniq, goas, nil)
+	// Skip building the tree if no keys are duplicated
+	if attrs, ok := h.unique.attrs(collectGroupOrAttrs(h.goa, &groupOrAttrs{attrs: finalAttrs})); ok {
+		return h.forward(ctx, r, r.Message, attrs, start)
+	}
 
+	// Resolve groups and with-attributes, followed by the final attributes
+	state, uniq := h.createRecordTree(finalAttrs)
+	h.largeValues.move(state, uniq, h.keyCompareFor)
+	state.addTruncated(uniq)
+	h.fingerprint.add(state, uniq, r)
+	h.sequence.add(state, uniq)
+	addModeTag(state, uniq, h.modeTagKey, DedupIncrement)
+	state.addGroupDepth(uniq, h.groupDepthKey)
+	ensureKeys(state, uniq, h.ensureKeys)
+	if h.sortBuiltins {
+		r = addBuiltins(state, uniq, r, h.ensureTime)
+	}
+	if h.sizeObserver != nil {
+		h.sizeObserver(len(r.Message) + estimateTreeSize(uniq))
+	}
+
+	// Optionally relocate the message, as if it were a root level attribute
+	msg, msgAttrs := r.Message, []slog.Attr(nil)
+	if h.resolveMessage {
+		msg, msgAttrs = resolveMessage(uniq, h.resolveKey, r.Message)
+	}
+
+	// Add deduplicated attributes back in
+	attrs := append(msgAttrs, buildAttrs(uniq, state.order, AppendedGroupMap)...)
+	if h.builtinsLast {
+		attrs = moveBuiltinsLast(attrs)
+	}
+	if h.collapseSeparator != "" {
+		attrs = collapseSingletonGroups(attrs, h.collapseSeparator)
+	}
+	return h.forward(ctx, r, msg, attrs, start)
+}
+
+// forward creates a new record with the message and the deduplicated
+// attributes, and passes it off to the next handler.
+func (h *IncrementHandler) forward(ctx context.Context, r slog.Record, msg string, attrs []slog.Attr, start time.Time) error {
 	// Add all attributes to new record (because old record has all the old attributes)
 	newR := &slog.Record{
 		Time:    r.Time,
 		Level:   r.Level,
-		Message: r.Message,
+		Message: msg,
 		PC:      r.PC,
 	}
+	if h.ensureTime && !h.sortBuiltins && newR.Time.IsZero() {
+		newR.Time = time.Now()
+	}
 
-	// Add deduplicated attributes back in
-	newR.AddAttrs(buildAttrs(uniq)...)
+	if h.attrCountObserver != nil {
+		h.attrCountObserver(newR.Level, countAttrs(attrs))
+	}
+	newR.AddAttrs(attrs...)
+	if h.timer != nil {
+		h.timer(time.Since(start))
+	}
+	if h.sample != nil && !h.sampleBeforeDedup && !h.sample(*newR) {
+		return nil
+	}
 	return h.next.Handle(ctx, *newR)
 }
 
@@ -123,6 +307,8 @@ func (h *IncrementHandler) Handle(ctx context.Context, r slog.Record) error {
 func (h *IncrementHandler) WithGroup(name string) slog.Handler {
 	h2 := *h
 	h2.goa = h2.goa.WithGroup(name)
+	h2.cache = newGoaCache(h.cache != nil)
+	h2.depthGuard = h.depthGuard.derive()
 	return &h2
 }
 
@@ -130,94 +316,297 @@ func (h *IncrementHandler) WithGroup(name string) slog.Handler {
 func (h *IncrementHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
 	h2 := *h
 	h2.goa = h2.goa.WithAttrs(attrs)
+	h2.cache = newGoaCache(h.cache != nil)
+	h2.depthGuard = h.depthGuard.derive()
+	return &h2
+}
+
+// Clone returns a new IncrementHandler with the same next handler, options, groups, and attributes as h.
+// The clone is independent of h, so calling WithAttrs or WithGroup on either one does not affect the other,
+// which is useful for building divergent trees of loggers from a single configured handler.
+func (h *IncrementHandler) Clone() *IncrementHandler {
+	h2 := *h
+	h2.cache = newGoaCache(h.cache != nil)
+	h2.depthGuard = h.depthGuard.derive()
 	return &h2
 }
 
+// WithMode returns a new handler that deduplicates according to the mode,
+// such as an AppendHandler for DedupAppend, keeping this handler's groups,
+// attributes, and the options that all handlers share. Options that are
+// specific to an IncrementHandler are dropped, and those specific to the new handler
+// are left at their defaults. Returns this handler if the mode is
+// DedupDefault or DedupIncrement, and panics if the mode is unknown.
+func (h *IncrementHandler) WithMode(mode DedupMode) slog.Handler {
+	if mode == DedupDefault || mode == DedupIncrement {
+		return h
+	}
+	return newHandlerWithMode(h.handlerConfig, h.cache != nil, mode)
+}
+
 // createAttrTree recursively goes through all groupOrAttrs, resolving their attributes and creating subtrees as
 // necessary, adding the results to the map
-func (h *IncrementHandler) createAttrTree(uniq *b.Tree[string, any], goas []*groupOrAttrs, groups []string) {
+func (h *IncrementHandler) createAttrTree(state *handleState, uniq attrStore, goas []*groupOrAttrs, groups []string) {
 	if len(goas) == 0 {
 		return
 	}
 
 	// If a group is encountered, create a subtree for that group and all groupOrAttrs after it
 	if goas[0].group != "" {
-		if key, keep := h.resolveIncrementKey(uniq, groups, goas[0].group); keep {
-			uniqGroup := b.TreeNew[string, any](h.keyCompare)
-			h.createAttrTree(uniqGroup, goas[1:], append(slices.Clip(groups), key))
-			// Ignore empty groups, otherwise put subtree into the map
-			if uniqGroup.Len() > 0 {
-				uniq.Set(key, uniqGroup)
+		if !state.visit(h.maxNodes) {
+			return // Over the budget, so drop the group and everything after it
+		}
+		// If unifying groups, merge into any existing group with the same (un-incremented) key
+		if existing, key, ok := h.existingSubtree(uniq, groups, goas[0].group); ok {
+			groupPath := append(slices.Clip(groups), key)
+			state.openGroup(len(groupPath))
+			state.deferGroup(uniq, existing, key, groupPath, true)
+			h.createAttrTree(state, existing, goas[1:], groupPath)
+			return
+		}
+		if key, keep := h.resolveKeyIn(state, uniq, groups, goas[0].group); keep {
+			groupPath := append(slices.Clip(groups), key)
+			state.openGroup(len(groupPath))
+			uniqGroup := uniq.New(h.keyCompareFor(groupPath))
+			deferred := state.deferGroup(uniq, uniqGroup, key, groupPath, false)
+			h.createAttrTree(state, uniqGroup, goas[1:], groupPath)
+			// Ignore empty and deferred groups, otherwise put subtree into the map
+			if !deferred && uniqGroup.Len() > 0 {
+				h.putGroup(state, uniq, groups, key, uniqGroup)
 			}
 			return
 		}
 	}
 
 	// Otherwise, set all attributes for this groupOrAttrs, and then call again for remaining groupOrAttrs's
-	h.resolveValues(uniq, goas[0].attrs, groups)
-	h.createAttrTree(uniq, goas[1:], groups)
+	h.resolveValues(state, uniq, goas[0].attrs, groups)
+	h.createAttrTree(state, uniq, goas[1:], groups)
 }
 
 // resolveValues iterates through the attributes, resolving them and putting them into the map.
 // If a group is encountered (as an attribute), it will be separately resolved and added as a subtree.
 // Since attributes are ordered from oldest to newest, it increments the key names as it goes.
-func (h *IncrementHandler) resolveValues(uniq *b.Tree[string, any], attrs []slog.Attr, groups []string) {
+func (h *IncrementHandler) resolveValues(state *handleState, uniq attrStore, attrs []slog.Attr, groups []string) {
 	var ok bool
 	for _, a := range attrs {
 		a.Value = a.Value.Resolve()
 		if a.Equal(slog.Attr{}) {
 			continue // Ignore empty attributes, and keep iterating
 		}
+		if !state.visit(h.maxNodes) {
+			return // Over the budget, so drop all remaining attributes
+		}
+
+		// Groups with empty keys are inlined, and have no key to resolve
+		if a.Key == "" && a.Value.Kind() == slog.KindGroup {
+			state.inline(groups, func() { h.resolveValues(state, uniq, a.Value.Group(), groups) })
+			continue
+		}
+
+		// If unifying groups, merge into any existing group with the same (un-incremented) key
+		if a.Value.Kind() == slog.KindGroup && a.Key != "" {
+			if existing, key, ok := h.existingSubtree(uniq, groups, a.Key); ok {
+				h.resolveValues(state, existing, a.Value.Group(), append(slices.Clip(groups), key))
+				continue
+			}
+		}
+
+		a.Value = splitValue(a.Value, h.splitKeys[a.Key])
+		a.Value = dedupSliceValue(a.Value, h.dedupSlices)
 
 		// Default situation: resolve the key and put it into the map
-		a.Key, ok = h.resolveIncrementKey(uniq, groups, a.Key)
+		a.Key, ok = h.resolveKeyIn(state, uniq, groups, a.Key)
 		if !ok {
 			continue
 		}
 
+		if c := state.colliderFor(groups, h.rootCollider, h.inlineCollider); c != nil && a.Value.Kind() != slog.KindGroup {
+			c.put(state, uniq, a.Key, a)
+			continue
+		}
+
 		if a.Value.Kind() != slog.KindGroup {
 			uniq.Set(a.Key, a)
+			state.order.touch(uniq, a.Key)
 			continue
 		}
 
-		// Groups with empty keys are inlined
+		// Groups whose keys were resolved to empty are also inlined
 		if a.Key == "" {
-			h.resolveValues(uniq, a.Value.Group(), groups)
+			state.inline(groups, func() { h.resolveValues(state, uniq, a.Value.Group(), groups) })
 			continue
 		}
 
 		// Create a subtree for this group
-		uniqGroup := b.TreeNew[string, any](h.keyCompare)
-		h.resolveValues(uniqGroup, a.Value.Group(), append(slices.Clip(groups), a.Key))
+		uniqGroup := uniq.New(h.keyCompareFor(append(slices.Clip(groups), a.Key)))
+		h.resolveValues(state, uniqGroup, a.Value.Group(), append(slices.Clip(groups), a.Key))
 
 		// Ignore empty groups, otherwise put subtree into the map
 		if uniqGroup.Len() > 0 {
-			uniq.Set(a.Key, uniqGroup)
+			h.putGroup(state, uniq, groups, a.Key, uniqGroup)
 		}
 	}
 }
 
+// existingSubtree returns the existing subtree and its resolved key, if
+// unifying group sources and the (un-incremented) key already holds a subtree.
+func (h *IncrementHandler) existingSubtree(uniq attrStore, groups []string, key string) (attrStore, string, bool) {
+	if !h.unifyGroups {
+		return nil, "", false
+	}
+	key, keep := h.resolveKey(groups, key, 0)
+	if !keep {
+		return nil, "", false
+	}
+	existing, merged := subtreeFor(uniq, key, h.keyCompare, true)
+	return existing, key, merged
+}
+
+// putGroup puts the subtree of the group into the map, under its already incremented key.
+func (h *IncrementHandler) putGroup(state *handleState, uniq attrStore, groups []string, key string, uniqGroup attrStore) {
+	if c := state.colliderFor(groups, h.rootCollider, h.inlineCollider); c != nil {
+		c.put(state, uniq, key, uniqGroup)
+		return
+	}
+	uniq.Set(key, uniqGroup)
+	state.order.touch(uniq, key)
+}
+
+// createRecordTree resolves the groups and with-attributes, followed by the final attributes, into a new tree.
+// If caching, the tree of the groups and with-attributes is only created once, and then cloned for each record.
+func (h *IncrementHandler) createRecordTree(finalAttrs []slog.Attr) (*handleState, attrStore) {
+	if h.cache == nil {
+		goas := collectGroupOrAttrs(h.goa, &groupOrAttrs{attrs: finalAttrs})
+		state, uniq := newAttrTree(h.newStore, h.orderMode, h.keyCompareFor(nil), h.keyOrder)
+		h.createAttrTree(state, uniq, goas, nil)
+		return state, uniq
+	}
+
+	template := h.cache.load(func() *goaTemplate {
+		state, uniq := newAttrTree(h.newStore, h.orderMode, h.keyCompareFor(nil), h.keyOrder)
+		state.deferGroups = true
+		h.createAttrTree(state, uniq, collectGroupOrAttrs(h.goa), nil)
+		return &goaTemplate{state: state, uniq: uniq}
+	})
+
+	state, uniq := template.clone(h.keyCompareFor)
+	inner, groups := state.innermost(uniq)
+	h.resolveValues(state, inner, finalAttrs, groups)
+	state.closeGroups(h.putGroup)
+	return state, uniq
+}
+
 // resolveIncrementKeyClosure returns a function to be used to resolve a key for IncrementHandler.
-func resolveIncrementKeyClosure(resolveKey func(groups []string, key string, index int) (string, bool)) func(uniq *b.Tree[string, any], groups []string, key string) (string, bool) {
-	return func(uniq *b.Tree[string, any], groups []string, key string) (string, bool) {
+// If reserveSuffix is true, keys that already end with an increment suffix start at the first increment.
+// If first is true or start is not 1, the increment suffixes are added to the key resolved with an index of 0,
+// starting from the number start, on the first occurrence if first is true.
+// If rewriteKey is not nil, it rewrites each key after resolveKey and after any suffix is added, so that
+// output rewriting such as SanitizeKeysPrometheus also applies to the suffixes.
+func resolveIncrementKeyClosure(resolveKey, rewriteKey func(groups []string, key string, index int) (string, bool), reserveSuffix, first bool, start int) func(uniq attrStore, groups []string, key string) (string, bool) {
+	if first || start != 1 {
+		return func(uniq attrStore, groups []string, key string) (string, bool) {
+			resolved, keep := resolveKey(groups, key, 0)
+			if !keep {
+				return "", false
+			}
+			// If ResolveKey only added an increment suffix, such as "msg#01" for
+			// a key that conflicts with a builtin, the key is already taken
+			// without one, so our suffix replaces it, starting at the start.
+			always := first
+			if resolved != key && hasIncrementSuffix(resolved) && strings.LastIndexByte(resolved, '#') == len(key) {
+				resolved, always = key, true
+			}
+			offset := 0
+			if reserveSuffix && hasIncrementSuffix(key) {
+				offset = 1
+			}
+			// Bounded like freeIncrementKey, in case the tree's key comparison
+			// treats the suffixed keys as the same key
+			var newKey string
+			for index := offset; index <= offset+uniq.Len(); index++ {
+				newKey = resolved
+				if always {
+					newKey = formatIncrement(resolved, start+index)
+				} else if index > 0 {
+					newKey = formatIncrement(resolved, start+index-1)
+				}
+				if rewriteKey != nil {
+					newKey, _ = rewriteKey(groups, newKey, 0)
+				}
+				if _, ok := uniq.Get(newKey); !ok {
+					break
+				}
+			}
+			return newKey, true
+		}
+	}
+
+	if rewriteKey != nil {
+		baseResolveKey := resolveKey
+		resolveKey = func(groups []string, key string, index int) (string, bool) {
+			key, keep := baseResolveKey(groups, key, index)
+			if !keep {
+				return "", false
+			}
+			return rewriteKey(groups, key, 0)
+		}
+	}
+
+	return func(uniq attrStore, groups []string, key string) (string, bool) {
 		var index int
+		if reserveSuffix && hasIncrementSuffix(key) {
+			index = 1
+		}
 		newKey, keep := resolveKey(groups, key, index)
 
-		// Seek cursor to the key in the map equal to or less than newKey
-		en, _ := uniq.Seek(newKey)
-		defer en.Close()
-
-		// If the next key is missing (io.EOF) or is greater than newKey, return newKey
-		for {
-			k, _, err := en.Next()
-			if err != nil || k > newKey {
-				return newKey, keep
+		// Query the map directly for each candidate, rather than relying on how
+		// incremented keys sort, so that any ResolveKey naming scheme works and
+		// the tree's own key comparison function is used, which may differ
+		// between groups. If the key already exists, we must increment our key
+		for keep {
+			if _, ok := uniq.Get(newKey); !ok {
+				return newKey, true
 			}
-			if k == newKey {
-				// If the next key is equal to newKey, we must increment our key
-				index++
-				newKey, keep = resolveKey(groups, key, index)
+			index++
+			prevKey := newKey
+			newKey, keep = resolveKey(groups, key, index)
+			if keep && newKey == prevKey {
+				// ResolveKey ignores the index, so the key would never be free.
+				// Keep the value by incrementing the key the default way instead.
+				return freeIncrementKey(uniq, newKey), true
 			}
 		}
+		return "", false
+	}
+}
+
+// freeIncrementKey returns the first incremented form of the key, such as
+// "arg1#01", that is not already in the tree. If the tree's key comparison
+// treats the incremented forms as the same key, so that none is free, the last
+// one tried is returned, and its value is overwritten.
+func freeIncrementKey(uniq attrStore, key string) string {
+	var newKey string
+	for index := 1; index <= uniq.Len()+1; index++ {
+		newKey = incrementKeyName(key, index)
+		if _, ok := uniq.Get(newKey); !ok {
+			break
+		}
+	}
+	return newKey
+}
+
+// resolveKeyIn resolves the attribute key or group name within the group path.
+func (h *IncrementHandler) resolveKeyIn(state *handleState, uniq attrStore, groups []string, key string) (string, bool) {
+	return resolveKeyIn(state, uniq, groups, key, h.rootCollider, h.inlineCollider, h.resolveKey, h.resolveIncrementKey)
+}
+
+// keyCompareFor returns the function to compare keys within the group path,
+// which is nil for the root level.
+func (h *IncrementHandler) keyCompareFor(groups []string) func(a, b string) int {
+	if h.keyCompareForGroup != nil {
+		if keyCompare := h.keyCompareForGroup(groups); keyCompare != nil {
+			return keyCompare
+		}
 	}
+	return h.keyCompare
 }
@@ -1,10 +1,12 @@
 package slogdedup
 
 import (
+	"fmt"
 	"log/slog"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 /*
@@ -141,3 +143,292 @@ func TestIncrementHandler_DoesKeyConflict_IncrementKeyName(t *testing.T) {
 
 	checkRecordForDuplicates(t, tester.Record)
 }
+
+func TestIncrementHandler_ReserveIncrementSuffix(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		reserve  bool
+		args     []any
+		expected string
+	}{
+		{
+			reserve:  false,
+			args:     []any{"foo", "first", "foo#01", "user", "foo", "second"},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"suffix","foo":"first","foo#01":"user","foo#02":"second"}`,
+		},
+		{
+			reserve:  false,
+			args:     []any{"foo", "first", "foo", "second", "foo#01", "user"},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"suffix","foo":"first","foo#01":"second","foo#01#01":"user"}`,
+		},
+		{
+			reserve:  true,
+			args:     []any{"foo", "first", "foo#01", "user", "foo", "second"},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"suffix","foo":"first","foo#01":"second","foo#01#01":"user"}`,
+		},
+		{
+			reserve:  true,
+			args:     []any{"foo", "first", "foo", "second", "foo#01", "user"},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"suffix","foo":"first","foo#01":"second","foo#01#01":"user"}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		slog.New(NewIncrementHandler(tester, &IncrementHandlerOptions{ReserveIncrementSuffix: testCase.reserve})).Info("suffix", testCase.args...)
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != testCase.expected {
+			t.Errorf("reserve=%t %v Expected:\n%s\nGot:\n%s", testCase.reserve, testCase.args, testCase.expected, jStr)
+		}
+
+		checkRecordForDuplicates(t, tester.Record)
+	}
+}
+
+func TestIncrementHandler_PrefixIncrementKeyName(t *testing.T) {
+	t.Parallel()
+
+	// Prefixed keys sort before the base key, unlike the default suffixes
+	resolveKey := func(groups []string, key string, index int) (string, bool) {
+		if len(groups) == 0 && doesBuiltinKeyConflict(key) {
+			index++
+		}
+		if index == 0 {
+			return key, true
+		}
+		return fmt.Sprintf("%d_%s", index, key), true
+	}
+
+	tester := &testHandler{}
+	log := slog.New(NewIncrementHandler(tester, &IncrementHandlerOptions{ResolveKey: resolveKey})).With("arg1", "with1", "1_arg1", "user")
+	log.Info("prefix", "arg1", "main1", "arg1", "main2", "msg", "main3")
+
+	jBytes, err := tester.MarshalJSON()
+	if err != nil {
+		t.Errorf("Unable to marshal json: %v", err)
+	}
+	jStr := strings.TrimSpace(string(jBytes))
+
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"prefix","1_arg1":"user","1_msg":"main3","2_arg1":"main1","3_arg1":"main2","arg1":"with1"}`
+	if jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+
+	checkRecordForDuplicates(t, tester.Record)
+}
+
+func TestIncrementHandler_IncrementFirst(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		opts     *IncrementHandlerOptions
+		expected string
+	}{
+		{
+			opts:     &IncrementHandlerOptions{IncrementFirst: true},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"first","arg1#00":"with1","arg1#01":"main1","arg2#00":"main2","group1#00":{"arg3#00":"main3","arg3#01":"main4"}}`,
+		},
+		{
+			opts:     &IncrementHandlerOptions{IncrementStart: 5},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"first","arg1":"with1","arg1#05":"main1","arg2":"main2","group1":{"arg3":"main3","arg3#05":"main4"}}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		log := slog.New(NewIncrementHandler(tester, testCase.opts)).With("arg1", "with1")
+		log.Info("first", "arg1", "main1", "arg2", "main2", slog.Group("group1", "arg3", "main3", "arg3", "main4"))
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != testCase.expected {
+			t.Errorf("Expected:\n%s\nGot:\n%s", testCase.expected, jStr)
+		}
+
+		checkRecordForDuplicates(t, tester.Record)
+	}
+
+	// A key that conflicts with a builtin only gets one increment suffix
+	builtinTests := []struct {
+		opts     *IncrementHandlerOptions
+		expected string
+	}{
+		{
+			opts:     &IncrementHandlerOptions{IncrementFirst: true},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"m","a#00":1,"a#01":2,"msg#00":"x","msg#01":"y"}`,
+		},
+		{
+			opts:     &IncrementHandlerOptions{IncrementStart: 5},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"m","a":1,"a#05":2,"msg#05":"x","msg#06":"y"}`,
+		},
+	}
+
+	for _, testCase := range builtinTests {
+		tester := &testHandler{}
+		slog.New(NewIncrementHandler(tester, testCase.opts)).Info("m", "msg", "x", "a", 1, "a", 2, "msg", "y")
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != testCase.expected {
+			t.Errorf("Expected:\n%s\nGot:\n%s", testCase.expected, jStr)
+		}
+
+		checkRecordForDuplicates(t, tester.Record)
+	}
+}
+
+func TestIncrementHandler_IncrementFirstRewritesSuffix(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		opts     *IncrementHandlerOptions
+		expected string
+	}{
+		{
+			name:     "prometheus",
+			opts:     &IncrementHandlerOptions{IncrementFirst: true, CommonOptions: CommonOptions{SanitizeKeysPrometheus: true}},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"rewrite","a_b_00":1,"a_b_01":2,"msg_00":"x"}`,
+		},
+		{
+			name:     "prometheus start",
+			opts:     &IncrementHandlerOptions{IncrementStart: 5, CommonOptions: CommonOptions{SanitizeKeysPrometheus: true}},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"rewrite","a_b":1,"a_b_05":2,"msg_05":"x"}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		slog.New(NewIncrementHandler(tester, testCase.opts)).Info("rewrite", "a.b", 1, "a.b", 2, "msg", "x")
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != testCase.expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, testCase.expected, jStr)
+		}
+	}
+
+	// A key comparison that treats every suffixed key as the same key must not
+	// loop forever looking for a free one
+	ignoreSuffix := func(a, b string) int {
+		a, _, _ = strings.Cut(a, "#")
+		b, _, _ = strings.Cut(b, "#")
+		return strings.Compare(a, b)
+	}
+	done := make(chan string, 1)
+	go func() {
+		tester := &testHandler{}
+		slog.New(NewIncrementHandler(tester, &IncrementHandlerOptions{IncrementFirst: true, KeyCompare: ignoreSuffix})).Info("rewrite", "a", 1, "a", 2)
+		jBytes, _ := tester.MarshalJSON()
+		done <- strings.TrimSpace(string(jBytes))
+	}()
+
+	select {
+	case jStr := <-done:
+		expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"rewrite","a#01":2}`
+		if jStr != expected {
+			t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out incrementing a key that the key comparison never finds free")
+	}
+}
+
+func TestIncrementHandler_OrderMode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		mode     OrderMode
+		expected string
+	}{
+		{
+			name:     "sorted",
+			mode:     OrderSorted,
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"order","arg1":"main1arg1","arg2":"with1arg2","arg2#01":"main1arg2","group1":{"alpha":6,"beta":5},"zed":1,"zed#01":2}`,
+		},
+		{
+			name:     "insertion",
+			mode:     OrderInsertion,
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"order","zed":1,"arg2":"with1arg2","arg1":"main1arg1","zed#01":2,"group1":{"beta":5,"alpha":6},"arg2#01":"main1arg2"}`,
+		},
+		{
+			name:     "insertion stable dedup",
+			mode:     OrderInsertionStableDedup,
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"order","zed":1,"arg2":"with1arg2","arg1":"main1arg1","zed#01":2,"group1":{"beta":5,"alpha":6},"arg2#01":"main1arg2"}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		log := slog.New(NewIncrementHandler(tester, &IncrementHandlerOptions{CommonOptions: CommonOptions{OrderMode: testCase.mode}})).With("zed", 1, "arg2", "with1arg2")
+		log.Info("order", "arg1", "main1arg1", "zed", 2, slog.Group("group1", "beta", 5, "alpha", 6), "arg2", "main1arg2")
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != testCase.expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, testCase.expected, jStr)
+		}
+
+		checkRecordForDuplicates(t, tester.Record)
+	}
+}
+
+func TestIncrementHandler_IndexIgnoringResolveKey(t *testing.T) {
+	t.Parallel()
+
+	// A ResolveKey that ignores the index must not hang, nor drop values
+	ignoreIndex := func(_ []string, key string, _ int) (string, bool) {
+		return key, true
+	}
+
+	tester := &testHandler{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		log := slog.New(NewIncrementHandler(tester, &IncrementHandlerOptions{ResolveKey: ignoreIndex})).With("arg1", "val1")
+		log.Info("ignore index", "arg1", "val2", "arg1", "val3", slog.Group("group1", "arg1", "val4", "arg1", "val5"))
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Handler did not return")
+	}
+
+	jBytes, err := tester.MarshalJSON()
+	if err != nil {
+		t.Errorf("Unable to marshal json: %v", err)
+	}
+	jStr := strings.TrimSpace(string(jBytes))
+
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"ignore index","arg1":"val1","arg1#01":"val2","arg1#02":"val3","group1":{"arg1":"val4","arg1#01":"val5"}}`
+	if jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+
+	checkRecordForDuplicates(t, tester.Record)
+}
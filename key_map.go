@@ -0,0 +1,166 @@
+package slogdedup
+
+import (
+	"regexp"
+	"strings"
+)
+
+// withKeyMap returns a ResolveKey function that first maps the key using the
+// keyMap, then resolves the mapped key using resolveKey.
+// If keyMap is nil, resolveKey is returned unchanged.
+func withKeyMap(keyMap func(groups []string, key string) (string, bool), resolveKey func(groups []string, key string, index int) (string, bool)) func(groups []string, key string, index int) (string, bool) {
+	if keyMap == nil {
+		return resolveKey
+	}
+	return func(groups []string, key string, index int) (string, bool) {
+		key, keep := keyMap(groups, key)
+		if !keep {
+			return "", false
+		}
+		return resolveKey(groups, key, index)
+	}
+}
+
+// aliasKeyMap returns a KeyMap function that replaces any key found in the
+// aliases with its canonical key, or nil if there are no aliases.
+func aliasKeyMap(aliases map[string]string) func(groups []string, key string) (string, bool) {
+	if len(aliases) == 0 {
+		return nil
+	}
+	return func(_ []string, key string) (string, bool) {
+		if canonical, ok := aliases[key]; ok {
+			return canonical, true
+		}
+		return key, true
+	}
+}
+
+// withPrometheusKeys returns a ResolveKey function that rewrites each key
+// returned by resolveKey into a valid Prometheus label name, or resolveKey
+// itself if not enabled. Because the resolved key is rewritten, keys that
+// become the same are deduplicated, and incremented keys are also valid.
+func withPrometheusKeys(enabled bool, resolveKey func(groups []string, key string, index int) (string, bool)) func(groups []string, key string, index int) (string, bool) {
+	if !enabled {
+		return resolveKey
+	}
+	return func(groups []string, key string, index int) (string, bool) {
+		key, keep := resolveKey(groups, key, index)
+		if !keep || key == "" {
+			return key, keep
+		}
+		return prometheusLabelName(key), true
+	}
+}
+
+// ReservedKeyPrefix is added to any key that is one of the ReservedOutputKeys.
+const ReservedKeyPrefix = "reserved_"
+
+// DefaultReservedOutputKeys are the keys renamed if ReservedOutputKeys is nil.
+// "__proto__" can modify the prototype of an object when JSON is parsed by
+// some JavaScript code, instead of being a normal key.
+var DefaultReservedOutputKeys = []string{"__proto__"}
+
+// withReservedKeys returns a ResolveKey function that adds ReservedKeyPrefix to
+// each key returned by resolveKey that is one of the reserved keys, or
+// resolveKey itself if there are none. Nil reserved keys defaults to
+// DefaultReservedOutputKeys.
+func withReservedKeys(reserved []string, resolveKey func(groups []string, key string, index int) (string, bool)) func(groups []string, key string, index int) (string, bool) {
+	if reserved == nil {
+		reserved = DefaultReservedOutputKeys
+	}
+	if len(reserved) == 0 {
+		return resolveKey
+	}
+	reservedSet := make(map[string]struct{}, len(reserved))
+	for _, key := range reserved {
+		reservedSet[key] = struct{}{}
+	}
+	return func(groups []string, key string, index int) (string, bool) {
+		key, keep := resolveKey(groups, key, index)
+		if !keep {
+			return key, keep
+		}
+		if _, ok := reservedSet[key]; ok {
+			return ReservedKeyPrefix + key, true
+		}
+		return key, true
+	}
+}
+
+// rewriteResolvedKey returns a ResolveKey function that only applies the
+// SanitizeKeysPrometheus and ReservedOutputKeys rewriting, to keys that were
+// changed after being resolved, such as by adding an increment suffix.
+func rewriteResolvedKey(prometheus bool, reserved []string) func(groups []string, key string, index int) (string, bool) {
+	return withReservedKeys(reserved, withPrometheusKeys(prometheus, func(_ []string, key string, _ int) (string, bool) {
+		return key, true
+	}))
+}
+
+// prometheusLabelName rewrites the key to match [a-zA-Z_][a-zA-Z0-9_]*, by
+// replacing each invalid character with an underscore, and prefixing an
+// underscore if it starts with a digit. For example, "2xx.count" becomes
+// "_2xx_count".
+func prometheusLabelName(key string) string {
+	var sb strings.Builder
+	sb.Grow(len(key) + 1)
+	for i, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+			sb.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				sb.WriteByte('_')
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte('_')
+		}
+	}
+	return sb.String()
+}
+
+// StripSuffixKeyMap returns a KeyMap function that strips the first match of
+// the regular expression from the key, if the match is at the end of the key.
+// The regular expression should usually be anchored with '$'. Keys are never
+// stripped down to nothing.
+// This is useful when keys have been made unique upstream by the addition of a
+// random suffix, and should be collapsed back together. For example, with
+// regexp.MustCompile(`_[a-z0-9]+$`), "req_abc123" and "req_def456" will both
+// become "req", and then be deduplicated.
+func StripSuffixKeyMap(re *regexp.Regexp) func(groups []string, key string) (string, bool) {
+	return func(_ []string, key string) (string, bool) {
+		if loc := re.FindStringIndex(key); loc != nil && loc[0] > 0 && loc[1] == len(key) {
+			return key[:loc[0]], true
+		}
+		return key, true
+	}
+}
+
+// StripPrefixKeyMap returns a KeyMap function that strips the prefix from the
+// start of the key. Keys are never stripped down to nothing.
+// This is useful when some keys have been prefixed upstream, and should be
+// collapsed together with the un-prefixed keys. For example, with "ext_",
+// "ext_user" and "user" will both become "user", and then be deduplicated.
+func StripPrefixKeyMap(prefix string) func(groups []string, key string) (string, bool) {
+	return func(_ []string, key string) (string, bool) {
+		if len(key) > len(prefix) && strings.HasPrefix(key, prefix) {
+			return key[len(prefix):], true
+		}
+		return key, true
+	}
+}
+
+// ValidateKeyMap returns a KeyMap function that drops any attribute or group
+// whose key fails the validation, which returns a non-nil error for a
+// malformed key. Because KeyMap runs before ResolveKey, the validation sees
+// the original key.
+// This is useful when keys come from composed middleware or user input, such
+// as dropping keys that are empty after trimming or contain control characters.
+func ValidateKeyMap(validate func(groups []string, key string) error) func(groups []string, key string) (string, bool) {
+	return func(groups []string, key string) (string, bool) {
+		if err := validate(groups, key); err != nil {
+			return "", false
+		}
+		return key, true
+	}
+}
@@ -0,0 +1,248 @@
+package slogdedup
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestStripSuffixKeyMap(t *testing.T) {
+	t.Parallel()
+
+	keyMap := StripSuffixKeyMap(regexp.MustCompile(`_[a-z0-9]+$`))
+
+	tests := []struct {
+		name     string
+		handler  func(next slog.Handler) slog.Handler
+		expected string
+	}{
+		{
+			name: "overwrite",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{KeyMap: keyMap}})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"strip suffix","group1":{"req":"def456"},"req":"def456","requestid":"noSuffix"}`,
+		},
+		{
+			name: "increment",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{KeyMap: keyMap}})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"strip suffix","group1":{"req":"abc123","req#01":"def456"},"req":"abc123","req#01":"def456","requestid":"noSuffix"}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		log := slog.New(testCase.handler(tester))
+		log.Info("strip suffix", "req_abc123", "abc123", "req_def456", "def456", "requestid", "noSuffix",
+			slog.Group("group1_xyz789", "req_abc123", "abc123", "req_def456", "def456"))
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != testCase.expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, testCase.expected, jStr)
+		}
+
+		checkRecordForDuplicates(t, tester.Record)
+	}
+}
+
+func TestStripPrefixKeyMap(t *testing.T) {
+	t.Parallel()
+
+	keyMap := StripPrefixKeyMap("ext_")
+
+	tests := []struct {
+		name     string
+		handler  func(next slog.Handler) slog.Handler
+		expected string
+	}{
+		{
+			name: "ignore",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{KeyMap: keyMap}})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"strip prefix","ext_":"prefixOnly","group1":{"user":"local"},"user":"gateway"}`,
+		},
+		{
+			name: "overwrite",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{KeyMap: keyMap}})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"strip prefix","ext_":"prefixOnly","group1":{"user":"gateway"},"user":"local"}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		log := slog.New(testCase.handler(tester))
+		log.Info("strip prefix", "ext_user", "gateway", "user", "local", "ext_", "prefixOnly",
+			slog.Group("ext_group1", "user", "local", "ext_user", "gateway"))
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != testCase.expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, testCase.expected, jStr)
+		}
+
+		checkRecordForDuplicates(t, tester.Record)
+	}
+}
+
+func TestKeyAliases(t *testing.T) {
+	t.Parallel()
+
+	tester := &testHandler{}
+	h := NewOverwriteHandler(tester, &OverwriteHandlerOptions{
+		KeyAliases: map[string]string{"usr": "user", "uid": "user_id"},
+	})
+
+	log := slog.New(h).With("usr", "bob", "uid", 1)
+	log.Info("aliases", "user", "alice", slog.Group("group1", "uid", 2, "user_id", 3))
+
+	jBytes, err := tester.MarshalJSON()
+	if err != nil {
+		t.Errorf("Unable to marshal json: %v", err)
+	}
+	jStr := strings.TrimSpace(string(jBytes))
+
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"aliases","group1":{"user_id":3},"user":"alice","user_id":1}`
+	if jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+
+	checkRecordForDuplicates(t, tester.Record)
+}
+
+func TestValidateKeyMap(t *testing.T) {
+	t.Parallel()
+
+	validKey := regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*$`)
+	keyMap := ValidateKeyMap(func(_ []string, key string) error {
+		if !validKey.MatchString(key) {
+			return fmt.Errorf("malformed key: %q", key)
+		}
+		return nil
+	})
+
+	tester := &testHandler{}
+	h := NewOverwriteHandler(tester, &OverwriteHandlerOptions{CommonOptions: CommonOptions{KeyMap: keyMap}})
+
+	log := slog.New(h).With("arg1", "val1", "bad key", "val1")
+	log.Info("validate", "1arg", "val2", slog.Group("group1", "arg2", "val2", "arg\n3", "val3"), slog.Group("group 2", "arg4", "val4"))
+
+	jBytes, err := tester.MarshalJSON()
+	if err != nil {
+		t.Errorf("Unable to marshal json: %v", err)
+	}
+	jStr := strings.TrimSpace(string(jBytes))
+
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"validate","arg1":"val1","group1":{"arg2":"val2"}}`
+	if jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+
+	checkRecordForDuplicates(t, tester.Record)
+}
+
+func TestSanitizeKeysPrometheus(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		handler  func(next slog.Handler) slog.Handler
+		expected string
+	}{
+		{
+			name: "overwrite",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{SanitizeKeysPrometheus: true}})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"prometheus","_2xx_count":2,"http_req":{"path_":"/b"},"msg_01":"val1"}`,
+		},
+		{
+			name: "increment",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{SanitizeKeysPrometheus: true}})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"prometheus","_2xx_count":1,"_2xx_count_01":2,"http_req":{"path_":"/a"},"http_req_01":{"path_":"/b"},"msg_01":"val1"}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		slog.New(testCase.handler(tester)).With("2xx.count", 1).With(slog.Group("http-req", "path!", "/a")).Info("prometheus", "2xx_count", 2, slog.Group("http.req", "path?", "/b"), "msg", "val1")
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != testCase.expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, testCase.expected, jStr)
+		}
+	}
+}
+
+func TestReservedOutputKeys(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		handler  func(next slog.Handler) slog.Handler
+		expected string
+	}{
+		{
+			name: "overwrite-default",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewOverwriteHandler(next, nil)
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"reserved","_id":5,"group1":{"reserved___proto__":{"admin":true}},"reserved___proto__":"val2"}`,
+		},
+		{
+			name: "append-custom",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{ReservedOutputKeys: []string{"_id", "__proto__"}}})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"reserved","group1":{"reserved___proto__":{"admin":true}},"reserved___proto__":["val1","val2"],"reserved__id":5}`,
+		},
+		{
+			name: "ignore-none",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{ReservedOutputKeys: []string{}}})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"reserved","__proto__":"val1","_id":5,"group1":{"__proto__":{"admin":true}}}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		slog.New(testCase.handler(tester)).With("__proto__", "val1").Info("reserved", "_id", 5, "__proto__", "val2", slog.Group("group1", slog.Group("__proto__", "admin", true)))
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != testCase.expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, testCase.expected, jStr)
+		}
+	}
+}
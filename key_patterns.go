@@ -0,0 +1,141 @@
+package slogdedup
+
+import (
+	"path"
+	"slices"
+)
+
+// keyPatterns is a list of patterns that match the full path of keys to an
+// attribute (its group names followed by its key), joined with dots.
+// For example, "group1.arg1" matches the "arg1" attribute inside "group1".
+// Each dotted segment of the pattern may use the syntax of path.Match, so "*"
+// matches any single key, while a segment of "**" matches any number of keys,
+// including none. For example, "**.password" matches "password" at any depth.
+// A dot that is part of a key must be escaped with a backslash, such as
+// "http\\.method" to match the key "http.method".
+type keyPatterns [][]string
+
+// newKeyPatterns splits the patterns into their segments, or returns nil if
+// there are no patterns. If anyDepth is true, a pattern of a single key, with
+// no group names, matches that key at any depth, as if it started with "**.".
+func newKeyPatterns(patterns []string, anyDepth bool) keyPatterns {
+	if len(patterns) == 0 {
+		return nil
+	}
+	kp := make(keyPatterns, 0, len(patterns))
+	for _, pattern := range patterns {
+		segments := splitKeyPattern(pattern)
+		if anyDepth && len(segments) == 1 && segments[0] != "**" {
+			segments = []string{"**", segments[0]}
+		}
+		kp = append(kp, segments)
+	}
+	return kp
+}
+
+// splitKeyPattern splits the pattern on each dot that is not escaped by a
+// backslash. Escapes are kept in the segments, because path.Match treats an
+// escaped dot as a literal dot.
+func splitKeyPattern(pattern string) []string {
+	var segments []string
+	start := 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++ // Skip the escaped character
+		case '.':
+			segments = append(segments, pattern[start:i])
+			start = i + 1
+		}
+	}
+	return append(segments, pattern[start:])
+}
+
+// match reports whether the attribute, or any of the groups containing it,
+// match any of the patterns.
+func (kp keyPatterns) match(groups []string, key string) bool {
+	keyPath := append(slices.Clip(groups), key)
+	for _, pattern := range kp {
+		for i := 1; i <= len(keyPath); i++ {
+			if matchKeyPath(pattern, keyPath[:i]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// leadsTo reports whether the group could contain an attribute that matches
+// any of the patterns.
+func (kp keyPatterns) leadsTo(groups []string, key string) bool {
+	keyPath := append(slices.Clip(groups), key)
+	for _, pattern := range kp {
+		if matchKeyPathPrefix(pattern, keyPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// prune deletes every attribute and group in the tree whose key path matches
+// any of the patterns, along with any groups left empty by the deletions.
+func (kp keyPatterns) prune(uniq attrStore, groups []string) {
+	if len(kp) == 0 {
+		return
+	}
+	// Collect first, because the tree must not be modified while enumerating
+	var deletes []string
+	uniq.Range(func(k string, v any) bool {
+		if kp.match(groups, k) {
+			deletes = append(deletes, k)
+			return true
+		}
+		if subtree, ok := v.(attrStore); ok && kp.leadsTo(groups, k) {
+			kp.prune(subtree, append(slices.Clip(groups), k))
+			if subtree.Len() == 0 {
+				deletes = append(deletes, k)
+			}
+		}
+		return true
+	})
+
+	for _, k := range deletes {
+		uniq.Delete(k)
+	}
+}
+
+// matchKeyPath reports whether the full key path matches the pattern
+func matchKeyPath(pattern, keyPath []string) bool {
+	if len(pattern) == 0 {
+		return len(keyPath) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(keyPath); i++ {
+			if matchKeyPath(pattern[1:], keyPath[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(keyPath) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], keyPath[0])
+	return ok && matchKeyPath(pattern[1:], keyPath[1:])
+}
+
+// matchKeyPathPrefix reports whether the key path is the beginning of a path
+// that could match the pattern
+func matchKeyPathPrefix(pattern, keyPath []string) bool {
+	if len(keyPath) == 0 {
+		return true
+	}
+	if len(pattern) == 0 {
+		return false
+	}
+	if pattern[0] == "**" {
+		return true
+	}
+	ok, _ := path.Match(pattern[0], keyPath[0])
+	return ok && matchKeyPathPrefix(pattern[1:], keyPath[1:])
+}
@@ -0,0 +1,157 @@
+package slogdedup
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+)
+
+// KindGroupHandler is a slog.Handler middleware that relocates each attribute
+// under a top-level group named for the slog.Kind of its value, such as
+// "strings", "numbers", or "bools", before passing the record off to the next
+// handler. Any groups the attribute was in are kept beneath the kind group, so
+// that "group1.arg1":"val1" becomes "strings.group1.arg1":"val1".
+// This suits columnar backends that need each column to have a single type.
+// Put this handler after one of the deduplicating handlers, so that each key
+// is only relocated once.
+type KindGroupHandler struct {
+	next   slog.Handler
+	groups []string
+	attrs  []slog.Attr // WithAttrs attributes, already nested inside their groups
+}
+
+var _ slog.Handler = &KindGroupHandler{} // Assert conformance with interface
+
+// kindGroupOrder is the order the kind groups are output in.
+var kindGroupOrder = []string{"strings", "numbers", "bools", "durations", "times", "others"}
+
+// KindGroupName returns the name of the group that attributes of the kind are
+// relocated under.
+func KindGroupName(kind slog.Kind) string {
+	switch kind {
+	case slog.KindString:
+		return "strings"
+	case slog.KindInt64, slog.KindUint64, slog.KindFloat64:
+		return "numbers"
+	case slog.KindBool:
+		return "bools"
+	case slog.KindDuration:
+		return "durations"
+	case slog.KindTime:
+		return "times"
+	default:
+		return "others"
+	}
+}
+
+// NewKindGroupMiddleware creates a KindGroupHandler slog.Handler middleware
+// that conforms to [github.com/samber/slog-multi.Middleware] interface.
+// It can be used with slogmulti methods such as Pipe to easily setup a pipeline of slog handlers:
+//
+//	slog.SetDefault(slog.New(slogmulti.
+//		Pipe(slogdedup.NewOverwriteMiddleware(&slogdedup.OverwriteHandlerOptions{})).
+//		Pipe(slogdedup.NewKindGroupMiddleware()).
+//		Handler(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{})),
+//	))
+func NewKindGroupMiddleware() func(slog.Handler) slog.Handler {
+	return func(next slog.Handler) slog.Handler {
+		return NewKindGroupHandler(
+			next,
+		)
+	}
+}
+
+// NewKindGroupHandler creates a KindGroupHandler slog.Handler middleware that
+// relocates each attribute under a group named for its kind, before passing
+// the record off to the next handler.
+// If next is nil, all records are discarded instead of panicking.
+func NewKindGroupHandler(next slog.Handler) *KindGroupHandler {
+	return &KindGroupHandler{
+		next: nextOrDiscard(next),
+	}
+}
+
+// Enabled reports whether the next handler handles records at the given level.
+// The handler ignores records whose level is lower.
+func (h *KindGroupHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle relocates the attributes under their kind groups, then passes the new
+// record to the next handler.
+func (h *KindGroupHandler) Handle(ctx context.Context, r slog.Record) error {
+	kinds := map[string][]slog.Attr{}
+	for _, a := range h.attrs {
+		kinds = relocateByKind(kinds, nil, a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		kinds = relocateByKind(kinds, h.groups, a)
+		return true
+	})
+
+	newR := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	for _, name := range kindGroupOrder {
+		if attrs, ok := kinds[name]; ok {
+			newR.AddAttrs(slog.Attr{Key: name, Value: slog.GroupValue(attrs...)})
+		}
+	}
+	return h.next.Handle(ctx, newR)
+}
+
+// WithGroup returns a new KindGroupHandler that has the group.
+// The group is not passed to the next handler, because it must be nested
+// inside the kind groups.
+func (h *KindGroupHandler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	h2.groups = append(slices.Clip(h.groups), name)
+	return &h2
+}
+
+// WithAttrs returns a new KindGroupHandler that has the attributes.
+func (h *KindGroupHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = slices.Clip(h.attrs)
+	for _, a := range attrs {
+		for i := len(h.groups) - 1; i >= 0; i-- {
+			a = slog.Attr{Key: h.groups[i], Value: slog.GroupValue(a)}
+		}
+		h2.attrs = append(h2.attrs, a)
+	}
+	return &h2
+}
+
+// relocateByKind adds each leaf of the attribute to the group for its kind,
+// nested inside the groups leading to it. Inline groups are flattened.
+func relocateByKind(kinds map[string][]slog.Attr, groups []string, a slog.Attr) map[string][]slog.Attr {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return kinds
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			groups = append(slices.Clip(groups), a.Key)
+		}
+		for _, ga := range a.Value.Group() {
+			kinds = relocateByKind(kinds, groups, ga)
+		}
+		return kinds
+	}
+	name := KindGroupName(a.Value.Kind())
+	kinds[name] = insertLeaf(kinds[name], groups, a)
+	return kinds
+}
+
+// insertLeaf adds the leaf to the attributes, inside the groups, merging it
+// into any existing groups with the same keys.
+func insertLeaf(attrs []slog.Attr, groups []string, leaf slog.Attr) []slog.Attr {
+	if len(groups) == 0 {
+		return append(attrs, leaf)
+	}
+	for i, a := range attrs {
+		if a.Key == groups[0] && a.Value.Kind() == slog.KindGroup {
+			attrs[i].Value = slog.GroupValue(insertLeaf(a.Value.Group(), groups[1:], leaf)...)
+			return attrs
+		}
+	}
+	return append(attrs, slog.Attr{Key: groups[0], Value: slog.GroupValue(insertLeaf(nil, groups[1:], leaf)...)})
+}
@@ -0,0 +1,55 @@
+package slogdedup
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKindGroupHandler(t *testing.T) {
+	t.Parallel()
+
+	tester := &testHandler{}
+	h := NewOverwriteHandler(NewKindGroupHandler(tester), nil)
+
+	log := slog.New(h).With("arg1", "val1", "count", 1).WithGroup("group1").With("enabled", true, "arg1", "with1")
+	log.Info("typed",
+		"arg1", "main1",
+		"ratio", 0.5,
+		"size", uint64(10),
+		"elapsed", time.Second,
+		"at", time.Date(2023, 9, 29, 13, 0, 0, 0, time.UTC),
+		"tags", []string{"a", "b"},
+		slog.Group("inner", "ok", false, "name", "bob"),
+	)
+
+	jBytes, err := tester.MarshalJSON()
+	if err != nil {
+		t.Errorf("Unable to marshal json: %v", err)
+	}
+	jStr := strings.TrimSpace(string(jBytes))
+
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"typed","strings":{"arg1":"val1","group1":{"arg1":"main1","inner":{"name":"bob"}}},"numbers":{"count":1,"group1":{"ratio":0.5,"size":10}},"bools":{"group1":{"enabled":true,"inner":{"ok":false}}},"durations":{"group1":{"elapsed":1000000000}},"times":{"group1":{"at":"2023-09-29T13:00:00Z"}},"others":{"group1":{"tags":["a","b"]}}}`
+	if jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+
+	checkRecordForDuplicates(t, tester.Record)
+
+	// Used directly, without a deduplicating handler in front
+	tester = &testHandler{}
+	log = slog.New(NewKindGroupHandler(tester)).With("arg1", "val1").WithGroup("group1").With("arg2", 2)
+	log.Info("direct", "arg3", true, slog.Group("", "arg4", "val4"))
+
+	jBytes, err = tester.MarshalJSON()
+	if err != nil {
+		t.Errorf("Unable to marshal json: %v", err)
+	}
+	jStr = strings.TrimSpace(string(jBytes))
+
+	expected = `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"direct","strings":{"arg1":"val1","group1":{"arg4":"val4"}},"numbers":{"group1":{"arg2":2}},"bools":{"group1":{"arg3":true}}}`
+	if jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+}
@@ -0,0 +1,92 @@
+package slogdedup
+
+import (
+	"log/slog"
+	"slices"
+)
+
+// largeValueMover relocates attributes with large string or byte slice values
+// into a separate group at the root level of the tree.
+type largeValueMover struct {
+	threshold int
+	group     string
+}
+
+// newLargeValueMover returns a largeValueMover, or nil if the threshold is
+// less than 1 or the group is empty.
+func newLargeValueMover(threshold int, group string) *largeValueMover {
+	if threshold < 1 || group == "" {
+		return nil
+	}
+	return &largeValueMover{threshold: threshold, group: group}
+}
+
+// move relocates every attribute whose value is longer than the threshold
+// into the large group, keeping the path of groups it was in, and removes any
+// groups left empty. If any attributes were moved, the large group replaces
+// any attribute or group with the same key at the root level.
+// Safe to call on a nil largeValueMover.
+func (m *largeValueMover) move(state *handleState, uniq attrStore, keyCompareFor func(groups []string) func(a, b string) int) {
+	if m == nil {
+		return
+	}
+	large := uniq.New(keyCompareFor([]string{m.group}))
+	m.collect(state, uniq, large, nil, keyCompareFor)
+	if large.Len() > 0 {
+		uniq.Set(m.group, large)
+		state.order.touch(uniq, m.group)
+	}
+}
+
+// collect moves the large attributes out of the tree and into the large tree,
+// creating subtrees in the large tree for each group along the way.
+func (m *largeValueMover) collect(state *handleState, uniq, large attrStore, groups []string, keyCompareFor func(groups []string) func(a, b string) int) {
+	// Collect first, because the tree must not be modified while enumerating
+	var moves []slog.Attr
+	var deletes []string
+	uniq.Range(func(k string, v any) bool {
+		switch val := v.(type) {
+		case slog.Attr:
+			if m.isLarge(val.Value) {
+				moves = append(moves, val)
+			}
+		case attrStore:
+			if len(groups) == 0 && k == m.group {
+				return true // An existing group with the same key as the large group
+			}
+			groupPath := append(slices.Clip(groups), k)
+			largeGroup := large.New(keyCompareFor(append([]string{m.group}, groupPath...)))
+			m.collect(state, val, largeGroup, groupPath, keyCompareFor)
+			if largeGroup.Len() > 0 {
+				large.Set(k, largeGroup)
+				state.order.touch(large, k)
+			}
+			if val.Len() == 0 {
+				deletes = append(deletes, k)
+			}
+		}
+		return true
+	})
+
+	for _, a := range moves {
+		uniq.Delete(a.Key)
+		large.Set(a.Key, a)
+		state.order.touch(large, a.Key)
+	}
+	for _, k := range deletes {
+		uniq.Delete(k)
+	}
+}
+
+// isLarge returns true if the value is a string or byte slice longer than the threshold
+func (m *largeValueMover) isLarge(v slog.Value) bool {
+	switch v.Kind() {
+	case slog.KindString:
+		return len(v.String()) > m.threshold
+	case slog.KindAny:
+		bs, ok := v.Any().([]byte)
+		return ok && len(bs) > m.threshold
+	default:
+		return false
+	}
+}
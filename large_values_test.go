@@ -0,0 +1,48 @@
+package slogdedup
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLargeValueThreshold(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		handler func(next slog.Handler) slog.Handler
+	}{
+		{"overwrite", func(next slog.Handler) slog.Handler {
+			return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{LargeValueThreshold: 10, LargeValueGroup: "_large"}})
+		}},
+		{"ignore", func(next slog.Handler) slog.Handler {
+			return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{LargeValueThreshold: 10, LargeValueGroup: "_large"}})
+		}},
+		{"increment", func(next slog.Handler) slog.Handler {
+			return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{LargeValueThreshold: 10, LargeValueGroup: "_large"}})
+		}},
+		{"append", func(next slog.Handler) slog.Handler {
+			return NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{LargeValueThreshold: 10, LargeValueGroup: "_large"}})
+		}},
+	}
+
+	big := strings.Repeat("x", 11)
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		log := slog.New(testCase.handler(tester)).With("small", "val1", "body", big).WithGroup("group1")
+		log.Info("large", "small", "val2", "bytes", []byte(big), slog.Group("group2", "body", big))
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"large","_large":{"body":"xxxxxxxxxxx","group1":{"bytes":"eHh4eHh4eHh4eHg=","group2":{"body":"xxxxxxxxxxx"}}},"group1":{"small":"val2"},"small":"val1"}`
+		if jStr != expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, expected, jStr)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package slogdedup
+
+import (
+	"context"
+	"log/slog"
+)
+
+// LevelCountHandler is a slog.Handler middleware that calls a counter function
+// with the level of every record it handles, before passing the record off to
+// the next handler. It is useful for exposing metrics on the number of log
+// lines by level.
+type LevelCountHandler struct {
+	next    slog.Handler
+	counter func(level slog.Level)
+}
+
+var _ slog.Handler = &LevelCountHandler{} // Assert conformance with interface
+
+// NewLevelCountMiddleware creates a LevelCountHandler slog.Handler middleware
+// that conforms to [github.com/samber/slog-multi.Middleware] interface.
+// It can be used with slogmulti methods such as Pipe to easily setup a pipeline of slog handlers:
+//
+//	slog.SetDefault(slog.New(slogmulti.
+//		Pipe(slogdedup.NewLevelCountMiddleware(counter)).
+//		Pipe(slogdedup.NewOverwriteMiddleware(&slogdedup.OverwriteHandlerOptions{})).
+//		Handler(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{})),
+//	))
+func NewLevelCountMiddleware(counter func(level slog.Level)) func(slog.Handler) slog.Handler {
+	return func(next slog.Handler) slog.Handler {
+		return NewLevelCountHandler(
+			next,
+			counter,
+		)
+	}
+}
+
+// NewLevelCountHandler creates a LevelCountHandler slog.Handler middleware
+// that calls counter with the level of every record it handles, before passing
+// the record off to the next handler.
+// Only records enabled by the next handler are counted.
+// If next is nil, all records are discarded instead of panicking.
+func NewLevelCountHandler(next slog.Handler, counter func(level slog.Level)) *LevelCountHandler {
+	return &LevelCountHandler{
+		next:    nextOrDiscard(next),
+		counter: counter,
+	}
+}
+
+// Enabled reports whether the next handler handles records at the given level.
+// The handler ignores records whose level is lower.
+func (h *LevelCountHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle counts the record's level, then passes the record to the next handler.
+func (h *LevelCountHandler) Handle(ctx context.Context, r slog.Record) error {
+	if h.counter != nil {
+		h.counter(r.Level)
+	}
+	return h.next.Handle(ctx, r)
+}
+
+// WithGroup returns a new LevelCountHandler whose next handler has the group.
+func (h *LevelCountHandler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	h2.next = h2.next.WithGroup(name)
+	return &h2
+}
+
+// WithAttrs returns a new LevelCountHandler whose next handler has the attributes.
+func (h *LevelCountHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.next = h2.next.WithAttrs(attrs)
+	return &h2
+}
@@ -0,0 +1,43 @@
+package slogdedup
+
+import (
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+)
+
+func TestLevelCountHandler(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	counts := map[slog.Level]int{}
+	counter := func(level slog.Level) {
+		mu.Lock()
+		defer mu.Unlock()
+		counts[level]++
+	}
+
+	h := NewLevelCountMiddleware(counter)(
+		NewOverwriteHandler(slog.NewJSONHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelInfo}), nil),
+	)
+	log := slog.New(h).With("with1", "arg0").WithGroup("group1")
+
+	log.Debug("not enabled")
+	log.Info("info1")
+	log.Info("info2")
+	log.Warn("warn1")
+	log.Error("error1")
+	log.Error("error2")
+	log.Error("error3")
+
+	expected := map[slog.Level]int{slog.LevelInfo: 2, slog.LevelWarn: 1, slog.LevelError: 3}
+	if len(counts) != len(expected) {
+		t.Errorf("Expected: %v; Got: %v", expected, counts)
+	}
+	for level, count := range expected {
+		if counts[level] != count {
+			t.Errorf("Expected %s count %d; Got %d", level, count, counts[level])
+		}
+	}
+}
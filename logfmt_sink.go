@@ -0,0 +1,146 @@
+package slogdedup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// logfmtHandler is a terminal slog.Handler that writes each record as a single
+// line of logfmt (key=value pairs). It does not deduplicate anything itself,
+// and expects to be placed after one of the dedup middlewares.
+type logfmtHandler struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+var _ slog.Handler = &logfmtHandler{} // Assert conformance with interface
+
+// NewLogfmtSink creates a terminal slog.Handler that deduplicates all
+// attributes and groups by overwriting older duplicates (see OverwriteHandler),
+// then writes the record as a single line of logfmt to w.
+// The builtin time, level, and msg are written first, followed by all other
+// attributes sorted by key. Attributes inside of groups have their keys
+// prefixed by the group names, joined with a dot. Any dots or backslashes in
+// the keys and group names themselves are escaped with a backslash, so a
+// group1.arg1 attribute at the root is written as group1\.arg1.
+// All levels are enabled.
+//
+//	time=2024-03-21T09:33:25Z level=INFO msg="hello world" arg1=val1 group1.arg2=val2
+func NewLogfmtSink(w io.Writer) slog.Handler {
+	return NewOverwriteHandler(&logfmtHandler{mu: &sync.Mutex{}, w: w}, nil)
+}
+
+// Enabled reports true for all levels.
+func (h *logfmtHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+// Handle writes the record as a single line of logfmt.
+func (h *logfmtHandler) Handle(_ context.Context, r slog.Record) error {
+	buf := &bytes.Buffer{}
+	if !r.Time.IsZero() {
+		writeLogfmtPair(buf, slog.TimeKey, r.Time.Format(time.RFC3339Nano))
+	}
+	writeLogfmtPair(buf, slog.LevelKey, r.Level.String())
+	writeLogfmtPair(buf, slog.MessageKey, r.Message)
+
+	var pairs [][2]string
+	r.Attrs(func(a slog.Attr) bool {
+		pairs = appendLogfmtPairs(pairs, "", a)
+		return true
+	})
+	slices.SortStableFunc(pairs, func(a, b [2]string) int {
+		return strings.Compare(a[0], b[0])
+	})
+	for _, pair := range pairs {
+		writeLogfmtPair(buf, pair[0], pair[1])
+	}
+	buf.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(buf.Bytes())
+	return err
+}
+
+// WithGroup returns a new handler that deduplicates and namespaces any future attributes.
+func (h *logfmtHandler) WithGroup(name string) slog.Handler {
+	return NewOverwriteHandler(h, nil).WithGroup(name)
+}
+
+// WithAttrs returns a new handler that deduplicates and includes the attributes.
+func (h *logfmtHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return NewOverwriteHandler(h, nil).WithAttrs(attrs)
+}
+
+// appendLogfmtPairs flattens the attribute, and any attributes inside it if it
+// is a group, into key-value string pairs.
+func appendLogfmtPairs(pairs [][2]string, prefix string, a slog.Attr) [][2]string {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return pairs
+	}
+	key := logfmtEscapeKey(a.Key)
+	if prefix != "" && key != "" {
+		key = prefix + "." + key
+	} else if prefix != "" {
+		key = prefix
+	}
+
+	if a.Value.Kind() != slog.KindGroup {
+		return append(pairs, [2]string{key, fmt.Sprint(a.Value.Any())})
+	}
+	for _, child := range a.Value.Group() {
+		pairs = appendLogfmtPairs(pairs, key, child)
+	}
+	return pairs
+}
+
+// logfmtKeyEscaper escapes the dots in a key or group name, and the escape
+// character itself, so that they cannot be confused with the dots joining the
+// group names of a flattened key.
+var logfmtKeyEscaper = strings.NewReplacer(`\`, `\\`, ".", `\.`)
+
+// logfmtEscapeKey escapes the key or group name before it is flattened, so that
+// a group1.arg1 attribute at the root is not written with the same key as an
+// arg1 attribute inside of group1.
+func logfmtEscapeKey(key string) string {
+	if !strings.ContainsAny(key, `.\`) {
+		return key
+	}
+	return logfmtKeyEscaper.Replace(key)
+}
+
+// writeLogfmtPair writes the key=value pair, preceded by a space if it is not
+// the first pair on the line. Values are quoted if necessary.
+func writeLogfmtPair(buf *bytes.Buffer, key, value string) {
+	if buf.Len() > 0 {
+		buf.WriteByte(' ')
+	}
+	buf.WriteString(logfmtQuote(key))
+	buf.WriteByte('=')
+	buf.WriteString(logfmtQuote(value))
+}
+
+// logfmtQuote quotes the string if it is empty, or contains spaces, quotes,
+// equal signs, or non-printable characters.
+func logfmtQuote(s string) string {
+	if s == "" {
+		return `""`
+	}
+	for _, r := range s {
+		if r == '=' || r == '"' || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
@@ -0,0 +1,55 @@
+package slogdedup
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+)
+
+func TestLogfmtSink(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	log := slog.New(NewLogfmtSink(buf))
+
+	log = log.With("zed", "first", "arg1", "with1arg1")
+	log.WithGroup("group1").Info("hello world", "arg2", "with spaces", "arg1", `quote"d`, "arg1", "main1arg1")
+	log.Warn("second", "zed", "last", slog.Group("group2", "empty", ""))
+
+	expected := `level=INFO msg="hello world" arg1=with1arg1 group1.arg1=main1arg1 group1.arg2="with spaces" zed=first
+level=WARN msg=second arg1=with1arg1 group2.empty="" zed=last
+`
+	// Time is variable, so strip it from each line
+	got := string(bytes.Join(stripLogfmtTime(bytes.Split(buf.Bytes(), []byte("\n"))), []byte("\n")))
+	if got != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+}
+
+func TestLogfmtSink_FlattenedCollision(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	log := slog.New(NewLogfmtSink(buf))
+
+	log.Info("first", "group1.arg1", "root", slog.Group("group1", "arg1", "group"))
+	log.WithGroup("group1.arg1").Info("second", "arg2", "group", `back\slash`, "root")
+
+	expected := `level=INFO msg=first group1.arg1=group group1\.arg1=root
+level=INFO msg=second group1\.arg1.arg2=group group1\.arg1.back\\slash=root
+`
+	// Time is variable, so strip it from each line
+	got := string(bytes.Join(stripLogfmtTime(bytes.Split(buf.Bytes(), []byte("\n"))), []byte("\n")))
+	if got != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+}
+
+func stripLogfmtTime(lines [][]byte) [][]byte {
+	for i, line := range lines {
+		if bytes.HasPrefix(line, []byte("time=")) {
+			lines[i] = line[bytes.IndexByte(line, ' ')+1:]
+		}
+	}
+	return lines
+}
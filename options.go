@@ -0,0 +1,297 @@
+package slogdedup
+
+import (
+	"context"
+	"hash"
+	"log/slog"
+	"time"
+)
+
+// CommonOptions are the options shared by all four deduplicating handlers,
+// embedded in each of their options. Where an option depends on the
+// deduplication strategy, each handler's options describe the difference.
+type CommonOptions struct {
+	// KeyCompareForGroup, if not nil, is called with the list of group names
+	// leading to each group (or nil for the root level), and returns the
+	// comparison function to determine if two keys in that group are equal.
+	// If it returns nil, KeyCompare is used. For example, a group of HTTP
+	// headers can be case-insensitive while all other keys are case-sensitive.
+	// The list of groups must not be retained or modified.
+	KeyCompareForGroup func(groups []string) func(a, b string) int
+
+	// KeyOrder, if not nil, sorts the final attributes within the root and
+	// within each group when OrderMode is OrderSorted, instead of KeyCompare.
+	// KeyCompare still determines which keys are duplicates, so keys can be
+	// deduplicated by a normalized form while being sorted by the keys that were
+	// actually kept.
+	KeyOrder func(a, b string) int
+
+	// HashOrder, if true and KeyOrder is nil, sets KeyOrder to HashKeyOrder, so
+	// that attributes are output in the order of a hash of their keys when
+	// OrderMode is OrderSorted. Each key keeps its position relative to the other
+	// keys even when an unrelated key is added or renamed, which keeps diffs of
+	// log output small.
+	HashOrder bool
+
+	// KeyMap, if not nil, is called on each attribute and group key before
+	// ResolveKey, and maps the key to a new key. Returns the new key, and true
+	// to keep the attribute or false to drop it. Because it runs before
+	// deduplication, keys that map to the same new key are deduplicated.
+	// See StripSuffixKeyMap for an example.
+	//
+	// The first argument is a list of currently open groups that contain the
+	// Attr. It must not be retained or modified.
+	KeyMap func(groups []string, key string) (string, bool)
+
+	// SanitizeKeysPrometheus, if true, rewrites every attribute and group key,
+	// at all levels, into a valid Prometheus label name matching
+	// [a-zA-Z_][a-zA-Z0-9_]*, by replacing invalid characters with an
+	// underscore and prefixing keys that start with a digit with an underscore.
+	// For example, "2xx.count" becomes "_2xx_count". The keys are rewritten
+	// after ResolveKey and before deduplication, so keys that become the same
+	// are deduplicated, and incremented keys are rewritten too.
+	SanitizeKeysPrometheus bool
+
+	// ReservedOutputKeys are keys that some encoders or downstream systems treat
+	// specially, which are always renamed by adding ReservedKeyPrefix, at all
+	// levels, such as "__proto__" becoming "reserved___proto__". Keys are renamed
+	// after they are resolved and before deduplication. Defaults to
+	// DefaultReservedOutputKeys if nil. Set it to an empty slice to rename nothing.
+	ReservedOutputKeys []string
+
+	// OrderMode determines the order of the final attributes, within the root
+	// and within each group. Defaults to OrderSorted.
+	OrderMode OrderMode
+
+	// TextOrder, if true and OrderMode is not set, keeps the attributes in the
+	// order they were first seen, as if OrderMode were OrderInsertionStableDedup.
+	// The slog.TextHandler prints attributes in the order it receives them, so
+	// sorted attributes look scrambled compared to the order they were logged.
+	// The handler can't detect which handler renders its output, so use this
+	// when the final handler renders text.
+	TextOrder bool
+
+	// MaxGoaDepth, if positive, is the maximum number of chained With and
+	// WithGroup calls a logger is expected to have. The first time a record
+	// is handled by a logger exceeding it, a single warning record is sent to
+	// the next handler, or the handler chosen by an OverwriteHandler's
+	// LevelRouter. Each logger warns once, including loggers derived from one
+	// that already warned. This usually indicates a misuse, such as calling
+	// logger = logger.With(...) inside a loop.
+	MaxGoaDepth int
+
+	// UnifyGroupSources, if true, merges a group into an existing group with
+	// the same key, regardless of whether either group came from WithGroup or
+	// from a slog.Group attribute. The attributes inside the merged group are
+	// then deduplicated like any others. If false, groups with the same key
+	// are treated like any other duplicate attribute, with the whole newer
+	// group being handled according to this handler's deduplication strategy.
+	UnifyGroupSources bool
+
+	// RootCollisionMode, if not DedupDefault, is used instead of this
+	// handler's own strategy whenever an attribute or group at the root level
+	// (not in a group) has the same key as an older one, such as a scalar and a
+	// group. Groups are still deduplicated by this handler's strategy. Because
+	// every handler resolves root collisions the same way for a given mode, this
+	// allows mixing any handler's group behavior with a chosen root behavior.
+	// Options of this handler that act on collisions do not apply at the root
+	// level when it is set.
+	RootCollisionMode DedupMode
+
+	// InlineCollisionMode, if not DedupDefault, is used instead of this
+	// handler's own strategy whenever an attribute or group from an inlined
+	// group (a group with an empty key, or whose key resolved to empty) has the
+	// same key as an older one at the level it is inlined into. It takes
+	// precedence over RootCollisionMode for inlined groups at the root level.
+	InlineCollisionMode DedupMode
+
+	// EnsureTime, if true, sets the time of any record with a zero time to the
+	// current time. The stdlib handlers omit the time builtin when it is zero,
+	// but some sinks require it, such as "@timestamp" on Elasticsearch data streams.
+	EnsureTime bool
+
+	// DropTime, if true, zeroes the time of every record, so that the stdlib
+	// handlers omit the time builtin. Use it for sinks that stamp their own
+	// ingestion time. It takes precedence over EnsureTime.
+	DropTime bool
+
+	// EnsureKeys, if not empty, are attributes added to the root of every record
+	// after deduplication, if no attribute or group with the same key is already
+	// there, so that the keys are always present. Use a nil value, such as
+	// slog.Any("trace_id", nil), for the key to appear as null in JSON.
+	EnsureKeys []slog.Attr
+
+	// SortBuiltins, if true, puts the record's time, level, and message into the
+	// root level as attributes, so that they are sorted along with all other
+	// attributes, instead of always coming first. The record's time and message
+	// are then left empty, but the level can not be, so the next handler must use
+	// ReplaceAttrSortedBuiltins to drop its own builtin level and message.
+	// An empty message is left out, and the source is left to the next handler.
+	// ResolveMessage has no effect when it is set.
+	SortBuiltins bool
+
+	// BuiltinsLast, if true, puts the record's time, level, and message into the
+	// root level as attributes after all other attributes, in that order, instead
+	// of the builtins always coming first. Like SortBuiltins, which it implies,
+	// the next handler must use ReplaceAttrSortedBuiltins to drop its own builtin
+	// level and message. This mostly affects the order of slog.TextHandler output.
+	BuiltinsLast bool
+
+	// SampleFunc, if not nil, is called with each record, and the record is
+	// dropped if it returns false, such as to sample INFO records while keeping
+	// all ERROR records. By default it is called after deduplication with the
+	// final record, right before it would be passed to the next handler.
+	SampleFunc func(r slog.Record) bool
+
+	// SampleBeforeDedup, if true, calls SampleFunc with the original record
+	// before deduplication instead, so that dropped records are not deduplicated.
+	// The record then has only its own attributes, not those of any WithAttrs.
+	SampleBeforeDedup bool
+
+	// RequestIDFromContext, if not nil, is called with the context of each
+	// record, and any non-empty request id it returns is added under the
+	// RequestIDKey, as if it were the first attribute of the record. It is then
+	// deduplicated like any other attribute, and is inside any groups from WithGroup.
+	RequestIDFromContext func(ctx context.Context) string
+
+	// RequestIDKey is the key of the request id from RequestIDFromContext.
+	// Defaults to "request_id".
+	RequestIDKey string
+
+	// SequenceKey, if not empty, is the key of an attribute added to the root
+	// level of every record, holding a number that increases by one with each
+	// record, starting at 1. It helps reconstruct the order of records whose
+	// times are equal. The count is shared by all loggers derived from the same
+	// handler. The key is not resolved, and replaces any attribute or group
+	// with the same key at the root level.
+	SequenceKey string
+
+	// ModeTagKey, if not empty, is the key of an attribute added to the root
+	// level of every record, holding the name of this handler's deduplication
+	// mode, such as "overwrite". It helps tell records apart when the output of
+	// differently deduplicated loggers is merged downstream. The key is not
+	// resolved, and replaces any attribute or group with the same key at the
+	// root level.
+	ModeTagKey string
+
+	// GroupDepthKey, if not empty, is the key of an attribute added to the root
+	// level of every record, holding the deepest number of groups opened with
+	// WithGroup that the record's attributes are in. It helps debug loggers with
+	// excessively nested groups. The key is not resolved, and replaces any
+	// attribute or group with the same key at the root level.
+	GroupDepthKey string
+
+	// FingerprintKey, if not empty, is the key of an attribute added to the
+	// root level of every record, holding a hex encoded hash of the record's
+	// level, message, and deduplicated attributes, but not its time or
+	// SequenceKey. Records with the same fingerprint are repeats of each
+	// other, which can be used to deduplicate or count them downstream.
+	// The key is not resolved, and replaces any attribute or group with the
+	// same key at the root level.
+	FingerprintKey string
+
+	// Hasher returns a new hash to create the FingerprintKey value with.
+	// Defaults to 64-bit FNV-1a (hash/fnv.New64a). A faster hash, such as
+	// xxhash, can be used instead at high volume. Fingerprints are only
+	// comparable when created with the same hash.
+	Hasher func() hash.Hash64
+
+	// CacheWithAttrs, if true, resolves and deduplicates the attributes and
+	// groups added with WithAttrs and WithGroup only once per logger, instead
+	// of once per record, and then merges each record's attributes into a copy
+	// of the cached result. This speeds up logging from loggers that have many
+	// With attributes. Because of the caching, any slog.LogValuer's in the With
+	// attributes are only resolved once, the first time a record is handled.
+	CacheWithAttrs bool
+
+	// MaxNodes, if positive, is the maximum number of attributes and groups
+	// that will be processed for each record, including those from WithAttrs
+	// and WithGroup. Once it is exceeded, all remaining attributes and groups
+	// are dropped, and a "_truncated" attribute set to true is added to the
+	// root level. This is a safety valve against huge or adversarial logs.
+	MaxNodes int
+
+	// DedupSliceValues, if true, removes any repeated elements from attribute
+	// values that are string slices ([]string) or slices of any ([]any), keeping
+	// the first of each. Elements of []any that are not comparable are kept.
+	DedupSliceValues bool
+
+	// SplitKeys, if not nil, maps keys to a separator. Attributes with one of
+	// the keys, at any level, whose values are strings are split by the
+	// separator into string slices ([]string), such as "a,b,c" becoming
+	// ["a","b","c"]. The keys are matched before they are resolved.
+	SplitKeys map[string]string
+
+	// Timer, if not nil, is called at the end of each Handle, just before the
+	// record is passed to the next handler, with the time taken to deduplicate
+	// the record. It can be used to record a histogram of the latency added by
+	// this handler. The clock is not read when it is nil.
+	Timer func(d time.Duration)
+
+	// SizeObserver, if not nil, is called for each record with an estimate of
+	// its size in bytes once serialized, which can be used to catch oversized
+	// logs. The estimate is the sum of the lengths of the message and of every
+	// key and value as a string, and does not include the time, level, or any
+	// formatting. It is cheap, because the record is not actually marshaled.
+	SizeObserver func(bytes int)
+
+	// AttrCountObserver, if not nil, is called for each record with its level
+	// and the number of attributes left after deduplication, which can be used
+	// for capacity planning. Attributes inside of groups are counted, but the
+	// groups themselves are not.
+	AttrCountObserver func(level slog.Level, count int)
+
+	// ResolveMessage, if true, passes the record's message through ResolveKey
+	// (and KeyMap) as if it were a root level attribute with the "msg" key.
+	// If the key is unchanged, the message stays the record's message. If the key
+	// is changed, such as to "summary", the message is moved into a root level
+	// attribute with that key, which comes before all other attributes, and the
+	// record's message is left empty. If the key is dropped, so is the message.
+	// Note that the default ResolveKey, IncrementIfBuiltinKeyConflict, changes
+	// "msg" to "msg#01", so this is meant to be used with a ResolveKey or KeyMap
+	// that handles the "msg" key.
+	ResolveMessage bool
+
+	// LargeValueThreshold, if greater than 0 and LargeValueGroup is not empty,
+	// is the length in bytes above which a string or byte slice attribute value
+	// is relocated out of the way, into the LargeValueGroup at the root level.
+	// This keeps the frequently used attributes small, while moving bulky ones,
+	// such as request bodies, aside. Relocated attributes keep the path of groups
+	// they were in, within the LargeValueGroup, and groups left empty are removed.
+	// Values that have been appended together are not relocated.
+	LargeValueThreshold int
+
+	// LargeValueGroup is the key of the root level group that attributes over
+	// the LargeValueThreshold are relocated into. The key is not resolved, and
+	// replaces any attribute or group with the same key at the root level.
+	LargeValueGroup string
+
+	// CollapseSingletonGroups, if true, rewrites each group that contains exactly
+	// one attribute or group into a single key joined by the CollapseSeparator,
+	// such as {"req":{"id":5}} becoming {"req.id":5}. It happens after
+	// deduplication, and a group is not collapsed if another key at the same
+	// level would collide with the collapsed key.
+	CollapseSingletonGroups bool
+
+	// CollapseSeparator is the separator used by CollapseSingletonGroups to join
+	// keys. Defaults to a dot.
+	CollapseSeparator string
+
+	// ShortCircuitUnique, if true, checks each record for duplicate keys before
+	// deduplicating it. If no keys are duplicated at any level, the attributes are
+	// passed to the next handler after only resolving their keys, without building
+	// the tree used to deduplicate them, which is faster. The output is the same.
+	// It has no effect when options are set that need the tree, such as
+	// SequenceKey, FingerprintKey, MaxNodes, or RootCollisionMode.
+	ShortCircuitUnique bool
+
+	// ParallelThreshold, if positive, resolves the values of a record's
+	// attributes (calling any slog.LogValuer) concurrently, when the record has
+	// more attributes than the threshold. The attributes are then deduplicated in
+	// order as usual, so the output is the same. Only the record's own values
+	// are resolved concurrently: any nested in groups, including the groups
+	// that a slog.LogValuer resolves to, are resolved in order, as are any
+	// conversions of the values. This is experimental, and only helps records
+	// with many attributes whose values are slow to resolve.
+	ParallelThreshold int
+}
@@ -0,0 +1,154 @@
+package slogdedup
+
+import (
+	"hash/fnv"
+	"log/slog"
+	"slices"
+
+	"modernc.org/b/v2"
+)
+
+// OrderMode determines the order that the final deduplicated attributes are
+// output in, within the root and within each group.
+type OrderMode int
+
+const (
+	// OrderSorted outputs all attributes sorted by key, using the KeyCompare
+	// function. This is the default.
+	OrderSorted OrderMode = iota
+
+	// OrderInsertion outputs attributes in the order they were logged, with
+	// each key placed at the position of its surviving value. For example, with
+	// the OverwriteHandler, a duplicated key is placed where it was last logged.
+	OrderInsertion
+
+	// OrderInsertionStableDedup outputs attributes in the order they were
+	// logged, with each key placed at the position it was first seen, even if
+	// the surviving value was logged later.
+	OrderInsertionStableDedup
+)
+
+// HashKeyOrder is an ordering function that orders keys by the FNV-1a hash of
+// each key, falling back to byte values if the hashes are equal. The order of
+// any two keys does not depend on any other keys, so it is stable when keys
+// are added, removed, or renamed.
+func HashKeyOrder(a, b string) int {
+	ha, hb := hashKey(a), hashKey(b)
+	if ha < hb {
+		return -1
+	}
+	if ha > hb {
+		return 1
+	}
+	return CaseSensitiveCmp(a, b)
+}
+
+// hashKey returns the FNV-1a hash of the key.
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// attrOrder tracks the insertion index of every key in every tree, so that the
+// final attributes can be output in insertion order instead of sorted order.
+// If the mode is OrderSorted, it instead sorts the final attributes by keyOrder.
+// A nil attrOrder means OrderSorted by the trees' own key comparison
+// functions, and all methods are safe to call on nil.
+type attrOrder struct {
+	mode       OrderMode
+	keyCompare func(a, b string) int
+	keyOrder   func(a, b string) int
+	counter    int
+	indexes    map[attrStore]*b.Tree[string, int]
+}
+
+// newAttrOrder returns an attrOrder for the mode, or nil if the mode is
+// OrderSorted and there is no keyOrder.
+func newAttrOrder(mode OrderMode, keyCompare func(a, b string) int, keyOrder func(a, b string) int) *attrOrder {
+	if mode == OrderSorted {
+		if keyOrder == nil {
+			return nil
+		}
+		return &attrOrder{mode: mode, keyOrder: keyOrder}
+	}
+	return &attrOrder{
+		mode:       mode,
+		keyCompare: keyCompare,
+		indexes:    map[attrStore]*b.Tree[string, int]{},
+	}
+}
+
+// touch records that the key has had a value written to it in the tree.
+// Must only be called when a value was actually written.
+func (o *attrOrder) touch(uniq attrStore, key string) {
+	if o == nil || o.mode == OrderSorted {
+		return
+	}
+	idx, ok := o.indexes[uniq]
+	if !ok {
+		idx = b.TreeNew[string, int](o.keyCompare)
+		o.indexes[uniq] = idx
+	}
+	o.counter++
+	if o.mode == OrderInsertionStableDedup {
+		idx.Put(key, func(oldValue int, exists bool) (int, bool) {
+			return o.counter, !exists
+		})
+		return
+	}
+	idx.Set(key, o.counter)
+}
+
+// clone returns a copy of the attrOrder for the cloned trees, which maps
+// each original tree to its clone. Safe to call on a nil attrOrder.
+func (o *attrOrder) clone(clones map[attrStore]attrStore) *attrOrder {
+	if o == nil {
+		return nil
+	}
+	c := &attrOrder{
+		mode:       o.mode,
+		keyCompare: o.keyCompare,
+		keyOrder:   o.keyOrder,
+		counter:    o.counter,
+		indexes:    make(map[attrStore]*b.Tree[string, int], len(o.indexes)),
+	}
+	for uniq, idx := range o.indexes {
+		cloned, ok := clones[uniq]
+		if !ok {
+			continue // The tree was discarded
+		}
+		cIdx := b.TreeNew[string, int](o.keyCompare)
+		if en, err := idx.SeekFirst(); err == nil {
+			for k, v, err := en.Next(); err == nil; k, v, err = en.Next() {
+				cIdx.Set(k, v)
+			}
+			en.Close()
+		}
+		c.indexes[cloned] = cIdx
+	}
+	return c
+}
+
+// sort re-orders the attributes built from the tree into insertion order,
+// or by keyOrder if the mode is OrderSorted.
+func (o *attrOrder) sort(uniq attrStore, attrs []slog.Attr) {
+	if o == nil {
+		return
+	}
+	if o.mode == OrderSorted {
+		slices.SortStableFunc(attrs, func(a1, a2 slog.Attr) int {
+			return o.keyOrder(a1.Key, a2.Key)
+		})
+		return
+	}
+	idx, ok := o.indexes[uniq]
+	if !ok {
+		return
+	}
+	slices.SortStableFunc(attrs, func(a1, a2 slog.Attr) int {
+		i1, _ := idx.Get(a1.Key)
+		i2, _ := idx.Get(a2.Key)
+		return i1 - i2
+	})
+}
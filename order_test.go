@@ -0,0 +1,268 @@
+package slogdedup
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOrderMode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		mode     OrderMode
+		expected string
+	}{
+		{
+			name:     "sorted",
+			mode:     OrderSorted,
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"WARN","msg":"main message","arg1":"with2arg1","arg2":"with1arg2","arg3":"with2arg3","arg4":"with2arg4","group1":{"arg1":"main1arg1","arg2":"group1with3arg2","arg3":"group1with4arg3","arg4":"group1with4arg4","arg5":"with4inlinedGroupArg5","arg6":"main1arg6","level":"main1level","main1":"arg0","main1group3":{"group3":"group3arg0"},"msg":"with4msg","overwrittenGroup":"with4overwrittenGroup","separateGroup2":{"arg1":"group2arg1","arg2":"group2arg2","group2":"group2arg0"},"source":"with3source","time":"with3time","with3":"arg0","with4":"arg0"},"level#01":{"inlinedLevelGroupKey":"inlinedLevelGroupValue"},"logging.googleapis.com/sourceLocation":"sourceLocationArg","message":"messageArg","message#01":"message#01Arg","msg#01":"with2msg2","msg#01a":"seekbug01a","msg#02":"seekbug02","severity":"severityArg","source#01":"with1source","sourceLoc":"sourceLocArg","time#01":"with1time","timestamp":"timestampArg","timestampRenamed":"timestampRenamedArg","typed":true,"with1":"arg0","with2":"arg0"}`,
+		},
+		{
+			name:     "insertion",
+			mode:     OrderInsertion,
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"WARN","msg":"main message","with1":"arg0","arg2":"with1arg2","source#01":"with1source","time#01":"with1time","with2":"arg0","arg1":"with2arg1","arg3":"with2arg3","arg4":"with2arg4","msg#01a":"seekbug01a","msg#02":"seekbug02","msg#01":"with2msg2","typed":true,"timestamp":"timestampArg","timestampRenamed":"timestampRenamedArg","severity":"severityArg","message":"messageArg","message#01":"message#01Arg","sourceLoc":"sourceLocArg","logging.googleapis.com/sourceLocation":"sourceLocationArg","level#01":{"inlinedLevelGroupKey":"inlinedLevelGroupValue"},"group1":{"with3":"arg0","arg2":"group1with3arg2","separateGroup2":{"group2":"group2arg0","arg1":"group2arg1","arg2":"group2arg2"},"source":"with3source","time":"with3time","with4":"arg0","arg3":"group1with4arg3","arg4":"group1with4arg4","arg5":"with4inlinedGroupArg5","overwrittenGroup":"with4overwrittenGroup","msg":"with4msg","main1":"arg0","arg1":"main1arg1","arg6":"main1arg6","level":"main1level","main1group3":{"group3":"group3arg0"}}}`,
+		},
+		{
+			name:     "insertion stable dedup",
+			mode:     OrderInsertionStableDedup,
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"WARN","msg":"main message","with1":"arg0","arg1":"with2arg1","arg2":"with1arg2","arg3":"with2arg3","source#01":"with1source","time#01":"with1time","typed":true,"with2":"arg0","arg4":"with2arg4","msg#01":"with2msg2","msg#01a":"seekbug01a","msg#02":"seekbug02","level#01":{"inlinedLevelGroupKey":"inlinedLevelGroupValue"},"group1":{"with3":"arg0","arg1":"main1arg1","arg2":"group1with3arg2","arg3":"group1with4arg3","overwrittenGroup":"with4overwrittenGroup","separateGroup2":{"group2":"group2arg0","arg1":"group2arg1","arg2":"group2arg2"},"source":"with3source","time":"with3time","with4":"arg0","arg4":"group1with4arg4","arg5":"with4inlinedGroupArg5","msg":"with4msg","level":"main1level","main1":"arg0","arg6":"main1arg6","main1group3":{"group3":"group3arg0"}},"timestamp":"timestampArg","timestampRenamed":"timestampRenamedArg","severity":"severityArg","message":"messageArg","message#01":"message#01Arg","sourceLoc":"sourceLocArg","logging.googleapis.com/sourceLocation":"sourceLocationArg"}`,
+		},
+	}
+
+	tester := &testHandler{}
+	for _, testCase := range tests {
+		logComplex(t, NewOverwriteHandler(tester, &OverwriteHandlerOptions{CommonOptions: CommonOptions{OrderMode: testCase.mode}}))
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != testCase.expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, testCase.expected, jStr)
+		}
+
+		checkRecordForDuplicates(t, tester.Record)
+	}
+}
+
+func TestTextOrder(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		opts     *OverwriteHandlerOptions
+		expected string
+	}{
+		{
+			name:     "sorted",
+			opts:     &OverwriteHandlerOptions{},
+			expected: `time=2023-09-29T13:00:59.000Z level=INFO msg="main message" method=GET path=/users status=200 user.id=1 user.name=bob`,
+		},
+		{
+			name:     "text order",
+			opts:     &OverwriteHandlerOptions{CommonOptions: CommonOptions{TextOrder: true}},
+			expected: `time=2023-09-29T13:00:59.000Z level=INFO msg="main message" path=/users method=GET user.name=bob user.id=1 status=200`,
+		},
+		{
+			name:     "explicit order mode wins",
+			opts:     &OverwriteHandlerOptions{CommonOptions: CommonOptions{TextOrder: true, OrderMode: OrderInsertion}},
+			expected: `time=2023-09-29T13:00:59.000Z level=INFO msg="main message" method=GET user.name=bob user.id=1 status=200 path=/users`,
+		},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		log := slog.New(NewOverwriteHandler(tester, testCase.opts)).With("path", "/", "method", "GET")
+		log.Info("main message", slog.Group("user", "name", "bob", "id", 1), "status", 200, "path", "/users")
+
+		if s := strings.TrimSpace(tester.String()); s != testCase.expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, testCase.expected, s)
+		}
+	}
+}
+
+func TestKeyOrder(t *testing.T) {
+	t.Parallel()
+
+	// Deduplicate keys by their lower-case form, but sort them as they were logged
+	for _, short := range []bool{false, true} {
+		tester := &testHandler{}
+		opts := &OverwriteHandlerOptions{KeyCompare: CaseInsensitiveCmp, CommonOptions: CommonOptions{KeyOrder: CaseSensitiveCmp, ShortCircuitUnique: short}}
+		log := slog.New(NewOverwriteHandler(tester, opts)).With("b", 1, "C", 2)
+		log.Info("main message", "a", 3, "B", 4, "Z", 5, slog.Group("g", "y", 6, "X", 7))
+
+		expected := `time=2023-09-29T13:00:59.000Z level=INFO msg="main message" B=4 C=2 Z=5 a=3 g.X=7 g.y=6`
+		if s := strings.TrimSpace(tester.String()); s != expected {
+			t.Errorf("Expected:\n%s\nGot:\n%s", expected, s)
+		}
+
+		log.Info("main message", "a", 3, "Z", 5, slog.Group("g", "y", 6, "X", 7))
+		expected = `time=2023-09-29T13:00:59.000Z level=INFO msg="main message" C=2 Z=5 a=3 b=1 g.X=7 g.y=6`
+		if s := strings.TrimSpace(tester.String()); s != expected {
+			t.Errorf("Expected:\n%s\nGot:\n%s", expected, s)
+		}
+	}
+}
+
+func TestHashOrder(t *testing.T) {
+	t.Parallel()
+
+	keys := func(args ...any) []string {
+		tester := &testHandler{}
+		slog.New(NewOverwriteHandler(tester, &OverwriteHandlerOptions{CommonOptions: CommonOptions{HashOrder: true}})).Info("main message", args...)
+		var keys []string
+		tester.Record.Attrs(func(a slog.Attr) bool {
+			keys = append(keys, a.Key)
+			return true
+		})
+		return keys
+	}
+
+	// Adding an unrelated key must not change the order of the other keys
+	before := keys("arg1", 1, "arg2", 2, "arg3", 3, "other", 4)
+	after := slices.DeleteFunc(keys("arg1", 1, "arg2", 2, "arg3", 3, "other", 4, "added", 5), func(key string) bool { return key == "added" })
+	if !slices.Equal(before, after) {
+		t.Errorf("Expected:\n%v\nGot:\n%v", before, after)
+	}
+	if !slices.IsSortedFunc(before, HashKeyOrder) || slices.IsSorted(before) {
+		t.Errorf("Expected hash order, got: %v", before)
+	}
+}
+
+func TestSortBuiltins(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		handler  func(next slog.Handler) slog.Handler
+		expected string
+	}{
+		{
+			name: "overwrite",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{SortBuiltins: true}})
+			},
+			expected: `arg1=val1 level=INFO msg="main message" msg#01=user time=2023-09-29T13:00:59.000Z zed=val2`,
+		},
+		{
+			name: "increment",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{SortBuiltins: true, EnsureTime: true}})
+			},
+			expected: `arg1=val1 level=INFO msg="main message" msg#01=user time=2023-09-29T13:00:59.000Z zed=val2`,
+		},
+		{
+			name: "ignore-insertion",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{SortBuiltins: true, OrderMode: OrderInsertion}})
+			},
+			expected: `zed=val2 msg#01=user arg1=val1 level=INFO time=2023-09-29T13:00:59.000Z msg="main message"`,
+		},
+		{
+			name: "overwrite-short-circuit",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{SortBuiltins: true, ShortCircuitUnique: true}})
+			},
+			expected: `arg1=val1 level=INFO msg="main message" msg#01=user time=2023-09-29T13:00:59.000Z zed=val2`,
+		},
+		{
+			name: "ignore-short-circuit",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{SortBuiltins: true, ShortCircuitUnique: true}})
+			},
+			expected: `arg1=val1 level=INFO msg="main message" msg#01=user time=2023-09-29T13:00:59.000Z zed=val2`,
+		},
+		{
+			name: "increment-short-circuit",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{SortBuiltins: true, ShortCircuitUnique: true}})
+			},
+			expected: `arg1=val1 level=INFO msg="main message" msg#01=user time=2023-09-29T13:00:59.000Z zed=val2`,
+		},
+		{
+			name: "append-short-circuit",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{SortBuiltins: true, ShortCircuitUnique: true}})
+			},
+			expected: `arg1=val1 level=INFO msg="main message" msg#01=user time=2023-09-29T13:00:59.000Z zed=val2`,
+		},
+	}
+
+	for _, testCase := range tests {
+		buf := &bytes.Buffer{}
+		h := testCase.handler(slog.NewTextHandler(buf, &slog.HandlerOptions{ReplaceAttr: ReplaceAttrSortedBuiltins}))
+
+		r := slog.NewRecord(time.Date(2023, 9, 29, 13, 0, 59, 0, time.UTC), slog.LevelInfo, "main message", 0)
+		r.AddAttrs(slog.String("zed", "val2"), slog.String("msg", "user"), slog.String("arg1", "val1"))
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Errorf("%s Unexpected error: %v", testCase.name, err)
+		}
+
+		if s := strings.TrimSpace(buf.String()); s != testCase.expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, testCase.expected, s)
+		}
+	}
+}
+
+func TestBuiltinsLast(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		handler  func(next slog.Handler) slog.Handler
+		expected string
+	}{
+		{
+			name: "overwrite",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{BuiltinsLast: true}})
+			},
+			expected: `arg1=val1 msg#01=user zed=val2 time=2023-09-29T13:00:59.000Z level=INFO msg="main message"`,
+		},
+		{
+			name: "ignore-sorted",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{BuiltinsLast: true, SortBuiltins: true}})
+			},
+			expected: `arg1=val1 msg#01=user zed=val2 time=2023-09-29T13:00:59.000Z level=INFO msg="main message"`,
+		},
+		{
+			name: "increment",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{BuiltinsLast: true, OrderMode: OrderInsertion}})
+			},
+			expected: `zed=val2 msg#01=user arg1=val1 time=2023-09-29T13:00:59.000Z level=INFO msg="main message"`,
+		},
+		{
+			name: "append",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{BuiltinsLast: true, OrderMode: OrderInsertion}})
+			},
+			expected: `zed=val2 msg#01=user arg1=val1 time=2023-09-29T13:00:59.000Z level=INFO msg="main message"`,
+		},
+	}
+
+	for _, testCase := range tests {
+		buf := &bytes.Buffer{}
+		h := testCase.handler(slog.NewTextHandler(buf, &slog.HandlerOptions{ReplaceAttr: ReplaceAttrSortedBuiltins}))
+
+		r := slog.NewRecord(time.Date(2023, 9, 29, 13, 0, 59, 0, time.UTC), slog.LevelInfo, "main message", 0)
+		r.AddAttrs(slog.String("zed", "val2"), slog.String("msg", "user"), slog.String("arg1", "val1"))
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Errorf("%s Unexpected error: %v", testCase.name, err)
+		}
+
+		if s := strings.TrimSpace(buf.String()); s != testCase.expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, testCase.expected, s)
+		}
+	}
+}
@@ -2,15 +2,19 @@ package slogdedup
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"log/slog"
 	"slices"
-
-	"modernc.org/b/v2"
+	"time"
 )
 
 // OverwriteHandlerOptions are options for a OverwriteHandler
 type OverwriteHandlerOptions struct {
-	// Comparison function to determine if two keys are equal
+	// Comparison function to determine if two keys are equal. Keys that compare
+	// as 0 are the same key, and are deduplicated. Unless KeyOrder is set, it
+	// also sorts the keys when OrderMode is OrderSorted, so it must be a
+	// consistent ordering of all keys. Defaults to CaseSensitiveCmp.
 	KeyCompare func(a, b string) int
 
 	// Function that will be called on each attribute and group, to determine
@@ -23,17 +27,145 @@ type OverwriteHandlerOptions struct {
 	//
 	// ResolveKey will not be called for the built-in fields on slog.Record
 	// (ie: time, level, msg, and source).
+	//
+	// ResolveKey is called on group names exactly as on attribute keys,
+	// regardless of whether the group came from WithGroup or slog.Group, but it
+	// is not called on the empty names of inlined groups.
+	// The index argument is 0 for this handler, unless RootCollisionMode or
+	// InlineCollisionMode is DedupIncrement.
 	ResolveKey func(groups []string, key string, _ int) (string, bool)
+
+	// CommonOptions are the options shared by all the handlers. With
+	// ParallelThreshold, the BytesAs and TimeAttrLayout conversions are still
+	// done in order.
+	CommonOptions
+
+	// TypeDisambiguate, if true, keeps both a group and an attribute that is not
+	// a group when they have the same key, instead of deduplicating them, by
+	// adding GroupKeySuffix to the group's key. For example, "user" keeps the
+	// attribute, while "user#group" holds the group. Groups and other attributes
+	// are still deduplicated among themselves.
+	TypeDisambiguate bool
+
+	// BytesAs determines how attribute values that are byte slices are
+	// rendered, because handlers are inconsistent with them.
+	// Defaults to BytesAsIs, which leaves them alone.
+	BytesAs BytesFormat
+
+	// TimeAttrLayout, if not empty, is the layout used to format any attribute
+	// values that are times (slog.KindTime) into strings, such as time.RFC3339,
+	// because handlers are inconsistent with them. It does not affect the
+	// record's builtin time.
+	TimeAttrLayout string
+
+	// BoolAggregate determines how duplicate attributes that both have boolean
+	// values are combined, such as a flag that is logged multiple times.
+	// Defaults to BoolLast, which overwrites them like any other attribute.
+	BoolAggregate BoolAggregate
+
+	// WarnOnGroupLoss, if true, adds an attribute next to any non-empty group that
+	// is overwritten, by another group or by an attribute, with the group's key
+	// and OverwrittenChildrenSuffix, and the number of attributes and groups
+	// directly within the group that were lost. Does not apply at the root level
+	// when RootCollisionMode is set.
+	WarnOnGroupLoss bool
+
+	// KeyAliases, if not empty, maps legacy or alternate keys to their canonical
+	// key, such as "usr" to "user", at all levels. Aliases are applied before
+	// KeyMap and ResolveKey, so an aliased key is deduplicated together with its
+	// canonical key. Aliases are not applied recursively.
+	KeyAliases map[string]string
+
+	// AllowKeys, if not empty, is a list of the only keys that are allowed.
+	// All other attributes and groups are dropped, such as for compliance.
+	// Each entry is the path of keys to an attribute, with group names and the
+	// attribute key joined with dots, such as "group1.arg1". Allowing a group
+	// allows everything inside of it. Each dotted segment may use the syntax
+	// of path.Match, such as "*" to match any single key, or may be "**" to
+	// match any number of keys, such as "**.id" to allow "id" at any depth.
+	// A dot that is part of a key must be escaped with a backslash, such as
+	// "http\\.method". Keys are matched after they have been resolved with
+	// ResolveKey.
+	AllowKeys []string
+
+	// DenyKeys is a list of keys to drop, wherever they appear, such as
+	// passwords or other secrets. It uses the same dotted path syntax as
+	// AllowKeys, except that a single key with no group names matches at any
+	// depth, so "password" drops "password" everywhere, the same as
+	// "**.password", while "group1.password" only drops it inside of "group1".
+	// Denying a group drops everything in it. DenyKeys takes precedence over
+	// AllowKeys. Keys are matched after they have been resolved with ResolveKey.
+	DenyKeys []string
+
+	// DenyKeysFromContext, if not nil, is called with the context of each record,
+	// and returns a list of additional keys to drop from only that record, such
+	// as a request scoped list of personal information to suppress. It uses the
+	// same dotted path syntax as DenyKeys, and also matches keys after they have
+	// been resolved, including those added with WithAttrs. Groups left empty are
+	// dropped too.
+	DenyKeysFromContext func(ctx context.Context) []string
+
+	// StrictBuiltins, if true, causes Handle to return an error wrapping
+	// ErrBuiltinKeyConflict, instead of logging the record, if any root level
+	// attribute or group key conflicts with one of the builtin keys
+	// (ie: time, level, msg, and source). The check happens before ResolveKey.
+	StrictBuiltins bool
+
+	// RecoverValuers, if true, recovers any panic in the LogValue method of an
+	// attribute's slog.LogValuer, and replaces the attribute's value with a
+	// short string describing the panic. Without it, slog.Value.Resolve still
+	// recovers, but replaces the value with an error including a stack trace.
+	RecoverValuers bool
+
+	// TraceCollisions, if true, records every attribute or group that
+	// overwrites an older one with the same key, and adds an event named
+	// CollisionEventName to the span returned by SpanFromContext for each.
+	// This helps find noisy loggers while debugging.
+	TraceCollisions bool
+
+	// DebugDuplicates, if not nil, is written a JSON line for every attribute or
+	// group that overwrites an older one with the same key, holding the path to
+	// the key as a JSON pointer (such as "/group1/arg1"), and both the kept and
+	// the dropped values. For example:
+	//
+	//	{"path":"/group1/arg1","kept":"new","dropped":"old"}
+	//
+	// This helps find noisy loggers while debugging, without any tracing setup.
+	// The lines for each record are written with a single call to Write.
+	DebugDuplicates io.Writer
+
+	// SpanFromContext returns the active span of the trace in the context, or
+	// nil if there is none. Required for TraceCollisions.
+	// See CollisionSpan for an OpenTelemetry adapter.
+	SpanFromContext func(ctx context.Context) CollisionSpan
+
+	// LevelRouter, if not nil, is called with the level of each record after it
+	// has been deduplicated, and returns the handler to pass the record to, such
+	// as a separate handler for errors. If it returns nil, the record is passed
+	// to the next handler. This keeps deduplication to a single pass while
+	// fanning out by level. The routed handlers are also used by Enabled.
+	LevelRouter func(level slog.Level) slog.Handler
 }
 
 // OverwriteHandler is a slog.Handler middleware that will deduplicate all attributes and
 // groups by overwriting any older attributes or groups with the same string key.
 // It passes the final record and attributes off to the next handler when finished.
 type OverwriteHandler struct {
-	next       slog.Handler
-	goa        *groupOrAttrs
-	keyCompare func(a, b string) int
-	resolveKey func(groups []string, key string, _ int) (string, bool)
+	handlerConfig
+	cache               *goaCache
+	bytesAs             BytesFormat
+	timeAttrLayout      string
+	boolAggregate       BoolAggregate
+	warnGroupLoss       bool
+	allowKeys           keyPatterns
+	denyKeys            keyPatterns
+	denyKeysFromContext func(ctx context.Context) []string
+	strict              bool
+	recoverValuers      bool
+	traceCollisions     bool
+	debugDuplicates     *duplicateDebugger
+	spanFromContext     func(ctx context.Context) CollisionSpan
+	levelRouter         func(level slog.Level) slog.Handler
 }
 
 var _ slog.Handler = &OverwriteHandler{} // Assert conformance with interface
@@ -47,6 +179,10 @@ var _ slog.Handler = &OverwriteHandler{} // Assert conformance with interface
 //		Pipe(slogdedup.NewOverwriteMiddleware(&slogdedup.OverwriteHandlerOptions{})).
 //		Handler(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{})),
 //	))
+//
+// The middleware and its options can be shared, such as by the branches of a
+// slogmulti.Fanout, because each handler keeps its own groups and attributes,
+// and the options are never modified.
 func NewOverwriteMiddleware(options *OverwriteHandlerOptions) func(slog.Handler) slog.Handler {
 	return func(next slog.Handler) slog.Handler {
 		return NewOverwriteHandler(
@@ -60,56 +196,207 @@ func NewOverwriteMiddleware(options *OverwriteHandlerOptions) func(slog.Handler)
 // groups by overwriting any older attributes or groups with the same string key.
 // It passes the final record and attributes off to the next handler when finished.
 // If opts is nil, the default options are used.
+// If next is nil, all records are discarded instead of panicking.
 func NewOverwriteHandler(next slog.Handler, opts *OverwriteHandlerOptions) *OverwriteHandler {
 	if opts == nil {
 		opts = &OverwriteHandlerOptions{}
+	} else {
+		// Copy the options, so that options shared between handlers are not modified
+		copied := *opts
+		opts = &copied
 	}
 	if opts.KeyCompare == nil {
 		opts.KeyCompare = CaseSensitiveCmp
 	}
+	if opts.TextOrder && opts.OrderMode == OrderSorted {
+		opts.OrderMode = OrderInsertionStableDedup
+	}
+	if opts.HashOrder && opts.KeyOrder == nil {
+		opts.KeyOrder = HashKeyOrder
+	}
+	if opts.DropTime {
+		opts.EnsureTime = false
+	}
+	if opts.RequestIDKey == "" {
+		opts.RequestIDKey = "request_id"
+	}
 	if opts.ResolveKey == nil {
 		opts.ResolveKey = IncrementIfBuiltinKeyConflict
 	}
 
-	return &OverwriteHandler{
-		next:       next,
-		keyCompare: opts.KeyCompare,
-		resolveKey: opts.ResolveKey,
+	resolveKey := withReservedKeys(opts.ReservedOutputKeys, withPrometheusKeys(opts.SanitizeKeysPrometheus, withKeyMap(aliasKeyMap(opts.KeyAliases), withKeyMap(opts.KeyMap, opts.ResolveKey))))
+
+	h := &OverwriteHandler{
+		handlerConfig: handlerConfig{
+			next:               nextOrDiscard(next),
+			keyCompare:         opts.KeyCompare,
+			keyCompareForGroup: opts.KeyCompareForGroup,
+			keyOrder:           opts.KeyOrder,
+			resolveKey:         resolveKey,
+			orderMode:          opts.OrderMode,
+			depthGuard:         newGoaDepthGuard(opts.MaxGoaDepth),
+			unifyGroups:        opts.UnifyGroupSources,
+			typeDisambiguate:   opts.TypeDisambiguate,
+			rootCollider:       newCollider(opts.RootCollisionMode, resolveKey),
+			inlineCollider:     newCollider(opts.InlineCollisionMode, resolveKey),
+			ensureTime:         opts.EnsureTime,
+			dropTime:           opts.DropTime,
+			ensureKeys:         opts.EnsureKeys,
+			sortBuiltins:       opts.SortBuiltins || opts.BuiltinsLast,
+			builtinsLast:       opts.BuiltinsLast,
+			sample:             opts.SampleFunc,
+			sampleBeforeDedup:  opts.SampleBeforeDedup,
+			requestID:          opts.RequestIDFromContext,
+			requestIDKey:       opts.RequestIDKey,
+			sequence:           newSequencer(opts.SequenceKey),
+			modeTagKey:         opts.ModeTagKey,
+			groupDepthKey:      opts.GroupDepthKey,
+			fingerprint:        newFingerprinter(opts.FingerprintKey, opts.Hasher),
+			newStore:           newBtreeStore,
+			maxNodes:           opts.MaxNodes,
+			dedupSlices:        opts.DedupSliceValues,
+			splitKeys:          opts.SplitKeys,
+			timer:              opts.Timer,
+			sizeObserver:       opts.SizeObserver,
+			attrCountObserver:  opts.AttrCountObserver,
+			resolveMessage:     opts.ResolveMessage && !opts.SortBuiltins && !opts.BuiltinsLast,
+			largeValues:        newLargeValueMover(opts.LargeValueThreshold, opts.LargeValueGroup),
+			collapseSeparator:  newCollapseSeparator(opts.CollapseSingletonGroups, opts.CollapseSeparator),
+			parallelThreshold:  opts.ParallelThreshold,
+		},
+		cache:               newGoaCache(opts.CacheWithAttrs),
+		bytesAs:             opts.BytesAs,
+		timeAttrLayout:      opts.TimeAttrLayout,
+		boolAggregate:       opts.BoolAggregate,
+		warnGroupLoss:       opts.WarnOnGroupLoss,
+		allowKeys:           newKeyPatterns(opts.AllowKeys, false),
+		denyKeys:            newKeyPatterns(opts.DenyKeys, true),
+		denyKeysFromContext: opts.DenyKeysFromContext,
+		strict:              opts.StrictBuiltins,
+		recoverValuers:      opts.RecoverValuers,
+		traceCollisions:     opts.TraceCollisions && opts.SpanFromContext != nil,
+		debugDuplicates:     newDuplicateDebugger(opts.DebugDuplicates),
+		spanFromContext:     opts.SpanFromContext,
+		levelRouter:         opts.LevelRouter,
 	}
+
+	// Records can only skip building the tree if no options need it
+	shortCircuit := opts.ShortCircuitUnique && h.canShortCircuit() &&
+		opts.BytesAs == BytesAsIs && opts.TimeAttrLayout == "" && opts.AllowKeys == nil && opts.DenyKeys == nil &&
+		opts.DenyKeysFromContext == nil && !opts.StrictBuiltins && !opts.RecoverValuers
+	h.unique = newUniqueScanner(shortCircuit, resolveKey, opts.KeyCompare, opts.KeyCompareForGroup, opts.KeyOrder, opts.OrderMode)
+	return h
 }
 
-// Enabled reports whether the next handler handles records at the given level.
+// Enabled reports whether the next handler, or the handler chosen by LevelRouter, handles records at the given level.
 // The handler ignores records whose level is lower.
 func (h *OverwriteHandler) Enabled(ctx context.Context, level slog.Level) bool {
-	return h.next.Enabled(ctx, level)
+	return h.route(level).Enabled(ctx, level)
 }
 
 // Handle de-duplicates all attributes and groups, then passes the new set of attributes to the next handler.
 func (h *OverwriteHandler) Handle(ctx context.Context, r slog.Record) error {
+	var start time.Time
+	if h.timer != nil {
+		start = time.Now()
+	}
+
+	// Drop records that are not sampled, before paying to deduplicate them
+	if h.sample != nil && h.sampleBeforeDedup && !h.sample(r) {
+		return nil
+	}
+	if h.dropTime {
+		r.Time = time.Time{}
+	}
+
+	// Warn once if this logger has an excessively deep chain of With/WithGroup calls
+	h.depthGuard.check(ctx, h.route(slog.LevelWarn), h.goa)
+
 	// The final set of attributes on the record, is basically the same as a final With-Attributes groupOrAttrs.
 	// So collect all final attributes and turn them into a groupOrAttrs so that it can be handled the same.
-	finalAttrs := make([]slog.Attr, 0, r.NumAttrs())
+	finalAttrs := make([]slog.Attr, 0, r.NumAttrs()+1)
+	if h.requestID != nil {
+		if id := h.requestID(ctx); id != "" {
+			finalAttrs = append(finalAttrs, slog.String(h.requestIDKey, id))
+		}
+	}
 	r.Attrs(func(a slog.Attr) bool {
 		finalAttrs = append(finalAttrs, a)
 		return true
 	})
-	goas := collectGroupOrAttrs(h.goa, &groupOrAttrs{attrs: finalAttrs})
+	resolveParallel(finalAttrs, h.parallelThreshold, func(v slog.Value) slog.Value { return resolveValue(v, h.recoverValuers) })
+
+	// Skip building the tree if no keys are duplicated
+	if attrs, ok := h.unique.attrs(collectGroupOrAttrs(h.goa, &groupOrAttrs{attrs: finalAttrs})); ok {
+		return h.forward(ctx, r, r.Message, attrs, start)
+	}
+
+	// Resolve groups and with-attributes, followed by the final attributes
+	state, uniq := h.createRecordTree(finalAttrs)
+	if state.err != nil {
+		return state.err
+	}
+	traceCollisions(ctx, h.spanFromContext, state.collisions)
+	h.debugDuplicates.write(state.collisions)
+	if h.denyKeysFromContext != nil {
+		newKeyPatterns(h.denyKeysFromContext(ctx), true).prune(uniq, nil)
+	}
+	h.largeValues.move(state, uniq, h.keyCompareFor)
+	state.addTruncated(uniq)
+	h.fingerprint.add(state, uniq, r)
+	h.sequence.add(state, uniq)
+	addModeTag(state, uniq, h.modeTagKey, DedupOverwrite)
+	state.addGroupDepth(uniq, h.groupDepthKey)
+	ensureKeys(state, uniq, h.ensureKeys)
+	if h.sortBuiltins {
+		r = addBuiltins(state, uniq, r, h.ensureTime)
+	}
+	if h.sizeObserver != nil {
+		h.sizeObserver(len(r.Message) + estimateTreeSize(uniq))
+	}
 
-	// Resolve groups and with-attributes
-	uniq := b.TreeNew[string, any](h.keyCompare)
-	h.createAttrTree(uniq, goas, nil)
+	// Optionally relocate the message, as if it were a root level attribute
+	msg, msgAttrs := r.Message, []slog.Attr(nil)
+	if h.resolveMessage {
+		msg, msgAttrs = resolveMessage(uniq, h.resolveKey, r.Message)
+	}
 
+	// Add deduplicated attributes back in
+	attrs := append(msgAttrs, buildAttrs(uniq, state.order, AppendedGroupMap)...)
+	if h.builtinsLast {
+		attrs = moveBuiltinsLast(attrs)
+	}
+	if h.collapseSeparator != "" {
+		attrs = collapseSingletonGroups(attrs, h.collapseSeparator)
+	}
+	return h.forward(ctx, r, msg, attrs, start)
+}
+
+// forward creates a new record with the message and the deduplicated
+// attributes, and passes it off to the next handler.
+func (h *OverwriteHandler) forward(ctx context.Context, r slog.Record, msg string, attrs []slog.Attr, start time.Time) error {
 	// Add all attributes to new record (because old record has all the old attributes)
 	newR := &slog.Record{
 		Time:    r.Time,
 		Level:   r.Level,
-		Message: r.Message,
+		Message: msg,
 		PC:      r.PC,
 	}
+	if h.ensureTime && !h.sortBuiltins && newR.Time.IsZero() {
+		newR.Time = time.Now()
+	}
 
-	// Add deduplicated attributes back in
-	newR.AddAttrs(buildAttrs(uniq)...)
-	return h.next.Handle(ctx, *newR)
+	if h.attrCountObserver != nil {
+		h.attrCountObserver(newR.Level, countAttrs(attrs))
+	}
+	newR.AddAttrs(attrs...)
+	if h.timer != nil {
+		h.timer(time.Since(start))
+	}
+	if h.sample != nil && !h.sampleBeforeDedup && !h.sample(*newR) {
+		return nil
+	}
+	return h.route(newR.Level).Handle(ctx, *newR)
 }
 
 // WithGroup returns a new OverwriteHandler that still has h's attributes,
@@ -117,6 +404,8 @@ func (h *OverwriteHandler) Handle(ctx context.Context, r slog.Record) error {
 func (h *OverwriteHandler) WithGroup(name string) slog.Handler {
 	h2 := *h
 	h2.goa = h2.goa.WithGroup(name)
+	h2.cache = newGoaCache(h.cache != nil)
+	h2.depthGuard = h.depthGuard.derive()
 	return &h2
 }
 
@@ -124,69 +413,253 @@ func (h *OverwriteHandler) WithGroup(name string) slog.Handler {
 func (h *OverwriteHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
 	h2 := *h
 	h2.goa = h2.goa.WithAttrs(attrs)
+	h2.cache = newGoaCache(h.cache != nil)
+	h2.depthGuard = h.depthGuard.derive()
+	return &h2
+}
+
+// Clone returns a new OverwriteHandler with the same next handler, options, groups, and attributes as h.
+// The clone is independent of h, so calling WithAttrs or WithGroup on either one does not affect the other,
+// which is useful for building divergent trees of loggers from a single configured handler.
+func (h *OverwriteHandler) Clone() *OverwriteHandler {
+	h2 := *h
+	h2.cache = newGoaCache(h.cache != nil)
+	h2.depthGuard = h.depthGuard.derive()
 	return &h2
 }
 
+// WithMode returns a new handler that deduplicates according to the mode,
+// such as an AppendHandler for DedupAppend, keeping this handler's groups,
+// attributes, and the options that all handlers share. KeyAliases also carries
+// over, because it is applied when resolving keys, but the other options that
+// are specific to an OverwriteHandler are dropped, and those specific to the new
+// handler are left at their defaults. Returns this handler if the mode is
+// DedupDefault or DedupOverwrite, and panics if the mode is unknown.
+func (h *OverwriteHandler) WithMode(mode DedupMode) slog.Handler {
+	if mode == DedupDefault || mode == DedupOverwrite {
+		return h
+	}
+	return newHandlerWithMode(h.handlerConfig, h.cache != nil, mode)
+}
+
+// route returns the handler that records of the level are passed to, which is
+// the next handler unless LevelRouter returns another.
+func (h *OverwriteHandler) route(level slog.Level) slog.Handler {
+	if h.levelRouter != nil {
+		if routed := h.levelRouter(level); routed != nil {
+			return routed
+		}
+	}
+	return h.next
+}
+
 // createAttrTree recursively goes through all groupOrAttrs, resolving their attributes and creating subtrees as
 // necessary, adding the results to the map
-func (h *OverwriteHandler) createAttrTree(uniq *b.Tree[string, any], goas []*groupOrAttrs, groups []string) {
+func (h *OverwriteHandler) createAttrTree(state *handleState, uniq attrStore, goas []*groupOrAttrs, groups []string) {
 	if len(goas) == 0 {
 		return
 	}
 
 	// If a group is encountered, create a subtree for that group and all groupOrAttrs after it
 	if goas[0].group != "" {
-		if key, ok := h.resolveKey(groups, goas[0].group, 0); ok {
-			uniqGroup := b.TreeNew[string, any](h.keyCompare)
-			h.createAttrTree(uniqGroup, goas[1:], append(slices.Clip(groups), key))
-			// Ignore empty groups, otherwise put subtree into the map
-			if uniqGroup.Len() > 0 {
-				uniq.Set(key, uniqGroup)
+		if !state.visit(h.maxNodes) {
+			return // Over the budget, so drop the group and everything after it
+		}
+		h.checkBuiltin(state, groups, goas[0].group)
+		if key, ok := h.resolveKeyIn(state, uniq, groups, goas[0].group); ok {
+			if !h.keepKey(groups, key, true) {
+				state.dropped = true
+				return // Drop the group and everything in it
+			}
+			groupPath := append(slices.Clip(groups), key)
+			state.openGroup(len(groupPath))
+			uniqGroup, merged := subtreeFor(uniq, key, h.keyCompareFor(groupPath), h.unifyGroups)
+			deferred := state.deferGroup(uniq, uniqGroup, key, groupPath, merged)
+			h.createAttrTree(state, uniqGroup, goas[1:], groupPath)
+			// Ignore empty, merged, and deferred groups, otherwise put subtree into the map
+			if !deferred && !merged && uniqGroup.Len() > 0 {
+				h.putGroup(state, uniq, groups, key, uniqGroup)
 			}
 			return
 		}
 	}
 
 	// Otherwise, set all attributes for this groupOrAttrs, and then call again for remaining groupOrAttrs's
-	h.resolveValues(uniq, goas[0].attrs, groups)
-	h.createAttrTree(uniq, goas[1:], groups)
+	h.resolveValues(state, uniq, goas[0].attrs, groups)
+	h.createAttrTree(state, uniq, goas[1:], groups)
 }
 
 // resolveValues iterates through the attributes, resolving them and putting them into the map.
 // If a group is encountered (as an attribute), it will be separately resolved and added as a subtree.
 // Since attributes are ordered from oldest to newest, it overwrites keys as it goes.
-func (h *OverwriteHandler) resolveValues(uniq *b.Tree[string, any], attrs []slog.Attr, groups []string) {
+func (h *OverwriteHandler) resolveValues(state *handleState, uniq attrStore, attrs []slog.Attr, groups []string) {
 	var ok bool
 	for _, a := range attrs {
-		a.Value = a.Value.Resolve()
+		a.Value = resolveValue(a.Value, h.recoverValuers)
 		if a.Equal(slog.Attr{}) {
 			continue // Ignore empty attributes, and keep iterating
 		}
+		if !state.visit(h.maxNodes) {
+			return // Over the budget, so drop all remaining attributes
+		}
+
+		// Groups with empty keys are inlined, and have no key to resolve
+		if a.Key == "" && a.Value.Kind() == slog.KindGroup {
+			state.inline(groups, func() { h.resolveValues(state, uniq, a.Value.Group(), groups) })
+			continue
+		}
+		a.Value = formatBytes(a.Value, h.bytesAs)
+		a.Value = formatTime(a.Value, h.timeAttrLayout)
+		a.Value = splitValue(a.Value, h.splitKeys[a.Key])
+		a.Value = dedupSliceValue(a.Value, h.dedupSlices)
+		h.checkBuiltin(state, groups, a.Key)
 
 		// Default situation: resolve the key and put it into the map
-		a.Key, ok = h.resolveKey(groups, a.Key, 0)
-		if !ok {
+		a.Key, ok = h.resolveKeyIn(state, uniq, groups, a.Key)
+		if !ok || !h.keepKey(groups, a.Key, a.Value.Kind() == slog.KindGroup) {
 			continue
 		}
 
 		if a.Value.Kind() != slog.KindGroup {
-			uniq.Set(a.Key, a)
+			if h.typeDisambiguate {
+				moveGroupAside(state, uniq, a.Key)
+			}
+			if state.colliderFor(groups, h.rootCollider, h.inlineCollider) == nil {
+				a = aggregateBool(uniq, a, h.boolAggregate)
+			}
+			h.set(state, uniq, groups, a.Key, a)
 			continue
 		}
 
-		// Groups with empty keys are inlined
+		// Groups whose keys were resolved to empty are also inlined
 		if a.Key == "" {
-			h.resolveValues(uniq, a.Value.Group(), groups)
+			state.inline(groups, func() { h.resolveValues(state, uniq, a.Value.Group(), groups) })
 			continue
 		}
 
 		// Create a subtree for this group
-		uniqGroup := b.TreeNew[string, any](h.keyCompare)
-		h.resolveValues(uniqGroup, a.Value.Group(), append(slices.Clip(groups), a.Key))
+		uniqGroup, merged := subtreeFor(uniq, a.Key, h.keyCompareFor(append(slices.Clip(groups), a.Key)), h.unifyGroups)
+		h.resolveValues(state, uniqGroup, a.Value.Group(), append(slices.Clip(groups), a.Key))
+
+		// Ignore empty and merged groups, otherwise put subtree into the map
+		if !merged && uniqGroup.Len() > 0 {
+			h.putGroup(state, uniq, groups, a.Key, uniqGroup)
+		}
+	}
+}
+
+// keepKey reports whether the attribute or group with the resolved key should
+// be kept, according to the allowed and denied keys.
+func (h *OverwriteHandler) keepKey(groups []string, key string, isGroup bool) bool {
+	if isGroup && key == "" {
+		return true // Inlined groups are checked by their contents
+	}
+	if h.denyKeys != nil && h.denyKeys.match(groups, key) {
+		return false
+	}
+	if h.allowKeys == nil {
+		return true
+	}
+	return h.allowKeys.match(groups, key) || (isGroup && h.allowKeys.leadsTo(groups, key))
+}
+
+// checkBuiltin records an error on the state if strict builtins are enabled
+// and the root level key conflicts with a builtin key.
+func (h *OverwriteHandler) checkBuiltin(state *handleState, groups []string, key string) {
+	if h.strict && state.err == nil && len(groups) == 0 && doesBuiltinKeyConflict(key) {
+		state.err = fmt.Errorf("%w: %q", ErrBuiltinKeyConflict, key)
+	}
+}
+
+// resolveKeyIn resolves the attribute key or group name within the group path.
+func (h *OverwriteHandler) resolveKeyIn(state *handleState, uniq attrStore, groups []string, key string) (string, bool) {
+	return resolveKeyIn(state, uniq, groups, key, h.rootCollider, h.inlineCollider, h.resolveKey, nil)
+}
+
+// keyCompareFor returns the function to compare keys within the group path,
+// which is nil for the root level.
+func (h *OverwriteHandler) keyCompareFor(groups []string) func(a, b string) int {
+	if h.keyCompareForGroup != nil {
+		if keyCompare := h.keyCompareForGroup(groups); keyCompare != nil {
+			return keyCompare
+		}
+	}
+	return h.keyCompare
+}
+
+// putGroup puts the subtree of the group into the map, overwriting any older value with the same key.
+func (h *OverwriteHandler) putGroup(state *handleState, uniq attrStore, groups []string, key string, uniqGroup attrStore) {
+	if h.typeDisambiguate {
+		key = disambiguateGroupKey(uniq, key)
+	}
+	h.set(state, uniq, groups, key, uniqGroup)
+}
+
+// set puts the value into the map, overwriting any older value with the same key.
+func (h *OverwriteHandler) set(state *handleState, uniq attrStore, groups []string, key string, v any) {
+	if c := state.colliderFor(groups, h.rootCollider, h.inlineCollider); c != nil {
+		c.put(state, uniq, key, v)
+		return
+	}
+	if h.traceCollisions || h.debugDuplicates != nil {
+		if existing, exists := uniq.Get(key); exists {
+			state.collide(groups, key, v, existing)
+		}
+	}
+	if h.warnGroupLoss {
+		if existing, exists := uniq.Get(key); exists {
+			addGroupLoss(state, uniq, key, existing)
+		}
+	}
+	uniq.Set(key, v)
+	state.order.touch(uniq, key)
+}
 
-		// Ignore empty groups, otherwise put subtree into the map
-		if uniqGroup.Len() > 0 {
-			uniq.Set(a.Key, uniqGroup)
+// OverwrittenChildrenSuffix is appended to the key of a group that was
+// overwritten, for the attribute with the number of children that were lost,
+// when WarnOnGroupLoss is enabled.
+const OverwrittenChildrenSuffix = "#overwritten_children"
+
+// addGroupLoss adds to the count of children lost under the key with
+// OverwrittenChildrenSuffix, if the existing value is a non-empty group.
+func addGroupLoss(state *handleState, uniq attrStore, key string, existing any) {
+	group, ok := existing.(attrStore)
+	if !ok || group.Len() == 0 {
+		return
+	}
+	warnKey := key + OverwrittenChildrenSuffix
+	uniq.Put(warnKey, func(oldValue any, exists bool) (any, bool) {
+		lost := int64(group.Len())
+		if old, ok := oldValue.(slog.Attr); exists && ok && old.Value.Kind() == slog.KindInt64 {
+			lost += old.Value.Int64()
 		}
+		return slog.Int64(warnKey, lost), true
+	})
+	state.order.touch(uniq, warnKey)
+}
+
+// createRecordTree resolves the groups and with-attributes, followed by the final attributes, into a new tree.
+// If caching, the tree of the groups and with-attributes is only created once, and then cloned for each record.
+func (h *OverwriteHandler) createRecordTree(finalAttrs []slog.Attr) (*handleState, attrStore) {
+	if h.cache == nil {
+		goas := collectGroupOrAttrs(h.goa, &groupOrAttrs{attrs: finalAttrs})
+		state, uniq := newAttrTree(h.newStore, h.orderMode, h.keyCompareFor(nil), h.keyOrder)
+		h.createAttrTree(state, uniq, goas, nil)
+		return state, uniq
+	}
+
+	template := h.cache.load(func() *goaTemplate {
+		state, uniq := newAttrTree(h.newStore, h.orderMode, h.keyCompareFor(nil), h.keyOrder)
+		state.deferGroups = true
+		h.createAttrTree(state, uniq, collectGroupOrAttrs(h.goa), nil)
+		return &goaTemplate{state: state, uniq: uniq}
+	})
+
+	state, uniq := template.clone(h.keyCompareFor)
+	if !state.dropped {
+		inner, groups := state.innermost(uniq)
+		h.resolveValues(state, inner, finalAttrs, groups)
 	}
+	state.closeGroups(h.putGroup)
+	return state, uniq
 }
@@ -1,9 +1,12 @@
 package slogdedup
 
 import (
+	"context"
+	"errors"
 	"log/slog"
 	"strings"
 	"testing"
+	"time"
 )
 
 /*
@@ -122,3 +125,273 @@ func TestOverwriteHandler_CaseInsensitiveDropBuiltinConflicts(t *testing.T) {
 
 	checkRecordForDuplicates(t, tester.Record)
 }
+
+/*
+	{
+	  "time": "2023-09-29T13:00:59Z",
+	  "level": "INFO",
+	  "msg": "allowlist",
+	  "arg1": "val1",
+	  "group1": {
+	    "arg2": "val2",
+	    "sub": {
+	      "id": "subid"
+	    }
+	  },
+	  "group2": {
+	    "arg1": "val1",
+	    "arg2": "val2"
+	  },
+	  "id": "rootid"
+	}
+*/
+func TestOverwriteHandler_AllowKeys(t *testing.T) {
+	t.Parallel()
+
+	tester := &testHandler{}
+	h := NewOverwriteHandler(tester, &OverwriteHandlerOptions{
+		AllowKeys: []string{"arg1", "group1.arg2", "group2", "**.id"},
+	})
+
+	log := slog.New(h).With("arg1", "val1", "arg2", "val2", "id", "rootid", slog.Group("group2", "arg1", "val1", "arg2", "val2"), slog.Group("group3", "arg1", "val1"))
+
+	for _, testCase := range []struct {
+		group    string
+		expected string
+	}{
+		{
+			group:    "group1",
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"allowlist","arg1":"val1","group1":{"arg2":"val2","sub":{"id":"subid"}},"group2":{"arg1":"val1","arg2":"val2"},"id":"rootid"}`,
+		},
+		{
+			group:    "group4",
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"allowlist","arg1":"val1","group2":{"arg1":"val1","arg2":"val2"},"group4":{"sub":{"id":"subid"}},"id":"rootid"}`,
+		},
+	} {
+		log.WithGroup(testCase.group).Info("allowlist", "arg1", "val1", "arg2", "val2", slog.Group("sub", "id", "subid", "other", "val3"))
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != testCase.expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.group, testCase.expected, jStr)
+		}
+
+		checkRecordForDuplicates(t, tester.Record)
+	}
+}
+
+func TestOverwriteHandler_DenyKeys(t *testing.T) {
+	t.Parallel()
+
+	/*
+		{
+			"time": "2023-09-29T13:00:59Z",
+			"level": "INFO",
+			"msg": "denylist",
+			"arg1": "val1",
+			"group1": {
+				"arg1": "val1",
+				"user": {
+					"name": "bob"
+				}
+			}
+		}
+	*/
+
+	tester := &testHandler{}
+	h := NewOverwriteHandler(tester, &OverwriteHandlerOptions{
+		DenyKeys: []string{"**.password", "group1.secret*"},
+	})
+
+	log := slog.New(h).With("arg1", "val1", "password", "hunter2")
+	log.WithGroup("group1").Info("denylist", "arg1", "val1", "secretKey", "abc", slog.Group("user", "name", "bob", "password", "hunter3"))
+
+	jBytes, err := tester.MarshalJSON()
+	if err != nil {
+		t.Errorf("Unable to marshal json: %v", err)
+	}
+	jStr := strings.TrimSpace(string(jBytes))
+
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"denylist","arg1":"val1","group1":{"arg1":"val1","user":{"name":"bob"}}}`
+	if jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+
+	checkRecordForDuplicates(t, tester.Record)
+}
+
+func TestOverwriteHandler_DenyKeysAnyDepth(t *testing.T) {
+	t.Parallel()
+
+	tester := &testHandler{}
+	h := NewOverwriteHandler(tester, &OverwriteHandlerOptions{
+		// A bare key matches at any depth, and escaped dots are part of the key
+		DenyKeys: []string{"password", `http\.method`, `group1.http\.path`},
+	})
+
+	log := slog.New(h).With("password", "hunter2", "http.method", "GET", "http.path", "/")
+	log.WithGroup("group1").Info("denylist", "http.method", "POST", "http.path", "/users", slog.Group("http", "method", "PUT"), slog.Group("user", "name", "bob", "password", "hunter3"))
+
+	jBytes, err := tester.MarshalJSON()
+	if err != nil {
+		t.Errorf("Unable to marshal json: %v", err)
+	}
+	jStr := strings.TrimSpace(string(jBytes))
+
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"denylist","group1":{"http":{"method":"PUT"},"user":{"name":"bob"}},"http.path":"/"}`
+	if jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+
+	checkRecordForDuplicates(t, tester.Record)
+}
+
+func TestOverwriteHandler_WarnOnGroupLoss(t *testing.T) {
+	t.Parallel()
+
+	/*
+		{
+			"time": "2023-09-29T13:00:59Z",
+			"level": "INFO",
+			"msg": "group loss",
+			"group1": {
+				"arg3": "val3",
+				"group2": "scalar",
+				"group2#overwritten_children": 1
+			},
+			"group1#overwritten_children": 2
+		}
+	*/
+
+	tester := &testHandler{}
+	h := NewOverwriteHandler(tester, &OverwriteHandlerOptions{WarnOnGroupLoss: true})
+
+	log := slog.New(h).With(slog.Group("group1", "arg1", "val1", "arg2", "val2"))
+	log.Info("group loss", slog.Group("group1", "arg3", "val3", slog.Group("group2", "arg4", "val4"), "group2", "scalar"))
+
+	jBytes, err := tester.MarshalJSON()
+	if err != nil {
+		t.Errorf("Unable to marshal json: %v", err)
+	}
+	jStr := strings.TrimSpace(string(jBytes))
+
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"group loss","group1":{"arg3":"val3","group2":"scalar","group2#overwritten_children":1},"group1#overwritten_children":2}`
+	if jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+
+	checkRecordForDuplicates(t, tester.Record)
+}
+
+func TestOverwriteHandler_StrictBuiltins(t *testing.T) {
+	t.Parallel()
+
+	tester := &testHandler{}
+	h := NewOverwriteHandler(tester, &OverwriteHandlerOptions{StrictBuiltins: true})
+
+	// Builtin keys inside of groups are fine
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "allowed", 0)
+	r.AddAttrs(slog.String("level", "user-level"))
+	err := h.WithGroup("group1").Handle(context.Background(), r)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	r = slog.NewRecord(time.Time{}, slog.LevelInfo, "strict", 0)
+	r.AddAttrs(slog.String("arg1", "val1"), slog.String("level", "user-level"))
+	err = h.WithAttrs([]slog.Attr{slog.String("arg2", "val2")}).Handle(context.Background(), r)
+	if !errors.Is(err, ErrBuiltinKeyConflict) {
+		t.Errorf("Expected ErrBuiltinKeyConflict, got: %v", err)
+	}
+	if err != nil && !strings.Contains(err.Error(), `"level"`) {
+		t.Errorf("Expected error to name the key, got: %v", err)
+	}
+	if tester.Record.Message != "allowed" {
+		t.Errorf("Expected the conflicting record not to be logged, got: %s", tester.Record.Message)
+	}
+}
+
+func TestOverwriteHandler_LevelRouter(t *testing.T) {
+	t.Parallel()
+
+	tester := &testHandler{}
+	errTester := &testHandler{}
+	h := NewOverwriteHandler(tester, &OverwriteHandlerOptions{LevelRouter: func(level slog.Level) slog.Handler {
+		if level >= slog.LevelError {
+			return errTester
+		}
+		return nil
+	}})
+
+	log := slog.New(h).With("arg1", "val1")
+	log.Info("info message", "arg1", "val2")
+	log.Error("error message", "arg1", "val3")
+
+	for _, check := range []struct {
+		handler  *testHandler
+		expected string
+	}{
+		{handler: tester, expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"info message","arg1":"val2"}`},
+		{handler: errTester, expected: `{"time":"2023-09-29T13:00:59Z","level":"ERROR","msg":"error message","arg1":"val3"}`},
+	} {
+		jBytes, err := check.handler.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != check.expected {
+			t.Errorf("Expected:\n%s\nGot:\n%s", check.expected, jStr)
+		}
+
+		checkRecordForDuplicates(t, check.handler.Record)
+	}
+}
+
+type denyKeysContextKey struct{}
+
+func TestOverwriteHandler_DenyKeysFromContext(t *testing.T) {
+	t.Parallel()
+
+	tester := &testHandler{}
+	h := NewOverwriteHandler(tester, &OverwriteHandlerOptions{
+		DenyKeysFromContext: func(ctx context.Context) []string {
+			deny, _ := ctx.Value(denyKeysContextKey{}).([]string)
+			return deny
+		},
+	})
+	log := slog.New(h).With("email", "a@b.com", slog.Group("user", "ssn", "123")).WithGroup("group1").With("arg1", "with1")
+	ctx := context.WithValue(context.Background(), denyKeysContextKey{}, []string{"email", "user.ssn", "**.password"})
+
+	log.InfoContext(ctx, "main message", "password", "secret", "arg2", "main2")
+
+	jBytes, err := tester.MarshalJSON()
+	if err != nil {
+		t.Fatalf("Unable to marshal json: %v", err)
+	}
+	jStr := strings.TrimSpace(string(jBytes))
+
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","group1":{"arg1":"with1","arg2":"main2"}}`
+	if jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+
+	// Without the denylist in the context, nothing is dropped
+	log.InfoContext(context.Background(), "main message", "password", "secret", "arg2", "main2")
+
+	jBytes, err = tester.MarshalJSON()
+	if err != nil {
+		t.Fatalf("Unable to marshal json: %v", err)
+	}
+	jStr = strings.TrimSpace(string(jBytes))
+
+	expected = `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","email":"a@b.com","group1":{"arg1":"with1","arg2":"main2","password":"secret"},"user":{"ssn":"123"}}`
+	if jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+}
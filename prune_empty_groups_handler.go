@@ -0,0 +1,103 @@
+package slogdedup
+
+import (
+	"context"
+	"log/slog"
+)
+
+// PruneEmptyGroupsHandler is a slog.Handler middleware that recursively removes
+// any groups that are empty, or that only contain empty groups, from the
+// record's attributes and from any attributes added with WithAttrs, before
+// passing them off to the next handler.
+// The deduplicating handlers in this package never output empty groups, but a
+// group can still end up empty if a middleware after them, such as one that
+// redacts secrets, drops all of its attributes. Put this handler after it.
+// Groups opened with WithGroup are passed to the next handler unchanged.
+type PruneEmptyGroupsHandler struct {
+	next slog.Handler
+}
+
+var _ slog.Handler = &PruneEmptyGroupsHandler{} // Assert conformance with interface
+
+// NewPruneEmptyGroupsMiddleware creates a PruneEmptyGroupsHandler slog.Handler
+// middleware that conforms to [github.com/samber/slog-multi.Middleware] interface.
+// It can be used with slogmulti methods such as Pipe to easily setup a pipeline of slog handlers:
+//
+//	slog.SetDefault(slog.New(slogmulti.
+//		Pipe(slogdedup.NewOverwriteMiddleware(&slogdedup.OverwriteHandlerOptions{})).
+//		Pipe(redactionMiddleware).
+//		Pipe(slogdedup.NewPruneEmptyGroupsMiddleware()).
+//		Handler(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{})),
+//	))
+func NewPruneEmptyGroupsMiddleware() func(slog.Handler) slog.Handler {
+	return func(next slog.Handler) slog.Handler {
+		return NewPruneEmptyGroupsHandler(
+			next,
+		)
+	}
+}
+
+// NewPruneEmptyGroupsHandler creates a PruneEmptyGroupsHandler slog.Handler
+// middleware that recursively removes empty groups, before passing the record
+// off to the next handler.
+// If next is nil, all records are discarded instead of panicking.
+func NewPruneEmptyGroupsHandler(next slog.Handler) *PruneEmptyGroupsHandler {
+	return &PruneEmptyGroupsHandler{
+		next: nextOrDiscard(next),
+	}
+}
+
+// Enabled reports whether the next handler handles records at the given level.
+// The handler ignores records whose level is lower.
+func (h *PruneEmptyGroupsHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle removes any empty groups, then passes the new record to the next handler.
+func (h *PruneEmptyGroupsHandler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := make([]slog.Attr, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+
+	newR := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	newR.AddAttrs(pruneEmptyGroups(attrs)...)
+	return h.next.Handle(ctx, newR)
+}
+
+// WithGroup returns a new PruneEmptyGroupsHandler whose next handler has the group.
+func (h *PruneEmptyGroupsHandler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	h2.next = h2.next.WithGroup(name)
+	return &h2
+}
+
+// WithAttrs returns a new PruneEmptyGroupsHandler whose next handler has the
+// attributes, with any empty groups removed.
+func (h *PruneEmptyGroupsHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.next = h2.next.WithAttrs(pruneEmptyGroups(attrs))
+	return &h2
+}
+
+// pruneEmptyGroups returns the attributes with any empty attributes and empty
+// groups removed, recursively.
+func pruneEmptyGroups(attrs []slog.Attr) []slog.Attr {
+	pruned := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		a.Value = a.Value.Resolve()
+		if a.Equal(slog.Attr{}) {
+			continue
+		}
+		if a.Value.Kind() == slog.KindGroup {
+			group := pruneEmptyGroups(a.Value.Group())
+			if len(group) == 0 {
+				continue
+			}
+			a.Value = slog.GroupValue(group...)
+		}
+		pruned = append(pruned, a)
+	}
+	return pruned
+}
@@ -0,0 +1,75 @@
+package slogdedup
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// redactHandler is a middleware that drops any password attributes, leaving
+// behind any groups that become empty
+type redactHandler struct {
+	next slog.Handler
+}
+
+func (h *redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	var redact func(attrs []slog.Attr) []slog.Attr
+	redact = func(attrs []slog.Attr) []slog.Attr {
+		var kept []slog.Attr
+		for _, a := range attrs {
+			if a.Key == "password" {
+				continue
+			}
+			if a.Value.Kind() == slog.KindGroup {
+				a.Value = slog.GroupValue(redact(a.Value.Group())...)
+			}
+			kept = append(kept, a)
+		}
+		return kept
+	}
+
+	var attrs []slog.Attr
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	newR := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	newR.AddAttrs(redact(attrs)...)
+	return h.next.Handle(ctx, newR)
+}
+
+func (h *redactHandler) WithGroup(string) slog.Handler {
+	panic("shouldn't be called")
+}
+
+func (h *redactHandler) WithAttrs([]slog.Attr) slog.Handler {
+	panic("shouldn't be called")
+}
+
+func TestPruneEmptyGroupsHandler(t *testing.T) {
+	t.Parallel()
+
+	tester := &testHandler{}
+	h := NewOverwriteHandler(&redactHandler{next: NewPruneEmptyGroupsHandler(tester)}, nil)
+
+	log := slog.New(h).With("arg1", "val1", slog.Group("user", "name", "bob", slog.Group("auth", "password", "hunter2")))
+	log.Info("prune", slog.Group("db", slog.Group("creds", "password", "hunter3")), slog.Group("", "arg2", "val2"))
+
+	jBytes, err := tester.MarshalJSON()
+	if err != nil {
+		t.Errorf("Unable to marshal json: %v", err)
+	}
+	jStr := strings.TrimSpace(string(jBytes))
+
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"prune","arg1":"val1","arg2":"val2","user":{"name":"bob"}}`
+	if jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+
+	checkRecordForDuplicates(t, tester.Record)
+}
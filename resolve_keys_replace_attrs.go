@@ -2,30 +2,70 @@ package slogdedup
 
 import (
 	"log/slog"
+	"os"
 	"strconv"
+	"strings"
 )
 
 // JoinResolveKey can be used to join together many slogdedup middlewares
 // xHandlerOptions.ResolveKey functions into a single one that applies all the
 // rules in order.
 func JoinResolveKey(resolveKeyFunctions ...func(groups []string, key string, index int) (string, bool)) func(groups []string, key string, index int) (string, bool) {
+	results := make([]func(groups []string, key string, index int) ResolveKeyResult, len(resolveKeyFunctions))
+	for i, f := range resolveKeyFunctions {
+		results[i] = ResolveKeyResultOf(f)
+	}
+	return JoinResolveKeyResults(results...)
+}
+
+// ResolveKeyResult is the result of resolving a key, for functions joined
+// together with JoinResolveKeyResults.
+type ResolveKeyResult struct {
+	// Key is the new key to use.
+	Key string
+
+	// Keep is true to keep the attribute, or false to drop it.
+	Keep bool
+
+	// Final, if true, stops any further resolving of the key: the remaining
+	// joined functions are not called, and the key is not incremented. The key
+	// is used exactly as it is, so for the IncrementHandler, or a
+	// DedupIncrement mode, a different key should be returned for each
+	// index. If the same key is returned again, the duplicate is still kept,
+	// under that key incremented the default way, such as "source#01".
+	Final bool
+}
+
+// ResolveKeyResultOf wraps a xHandlerOptions.ResolveKey function so that it
+// returns a ResolveKeyResult, which is never Final, so that it can be joined
+// with other functions using JoinResolveKeyResults.
+func ResolveKeyResultOf(resolveKey func(groups []string, key string, index int) (string, bool)) func(groups []string, key string, index int) ResolveKeyResult {
+	return func(groups []string, key string, index int) ResolveKeyResult {
+		key, keep := resolveKey(groups, key, index)
+		return ResolveKeyResult{Key: key, Keep: keep}
+	}
+}
+
+// JoinResolveKeyResults is like JoinResolveKey, but joins functions that
+// return a ResolveKeyResult, into a single xHandlerOptions.ResolveKey function
+// that applies all the rules in order, until one drops the key or is Final.
+func JoinResolveKeyResults(resolveKeyFunctions ...func(groups []string, key string, index int) ResolveKeyResult) func(groups []string, key string, index int) (string, bool) {
 	if len(resolveKeyFunctions) == 0 {
 		return nil
 	}
 	return func(groups []string, originalKey string, index int) (string, bool) {
-		var ok bool
-		key := originalKey
+		result := ResolveKeyResult{Key: originalKey, Keep: true}
 		for _, f := range resolveKeyFunctions {
-			if key, ok = f(groups, key, index); !ok {
+			if result = f(groups, result.Key, index); !result.Keep || result.Final {
 				break
 			}
 		}
 		// Only increment once, and only if the key was not changed.
 		// This would happen if we have multiple duplicate keys in row.
-		if key != originalKey {
-			return key, ok
+		if result.Final || result.Key != originalKey {
+			return result.Key, result.Keep
 		}
-		return incrementKeyName(key, index), ok
+		return incrementKeyName(result.Key, index), result.Keep
 	}
 }
 
@@ -45,6 +85,56 @@ func JoinReplaceAttr(replaceAttrFunctions ...func(groups []string, a slog.Attr)
 	}
 }
 
+// ReplaceAttrSortedBuiltins is a slog.HandlerOptions.ReplaceAttr function for
+// the handler after one of the deduplicating handlers with SortBuiltins or
+// BuiltinsLast set.
+// It drops the record's own builtin level, and its empty builtin message,
+// which are replaced by the sorted attributes that the deduplicating handler
+// added. It can be joined with other ReplaceAttr functions using JoinReplaceAttr.
+func ReplaceAttrSortedBuiltins(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return a
+	}
+	if a.Key == slog.LevelKey && a.Value.Kind() == slog.KindAny {
+		if _, ok := a.Value.Any().(slog.Level); ok {
+			return slog.Attr{}
+		}
+	}
+	if a.Key == slog.MessageKey && a.Value.Kind() == slog.KindString && a.Value.String() == "" {
+		return slog.Attr{}
+	}
+	return a
+}
+
+// RootOnlyReplaceAttr wraps a slog.HandlerOptions.ReplaceAttr function so that
+// it is only called on root level (not in a group) attributes, which includes
+// the builtins. Attributes inside of groups are returned unchanged. This is
+// the same guard used by the sink ReplaceAttr functions in this package, such
+// as ReplaceAttrGraylog, and is useful when joining them with custom logic
+// meant only for the builtins.
+func RootOnlyReplaceAttr(replaceAttr func(groups []string, a slog.Attr) slog.Attr) func(groups []string, a slog.Attr) slog.Attr {
+	return func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) > 0 {
+			return a
+		}
+		return replaceAttr(groups, a)
+	}
+}
+
+// GroupOnlyResolveKey wraps a xHandlerOptions.ResolveKey function so that it is
+// only called on attributes and groups inside of groups. Root level keys are
+// resolved with IncrementIfBuiltinKeyConflict, the default, instead. This is
+// the opposite of the sink ResolveKey functions in this package, such as
+// ResolveKeyGraylog, and is useful for targeting nested keys specifically.
+func GroupOnlyResolveKey(resolveKey func(groups []string, key string, index int) (string, bool)) func(groups []string, key string, index int) (string, bool) {
+	return func(groups []string, key string, index int) (string, bool) {
+		if len(groups) == 0 {
+			return IncrementIfBuiltinKeyConflict(groups, key, index)
+		}
+		return resolveKey(groups, key, index)
+	}
+}
+
 // ResolveReplaceOptions is a struct of optional options that change the
 // behavior of the ResolveKey and ReplaceAttr functions.
 type ResolveReplaceOptions struct {
@@ -53,11 +143,43 @@ type ResolveReplaceOptions struct {
 	// "message" or "summary" key for that sink (usually causing the msg to show
 	// up as the log line summary when skimming.
 	OverwriteSummary bool
+
+	// GELFFields, if true and applicable to the log sink (Graylog), adds the
+	// constant fields required by the Graylog Extended Log Format (GELF):
+	// "version" set to "1.1", and "host" set to GELFHost. The ReplaceAttr
+	// function adds them next to the builtin level, and the ResolveKey
+	// function increments any attributes with the same keys, so that each
+	// field appears once.
+	GELFFields bool
+
+	// GELFHost is the value of the "host" field added by GELFFields.
+	// Defaults to the result of os.Hostname().
+	GELFHost string
+
+	// SyslogLevel, if true and applicable to the log sink (Graylog), replaces
+	// the builtin level with its numeric syslog severity, from 0 (emergency)
+	// to 7 (debug), which is what GELF expects, instead of its string name.
+	// For example, WARN becomes 4 and ERROR becomes 3.
+	SyslogLevel bool
+
+	// LevelText, if true and applicable to the log sink (Graylog), adds a
+	// "level_text" field with the name of the level, such as "WARN", next to
+	// the builtin level. Combined with SyslogLevel, this outputs both the
+	// numeric severity and its text, for pipelines that want both.
+	LevelText bool
+
+	// SourceFields, if true and applicable to the log sink (Graylog), replaces
+	// the builtin source object with separate "_file" and "_line" fields, as
+	// Graylog expects additional fields to be prefixed with an underscore.
+	SourceFields bool
 }
 
 // ResolveKeyGraylog returns a ResolveKey function works for Graylog.
 // If OverwriteSummary is true, the slog.Record "msg" key will be changed to "message",
 // causing it to show up as the main log line when skimming.
+// If GELFFields is true, the constant GELF "version" and "host" fields are added.
+// If SourceFields is true, the source is output as "_file" and "_line" fields.
+// If LevelText is true, the level's name is output as a "level_text" field.
 func ResolveKeyGraylog(options *ResolveReplaceOptions) func(groups []string, key string, index int) (string, bool) {
 	return resolveKeys(sinkGraylog(options))
 }
@@ -65,6 +187,9 @@ func ResolveKeyGraylog(options *ResolveReplaceOptions) func(groups []string, key
 // ReplaceAttrGraylog returns a ReplaceAttr function works for Graylog.
 // If OverwriteSummary is true, the slog.Record "msg" key will be changed to "message",
 // causing it to show up as the main log line when skimming.
+// If GELFFields is true, the constant GELF "version" and "host" fields are added.
+// If SourceFields is true, the source is output as "_file" and "_line" fields.
+// If LevelText is true, the level's name is output as a "level_text" field.
 func ReplaceAttrGraylog(options *ResolveReplaceOptions) func(groups []string, a slog.Attr) slog.Attr {
 	return replaceAttr(sinkGraylog(options))
 }
@@ -78,23 +203,86 @@ func sinkGraylog(options *ResolveReplaceOptions) sink {
 		finalMsgKey = "message"
 	}
 
+	builtins := []string{slog.TimeKey, slog.LevelKey, finalMsgKey, "sourceLoc", "message"}
+	var constants []slog.Attr
+	if options != nil && options.GELFFields {
+		host := options.GELFHost
+		if host == "" {
+			host, _ = os.Hostname()
+		}
+		constants = []slog.Attr{slog.String("version", "1.1"), slog.String("host", host)}
+		builtins = append(builtins, "version", "host")
+	}
+
+	var sourceAttrs func(src *slog.Source) []slog.Attr
+	if options != nil && options.SourceFields {
+		sourceAttrs = func(src *slog.Source) []slog.Attr {
+			return []slog.Attr{slog.String("_file", src.File), slog.Int("_line", src.Line)}
+		}
+		builtins = append(builtins, "_file", "_line")
+	}
+
+	replacers := map[string]attrReplacer{
+		// "timestamp" is the time of the record. Defaults to the time the log was received by grayload.
+		// If using a json extractor or rule, Graylog needs to have it set to a time object, not a string.
+		// So best to let your timestamp come in under a different key, then set it specifically with a pipeline rule.
+		"timestamp": {key: "timestampRenamed"},
+
+		slog.MessageKey: {key: finalMsgKey},
+
+		// "source" is the IP address or similar of where the logs came from.
+		// Let Graylog keep its enchriched field, and rename our source location.
+		slog.SourceKey: {key: "sourceLoc"},
+	}
+
+	if options != nil && options.SyslogLevel {
+		// "level" is the syslog severity in GELF, as a number from 0 to 7
+		replacers[slog.LevelKey] = attrReplacer{key: slog.LevelKey, valuer: syslogSeverity}
+	}
+	if options != nil && options.LevelText {
+		level := replacers[slog.LevelKey]
+		level.key = slog.LevelKey
+		level.extras = func(v slog.Value) []slog.Attr {
+			return []slog.Attr{slog.String("level_text", v.String())}
+		}
+		replacers[slog.LevelKey] = level
+		builtins = append(builtins, "level_text")
+	}
+
 	return sink{
 		// builtins are going to be the FINAL key namess for the 4 builtin fields on slog.Record.
 		// We will also add in any fields we want incremented, if they would be assigned a special value by graylog.
 		// In this case, we want to increment "message" regardless of whether it will be overwritten by the "msg" builtin or not.
-		builtins: []string{slog.TimeKey, slog.LevelKey, finalMsgKey, "sourceLoc", "message"},
-		replacers: map[string]attrReplacer{
-			// "timestamp" is the time of the record. Defaults to the time the log was received by grayload.
-			// If using a json extractor or rule, Graylog needs to have it set to a time object, not a string.
-			// So best to let your timestamp come in under a different key, then set it specifically with a pipeline rule.
-			"timestamp": {key: "timestampRenamed"},
-
-			slog.MessageKey: {key: finalMsgKey},
+		builtins:    builtins,
+		replacers:   replacers,
+		constants:   constants,
+		sourceAttrs: sourceAttrs,
+	}
+}
 
-			// "source" is the IP address or similar of where the logs came from.
-			// Let Graylog keep its enchriched field, and rename our source location.
-			slog.SourceKey: {key: "sourceLoc"},
-		},
+// syslogSeverity replaces a slog.Level value with its numeric syslog severity:
+// https://en.wikipedia.org/wiki/Syslog#Severity_level
+func syslogSeverity(v slog.Value) slog.Value {
+	switch lvl := v.Any().(type) {
+	case slog.Level:
+		if lvl <= slog.LevelDebug {
+			return slog.IntValue(7) // -4
+		} else if lvl <= slog.LevelInfo {
+			return slog.IntValue(6) // 0
+		} else if lvl <= slog.LevelInfo+2 {
+			return slog.IntValue(5) // 2
+		} else if lvl <= slog.LevelWarn {
+			return slog.IntValue(4) // 4
+		} else if lvl <= slog.LevelError {
+			return slog.IntValue(3) // 8
+		} else if lvl <= slog.LevelError+4 {
+			return slog.IntValue(2) // 12
+		} else if lvl <= slog.LevelError+8 {
+			return slog.IntValue(1) // 16
+		}
+		return slog.IntValue(0)
+	default:
+		return v
 	}
 }
 
@@ -172,11 +360,7 @@ func sinkStackdriver(options *ResolveReplaceOptions) sink {
 					if source == nil {
 						return v
 					}
-					return slog.AnyValue(struct {
-						Function string `json:"function"`
-						File     string `json:"file"`
-						Line     string `json:"line"` // slog.Source.Line is an int, GCP wants a string
-					}{
+					return slog.AnyValue(stackdriverSourceLocation{
 						Function: source.Function,
 						File:     source.File,
 						Line:     strconv.Itoa(source.Line),
@@ -189,6 +373,158 @@ func sinkStackdriver(options *ResolveReplaceOptions) sink {
 	}
 }
 
+// ResolveKeyECS returns a ResolveKey function works for Elastic Common Schema
+// (ECS), including Elasticsearch data streams.
+// Elasticsearch data streams require the "@timestamp" field on every document,
+// so consider also setting EnsureTime on the handler options, so that records
+// with a zero time still have a timestamp.
+func ResolveKeyECS(options *ResolveReplaceOptions) func(groups []string, key string, index int) (string, bool) {
+	return resolveKeys(sinkECS(options))
+}
+
+// ReplaceAttrECS returns a ReplaceAttr function works for Elastic Common Schema
+// (ECS), including Elasticsearch data streams.
+// Elasticsearch data streams require the "@timestamp" field on every document,
+// so consider also setting EnsureTime on the handler options, so that records
+// with a zero time still have a timestamp.
+func ReplaceAttrECS(options *ResolveReplaceOptions) func(groups []string, a slog.Attr) slog.Attr {
+	return replaceAttr(sinkECS(options))
+}
+
+// Elastic Common Schema (ECS)
+// https://www.elastic.co/guide/en/ecs/current/ecs-base.html
+func sinkECS(_ *ResolveReplaceOptions) sink {
+	return sink{
+		// builtins are going to be the FINAL key namess for the 4 builtin fields on slog.Record.
+		// ECS always uses "message" for the log line summary, so OverwriteSummary is not needed.
+		builtins: []string{"@timestamp", "log.level", "message", "log.origin"},
+		replacers: map[string]attrReplacer{
+			// "@timestamp" is required on all documents in an Elasticsearch data stream.
+			slog.TimeKey: {key: "@timestamp"},
+
+			// https://www.elastic.co/guide/en/ecs/current/ecs-log.html#field-log-level
+			slog.LevelKey: {key: "log.level", valuer: func(v slog.Value) slog.Value {
+				switch lvl := v.Any().(type) {
+				case slog.Level:
+					return slog.StringValue(strings.ToLower(lvl.String()))
+				default:
+					return v
+				}
+			}},
+
+			slog.MessageKey: {key: "message"},
+
+			// https://www.elastic.co/guide/en/ecs/current/ecs-log.html#field-log-origin-file-line
+			slog.SourceKey: {key: "log.origin", valuer: func(v slog.Value) slog.Value {
+				switch source := v.Any().(type) {
+				case *slog.Source:
+					if source == nil {
+						return v
+					}
+					return slog.GroupValue(
+						slog.Group("file", slog.String("name", source.File), slog.Int("line", source.Line)),
+						slog.String("function", source.Function),
+					)
+				default:
+					return v
+				}
+			}},
+		},
+	}
+}
+
+// stackdriverSourceLocation is the value of the source builtin for Stackdriver
+// https://cloud.google.com/logging/docs/reference/v2/rest/v2/LogEntry#LogEntrySourceLocation
+type stackdriverSourceLocation struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     string `json:"line"` // slog.Source.Line is an int, GCP wants a string
+}
+
+// ReplaceAttrSourceLineInt returns a ReplaceAttr function that converts a
+// source location whose line was turned into a string by
+// ReplaceAttrStackdriver back into a *slog.Source with an integer line.
+// This is for sinks that expect a numeric line, but share a chain of
+// ReplaceAttr functions with Stackdriver. When used with JoinReplaceAttr, it
+// must come after ReplaceAttrStackdriver:
+//
+//	JoinReplaceAttr(ReplaceAttrStackdriver(nil), ReplaceAttrSourceLineInt())
+func ReplaceAttrSourceLineInt() func(groups []string, a slog.Attr) slog.Attr {
+	return func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) > 0 {
+			return a
+		}
+		if loc, ok := a.Value.Any().(stackdriverSourceLocation); ok {
+			line, err := strconv.Atoi(loc.Line)
+			if err != nil {
+				return a
+			}
+			a.Value = slog.AnyValue(&slog.Source{
+				Function: loc.Function,
+				File:     loc.File,
+				Line:     line,
+			})
+		}
+		return a
+	}
+}
+
+// ReplaceAttrSourceRelative returns a ReplaceAttr function that rewrites the
+// file of the builtin source to be relative to the root directory, such as the
+// module root, so that build directories are not leaked into the logs. Files
+// outside of the root are left unchanged. It works on both the default source
+// and on the source after ReplaceAttrStackdriver, so it can come before or
+// after it when used with JoinReplaceAttr. With other sinks, such as ECS, it
+// must come before the sink's ReplaceAttr function:
+//
+//	JoinReplaceAttr(ReplaceAttrSourceRelative("/home/build/myapp"), ReplaceAttrECS(nil))
+func ReplaceAttrSourceRelative(root string) func(groups []string, a slog.Attr) slog.Attr {
+	prefix := strings.TrimSuffix(root, "/") + "/"
+	relative := func(file string) string {
+		if len(file) > len(prefix) && strings.HasPrefix(file, prefix) {
+			return file[len(prefix):]
+		}
+		return file
+	}
+
+	return func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) > 0 {
+			return a
+		}
+		switch source := a.Value.Any().(type) {
+		case *slog.Source:
+			if source == nil {
+				return a
+			}
+			relSource := *source // Copy, to avoid modifying the original
+			relSource.File = relative(source.File)
+			a.Value = slog.AnyValue(&relSource)
+		case stackdriverSourceLocation:
+			source.File = relative(source.File)
+			a.Value = slog.AnyValue(source)
+		}
+		return a
+	}
+}
+
+// ReplaceAttrLevelNumeric returns a ReplaceAttr function that replaces the
+// builtin level with its integer value on the slog numeric scale, such as -4
+// for DEBUG and 4 for WARN, instead of its string name. This is for sinks that
+// index the level best as a number. Other attributes whose values are levels
+// are left alone. When used with JoinReplaceAttr, it must come before any
+// ReplaceAttr function that converts the level into a string or renames it.
+func ReplaceAttrLevelNumeric() func(groups []string, a slog.Attr) slog.Attr {
+	return func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) > 0 || a.Key != slog.LevelKey {
+			return a
+		}
+		if level, ok := a.Value.Any().(slog.Level); ok {
+			a.Value = slog.IntValue(int(level))
+		}
+		return a
+	}
+}
+
 // sink represents the final destination of the logs.
 type sink struct {
 	// Only the keys that will be used for the builtins:
@@ -197,12 +533,25 @@ type sink struct {
 
 	// Replacement key name and optional function to replace the value.
 	replacers map[string]attrReplacer
+
+	// Constant attributes to add to every record, next to the builtin level.
+	// Their keys should also be in builtins.
+	constants []slog.Attr
+
+	// If not nil, returns the separate attributes to replace the builtin
+	// source with. Their keys should also be in builtins.
+	sourceAttrs func(src *slog.Source) []slog.Attr
 }
 
 // attrReplacer has the replacement key name, and optional function to replace the value
 type attrReplacer struct {
 	key    string
 	valuer func(v slog.Value) slog.Value
+
+	// Optional function returning more attributes to output after the replaced
+	// one, from its original value. Only used for the builtin level.
+	// Their keys should also be in builtins.
+	extras func(v slog.Value) []slog.Attr
 }
 
 // resolveKeys returns a closure that can be used with any slogdedup middlewares
@@ -260,16 +609,54 @@ func replaceAttr(dest sink) func(groups []string, a slog.Attr) slog.Attr {
 			return a
 		}
 
-		// This will still catch the builtin fields.
-		for oldKey, replacement := range dest.replacers {
-			if a.Key == oldKey {
-				a.Key = replacement.key
-				if replacement.valuer != nil {
-					a.Value = replacement.valuer(a.Value)
+		// Replace the builtin source by turning it into an inlined group of
+		// separate attributes, which the final handler then calls ReplaceAttr on.
+		if src, ok := a.Value.Any().(*slog.Source); ok && a.Key == slog.SourceKey && dest.sourceAttrs != nil {
+			return slog.Attr{Value: slog.GroupValue(dest.sourceAttrs(src)...)}
+		}
+
+		// Add the extras and constants by turning the builtin level into an
+		// inlined group containing the level, its extras, and the constants. The
+		// level is no longer a slog.Level afterwards, so this only happens once,
+		// even though the final handler then calls ReplaceAttr on each attribute
+		// in the group.
+		if _, ok := a.Value.Any().(slog.Level); ok && a.Key == slog.LevelKey {
+			extras := dest.extras(a)
+			if len(extras) > 0 || len(dest.constants) > 0 {
+				a = dest.replace(a)
+				if level, ok := a.Value.Any().(slog.Level); ok {
+					a.Value = slog.StringValue(level.String())
 				}
-				return a
+				attrs := append(append([]slog.Attr{a}, extras...), dest.constants...)
+				return slog.Attr{Value: slog.GroupValue(attrs...)}
 			}
 		}
-		return a
+		return dest.replace(a)
+	}
+}
+
+// extras returns the extra attributes to output after the attribute, if it has
+// a replacer with extras.
+func (dest sink) extras(a slog.Attr) []slog.Attr {
+	for oldKey, replacement := range dest.replacers {
+		if a.Key == oldKey && replacement.extras != nil {
+			return replacement.extras(a.Value)
+		}
+	}
+	return nil
+}
+
+// replace replaces the key and value of the attribute, if it has a replacer.
+func (dest sink) replace(a slog.Attr) slog.Attr {
+	// This will still catch the builtin fields.
+	for oldKey, replacement := range dest.replacers {
+		if a.Key == oldKey {
+			a.Key = replacement.key
+			if replacement.valuer != nil {
+				a.Value = replacement.valuer(a.Value)
+			}
+			return a
+		}
 	}
+	return a
 }
@@ -2,9 +2,16 @@ package slogdedup
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"log/slog"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestResolveKeyReplaceAttr(t *testing.T) {
@@ -121,3 +128,474 @@ func TestResolveKeyReplaceAttr(t *testing.T) {
 		checkRecordForDuplicates(t, tester.Record)
 	}
 }
+
+func TestReplaceAttrSourceLineInt(t *testing.T) {
+	t.Parallel()
+
+	tester := &testHandler{}
+	log := slog.New(NewOverwriteHandler(tester, nil))
+	log.Info("main message")
+
+	replacers := JoinReplaceAttr(
+		func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.SourceKey {
+				src := a.Value.Any().(*slog.Source)
+				src.File = "github.com/veqryn/slog-dedup/helpers_test.go"
+				src.Function = "github.com/veqryn/slog-dedup.logComplex"
+				src.Line = 85
+			}
+			return a
+		},
+		ReplaceAttrStackdriver(nil),
+		ReplaceAttrSourceLineInt(),
+	)
+
+	buf := &bytes.Buffer{}
+	err := tester.MarshalWith(slog.NewJSONHandler(buf, &slog.HandlerOptions{AddSource: true, ReplaceAttr: replacers}))
+	if err != nil {
+		t.Errorf("Unable to marshal json: %v", err)
+	}
+	jStr := strings.TrimSpace(buf.String())
+
+	expected := `{"time":"2023-09-29T13:00:59Z","severity":"INFO","logging.googleapis.com/sourceLocation":{"function":"github.com/veqryn/slog-dedup.logComplex","file":"github.com/veqryn/slog-dedup/helpers_test.go","line":85},"msg":"main message"}`
+	if jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+}
+
+func TestReplaceAttrSourceRelative(t *testing.T) {
+	t.Parallel()
+
+	tester := &testHandler{}
+	log := slog.New(NewOverwriteHandler(tester, nil))
+	log.Info("main message")
+
+	setSource := func(file string) func(groups []string, a slog.Attr) slog.Attr {
+		return func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.SourceKey {
+				src := a.Value.Any().(*slog.Source)
+				src.File = file
+				src.Function = "github.com/veqryn/slog-dedup.logComplex"
+				src.Line = 85
+			}
+			return a
+		}
+	}
+
+	tests := []struct {
+		name     string
+		replacer func(groups []string, a slog.Attr) slog.Attr
+		expected string
+	}{
+		{
+			name:     "default",
+			replacer: JoinReplaceAttr(setSource("/home/build/slog-dedup/helpers_test.go"), ReplaceAttrSourceRelative("/home/build/slog-dedup/")),
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","source":{"function":"github.com/veqryn/slog-dedup.logComplex","file":"helpers_test.go","line":85},"msg":"main message"}`,
+		},
+		{
+			name:     "outside root",
+			replacer: JoinReplaceAttr(setSource("/usr/local/go/src/log/slog/logger.go"), ReplaceAttrSourceRelative("/home/build/slog-dedup")),
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","source":{"function":"github.com/veqryn/slog-dedup.logComplex","file":"/usr/local/go/src/log/slog/logger.go","line":85},"msg":"main message"}`,
+		},
+		{
+			name:     "stackdriver",
+			replacer: JoinReplaceAttr(setSource("/home/build/slog-dedup/sub/file.go"), ReplaceAttrStackdriver(nil), ReplaceAttrSourceRelative("/home/build/slog-dedup")),
+			expected: `{"time":"2023-09-29T13:00:59Z","severity":"INFO","logging.googleapis.com/sourceLocation":{"function":"github.com/veqryn/slog-dedup.logComplex","file":"sub/file.go","line":"85"},"msg":"main message"}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		buf := &bytes.Buffer{}
+		err := tester.MarshalWith(slog.NewJSONHandler(buf, &slog.HandlerOptions{AddSource: true, ReplaceAttr: testCase.replacer}))
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+		}
+		jStr := strings.TrimSpace(buf.String())
+
+		if jStr != testCase.expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, testCase.expected, jStr)
+		}
+	}
+}
+
+func TestReplaceAttrLevelNumeric(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	log := slog.New(NewOverwriteHandler(
+		slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: ReplaceAttrLevelNumeric()}),
+		nil,
+	))
+	log.Debug("debug message")
+	log.Warn("warn message", slog.Group("group1", slog.Any("level", slog.LevelError)), slog.Any("min_level", slog.LevelWarn))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines; Got: %s", buf.String())
+	}
+	for i, expected := range []string{
+		`"level":-4,"msg":"debug message"}`,
+		`"level":4,"msg":"warn message","group1":{"level":"ERROR"},"min_level":"WARN"}`,
+	} {
+		if !strings.HasSuffix(lines[i], expected) {
+			t.Errorf("Expected suffix:\n%s\nGot:\n%s", expected, lines[i])
+		}
+	}
+}
+
+func TestRootOnlyReplaceAttr(t *testing.T) {
+	t.Parallel()
+
+	upper := func(groups []string, a slog.Attr) slog.Attr {
+		if a.Value.Kind() == slog.KindString {
+			a.Value = slog.StringValue(strings.ToUpper(a.Value.String()))
+		}
+		return a
+	}
+
+	buf := &bytes.Buffer{}
+	log := slog.New(NewOverwriteHandler(
+		slog.NewJSONHandler(buf, &slog.HandlerOptions{ReplaceAttr: RootOnlyReplaceAttr(upper)}),
+		nil,
+	))
+	log.Info("main message", "arg1", "val1", slog.Group("group1", "arg1", "val1", slog.Group("group2", "arg1", "val1")))
+
+	expected := `"msg":"MAIN MESSAGE","arg1":"VAL1","group1":{"arg1":"val1","group2":{"arg1":"val1"}}}`
+	if got := strings.TrimSpace(buf.String()); !strings.HasSuffix(got, expected) {
+		t.Errorf("Expected suffix:\n%s\nGot:\n%s", expected, got)
+	}
+}
+
+func TestGroupOnlyResolveKey(t *testing.T) {
+	t.Parallel()
+
+	upper := func(groups []string, key string, index int) (string, bool) {
+		return incrementKeyName(strings.ToUpper(key), index), true
+	}
+
+	tester := &testHandler{}
+	h := NewIncrementHandler(tester, &IncrementHandlerOptions{ResolveKey: GroupOnlyResolveKey(upper)})
+
+	log := slog.New(h).With("arg1", "val1", "msg", "with1")
+	log.Info("main message", "arg1", "val2", slog.Group("group1", "arg1", "val1", slog.Group("group2", "arg1", "val1", "arg1", "val2")))
+
+	jBytes, err := tester.MarshalJSON()
+	if err != nil {
+		t.Errorf("Unable to marshal json: %v", err)
+	}
+	jStr := strings.TrimSpace(string(jBytes))
+
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","arg1":"val1","arg1#01":"val2","group1":{"ARG1":"val1","GROUP2":{"ARG1":"val1","ARG1#01":"val2"}},"msg#01":"with1"}`
+	if jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+
+	checkRecordForDuplicates(t, tester.Record)
+}
+
+func TestResolveKeyReplaceAttrGELF(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	options := &ResolveReplaceOptions{OverwriteSummary: true, GELFFields: true, GELFHost: "server1"}
+	h := NewOverwriteHandler(
+		slog.NewJSONHandler(buf, &slog.HandlerOptions{ReplaceAttr: ReplaceAttrGraylog(options)}),
+		&OverwriteHandlerOptions{ResolveKey: ResolveKeyGraylog(options)},
+	)
+
+	log := slog.New(h).With("host", "user-host")
+	log.Warn("main message", "version", 2, slog.Group("group1", "host", "group-host"))
+
+	expected := `"level":"WARN","version":"1.1","host":"server1","message":"main message","group1":{"host":"group-host"},"host#01":"user-host","version#01":2}`
+	if got := strings.TrimSpace(buf.String()); !strings.HasSuffix(got, expected) || strings.Count(got, `"version":`) != 1 || strings.Count(got, `"host":"server1"`) != 1 {
+		t.Errorf("Expected suffix:\n%s\nGot:\n%s", expected, got)
+	}
+
+	// The host defaults to the hostname
+	hostname, _ := os.Hostname()
+	buf.Reset()
+	options = &ResolveReplaceOptions{GELFFields: true}
+	log = slog.New(NewOverwriteHandler(
+		slog.NewJSONHandler(buf, &slog.HandlerOptions{ReplaceAttr: ReplaceAttrGraylog(options)}),
+		&OverwriteHandlerOptions{ResolveKey: ResolveKeyGraylog(options)},
+	))
+	log.Info("main message")
+
+	expected = `"level":"INFO","version":"1.1","host":` + strconv.Quote(hostname) + `,"msg":"main message"}`
+	if got := strings.TrimSpace(buf.String()); !strings.HasSuffix(got, expected) {
+		t.Errorf("Expected suffix:\n%s\nGot:\n%s", expected, got)
+	}
+}
+
+func TestReplaceAttrGraylogSyslogLevel(t *testing.T) {
+	t.Parallel()
+
+	for _, gelf := range []bool{false, true} {
+		buf := &bytes.Buffer{}
+		options := &ResolveReplaceOptions{SyslogLevel: true, GELFFields: gelf, GELFHost: "server1"}
+		log := slog.New(NewOverwriteHandler(
+			slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: ReplaceAttrGraylog(options)}),
+			&OverwriteHandlerOptions{ResolveKey: ResolveKeyGraylog(options)},
+		))
+		log.Debug("debug message")
+		log.Warn("warn message", "level", "user-level")
+		log.Error("error message")
+
+		gelfFields := ""
+		if gelf {
+			gelfFields = `"version":"1.1","host":"server1",`
+		}
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 3 {
+			t.Fatalf("Expected 3 lines; Got: %s", buf.String())
+		}
+		for i, expected := range []string{
+			`"level":7,` + gelfFields + `"msg":"debug message"}`,
+			`"level":4,` + gelfFields + `"msg":"warn message","level#01":"user-level"}`,
+			`"level":3,` + gelfFields + `"msg":"error message"}`,
+		} {
+			if !strings.HasSuffix(lines[i], expected) {
+				t.Errorf("Expected suffix:\n%s\nGot:\n%s", expected, lines[i])
+			}
+		}
+	}
+}
+
+func TestReplaceAttrGraylogSourceFields(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	options := &ResolveReplaceOptions{SourceFields: true}
+	log := slog.New(NewOverwriteHandler(
+		slog.NewJSONHandler(buf, &slog.HandlerOptions{AddSource: true, ReplaceAttr: ReplaceAttrGraylog(options)}),
+		&OverwriteHandlerOptions{ResolveKey: ResolveKeyGraylog(options)},
+	))
+	_, _, line, _ := runtime.Caller(0)
+	log.Info("main message", "_line", "user-line")
+
+	var m map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("Unable to unmarshal json: %v", err)
+	}
+	if file, _ := m["_file"].(string); !strings.HasSuffix(file, "resolve_keys_replace_attrs_test.go") {
+		t.Errorf("Expected _file of this test; Got: %s", buf.String())
+	}
+	if m["_line"] != float64(line+1) || m["_line#01"] != "user-line" {
+		t.Errorf("Expected _line %d and _line#01; Got: %s", line+1, buf.String())
+	}
+	if _, ok := m["sourceLoc"]; ok {
+		t.Errorf("Expected no sourceLoc; Got: %s", buf.String())
+	}
+}
+
+func TestReplaceAttrGraylogLevelText(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	options := &ResolveReplaceOptions{SyslogLevel: true, LevelText: true, GELFFields: true, GELFHost: "myhost"}
+	log := slog.New(NewOverwriteHandler(
+		slog.NewJSONHandler(buf, &slog.HandlerOptions{ReplaceAttr: ReplaceAttrGraylog(options)}),
+		&OverwriteHandlerOptions{ResolveKey: ResolveKeyGraylog(options)},
+	))
+	log.With("level_text", "with").Warn("main message", "level", "user-level", "level_text", "user")
+
+	// Each key must appear once, which unmarshalling into a map would hide
+	got := buf.String()
+	for _, key := range []string{`"level":`, `"level_text":`, `"level#01":`, `"level_text#01":`, `"host":`} {
+		if n := strings.Count(got, key); n != 1 {
+			t.Errorf("Expected %s once; Got %d: %s", key, n, got)
+		}
+	}
+
+	var m map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("Unable to unmarshal json: %v", err)
+	}
+	if m["level"] != float64(4) || m["level_text"] != "WARN" {
+		t.Errorf("Expected level 4 and level_text WARN; Got: %s", got)
+	}
+	if m["level#01"] != "user-level" || m["level_text#01"] != "user" {
+		t.Errorf("Expected user level and level_text incremented; Got: %s", got)
+	}
+}
+
+func TestResolveKeyReplaceAttrECS(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	h := NewOverwriteHandler(
+		slog.NewJSONHandler(buf, &slog.HandlerOptions{ReplaceAttr: ReplaceAttrECS(nil)}),
+		&OverwriteHandlerOptions{ResolveKey: ResolveKeyECS(nil), CommonOptions: CommonOptions{EnsureTime: true}},
+	)
+
+	// A record with a zero time would normally have its time omitted
+	err := h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelWarn, "main message", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.WithAttrs([]slog.Attr{slog.String("@timestamp", "user"), slog.String("message", "user")}).
+		Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "second message", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines; Got: %s", buf.String())
+	}
+	for i, expected := range []string{
+		`"log.level":"warn","message":"main message"}`,
+		`"log.level":"info","message":"second message","@timestamp#01":"user","message#01":"user"}`,
+	} {
+		if !strings.HasPrefix(lines[i], `{"@timestamp":"`) || !strings.HasSuffix(lines[i], expected) {
+			t.Errorf("Expected @timestamp and suffix:\n%s\nGot:\n%s", expected, lines[i])
+		}
+	}
+}
+
+func TestJoinResolveKeyResults(t *testing.T) {
+	t.Parallel()
+
+	// Keep the user's own source key, stopping it from being incremented
+	keepSource := func(groups []string, key string, _ int) ResolveKeyResult {
+		if len(groups) == 0 && key == slog.SourceKey {
+			return ResolveKeyResult{Key: key, Keep: true, Final: true}
+		}
+		return ResolveKeyResult{Key: key, Keep: true}
+	}
+	resolveKey := JoinResolveKeyResults(keepSource, ResolveKeyResultOf(IncrementIfBuiltinKeyConflict))
+
+	tests := []struct {
+		name     string
+		handler  func(next slog.Handler) slog.Handler
+		expected string
+	}{
+		{
+			name: "final",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewOverwriteHandler(next, &OverwriteHandlerOptions{ResolveKey: resolveKey})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","group1":{"msg":"val3","source":"val4"},"msg#01":"val2","source":"val5"}`,
+		},
+		{
+			name: "not final",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewOverwriteHandler(next, &OverwriteHandlerOptions{ResolveKey: JoinResolveKey(IncrementIfBuiltinKeyConflict)})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","group1":{"msg":"val3","source":"val4"},"msg#01":"val2","source#01":"val5"}`,
+		},
+		{
+			// The final key isn't incremented by ResolveKey, so it is incremented the default way
+			name: "final increment",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIncrementHandler(next, &IncrementHandlerOptions{ResolveKey: resolveKey})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","group1":{"msg":"val3","source":"val4"},"msg#01":"val2","source":"val1","source#01":"val5"}`,
+		},
+		{
+			// The same for a root collision mode that increments
+			name: "final root increment",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewOverwriteHandler(next, &OverwriteHandlerOptions{ResolveKey: resolveKey, CommonOptions: CommonOptions{RootCollisionMode: DedupIncrement}})
+			},
+			expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","group1":{"msg":"val3","source":"val4"},"msg#01":"val2","source":"val1","source#01":"val5"}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		slog.New(testCase.handler(tester)).Info("main message", "source", "val1", "msg", "val2", "source", "val5", slog.Group("group1", "msg", "val3", "source", "val4"))
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != testCase.expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, testCase.expected, jStr)
+		}
+	}
+}
+
+func TestResolveKeyGroupNameCalls(t *testing.T) {
+	t.Parallel()
+
+	type call struct {
+		key   string
+		index int
+	}
+
+	tests := []struct {
+		name     string
+		handler  func(next slog.Handler, resolveKey func(groups []string, key string, index int) (string, bool)) slog.Handler
+		expected []call
+	}{
+		{
+			name: "overwrite",
+			handler: func(next slog.Handler, resolveKey func(groups []string, key string, index int) (string, bool)) slog.Handler {
+				return NewOverwriteHandler(next, &OverwriteHandlerOptions{ResolveKey: resolveKey})
+			},
+			expected: []call{{"group1", 0}, {"group1", 0}},
+		},
+		{
+			name: "ignore",
+			handler: func(next slog.Handler, resolveKey func(groups []string, key string, index int) (string, bool)) slog.Handler {
+				return NewIgnoreHandler(next, &IgnoreHandlerOptions{ResolveKey: resolveKey})
+			},
+			expected: []call{{"group1", 0}, {"group1", 0}},
+		},
+		{
+			name: "increment",
+			handler: func(next slog.Handler, resolveKey func(groups []string, key string, index int) (string, bool)) slog.Handler {
+				return NewIncrementHandler(next, &IncrementHandlerOptions{ResolveKey: resolveKey})
+			},
+			expected: []call{{"group1", 0}, {"group1", 0}, {"group1", 1}},
+		},
+		{
+			name: "append",
+			handler: func(next slog.Handler, resolveKey func(groups []string, key string, index int) (string, bool)) slog.Handler {
+				return NewAppendHandler(next, &AppendHandlerOptions{ResolveKey: resolveKey})
+			},
+			expected: []call{{"group1", 0}, {"group1", 0}},
+		},
+		{
+			name: "root collision increment",
+			handler: func(next slog.Handler, resolveKey func(groups []string, key string, index int) (string, bool)) slog.Handler {
+				return NewOverwriteHandler(next, &OverwriteHandlerOptions{ResolveKey: resolveKey, CommonOptions: CommonOptions{RootCollisionMode: DedupIncrement}})
+			},
+			expected: []call{{"group1", 0}, {"group1", 0}, {"group1", 1}},
+		},
+	}
+
+	// The name of a group colliding with a prior attribute must be resolved
+	// exactly like the key of a second attribute would be, whether the group
+	// came from WithGroup or from slog.Group
+	for _, testCase := range tests {
+		for _, source := range []string{"attribute", "WithGroup", "slog.Group"} {
+			var calls []call
+			resolveKey := func(groups []string, key string, index int) (string, bool) {
+				if len(groups) == 0 && key == "group1" {
+					calls = append(calls, call{key, index})
+				}
+				return IncrementIfBuiltinKeyConflict(groups, key, index)
+			}
+
+			log := slog.New(testCase.handler(&testHandler{}, resolveKey)).With("group1", "scalar")
+			switch source {
+			case "attribute":
+				log.Info("group names", "group1", "val1")
+			case "WithGroup":
+				log.WithGroup("group1").Info("group names", "arg1", "val1")
+			case "slog.Group":
+				log.Info("group names", slog.Group("group1", "arg1", "val1"))
+			}
+
+			if !slices.Equal(calls, testCase.expected) {
+				t.Errorf("%s %s Expected calls: %v; Got: %v", testCase.name, source, testCase.expected, calls)
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package slogdedup
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// RingBufferHandler is a slog.Handler middleware that retains the most recent
+// records it handles in memory, before passing each record off to the next
+// handler. It is useful for dumping the last few log lines after a crash.
+// The records are retained raw, so they do not include any attributes or
+// groups added with WithAttrs or WithGroup.
+type RingBufferHandler struct {
+	next slog.Handler
+	ring *recordRing
+}
+
+var _ slog.Handler = &RingBufferHandler{} // Assert conformance with interface
+
+// recordRing is a fixed size circular buffer of records, shared by a
+// RingBufferHandler and all handlers derived from it.
+type recordRing struct {
+	mu      sync.Mutex
+	records []slog.Record
+	start   int // index of the oldest record, once the buffer is full
+}
+
+// NewRingBufferMiddleware creates a RingBufferHandler slog.Handler middleware
+// that conforms to [github.com/samber/slog-multi.Middleware] interface.
+// Because the middleware creates a new buffer for each handler it wraps,
+// use NewRingBufferHandler instead if the buffer needs to be dumped.
+func NewRingBufferMiddleware(size int) func(slog.Handler) slog.Handler {
+	return func(next slog.Handler) slog.Handler {
+		return NewRingBufferHandler(
+			next,
+			size,
+		)
+	}
+}
+
+// NewRingBufferHandler creates a RingBufferHandler slog.Handler middleware
+// that retains the last size records it handles, and passes every record off
+// to the next handler. If size is less than 1, no records are retained.
+// Handlers created by WithAttrs and WithGroup share the same buffer.
+// If next is nil, all records are discarded instead of panicking.
+func NewRingBufferHandler(next slog.Handler, size int) *RingBufferHandler {
+	return &RingBufferHandler{
+		next: nextOrDiscard(next),
+		ring: &recordRing{records: make([]slog.Record, 0, max(size, 0))},
+	}
+}
+
+// Enabled reports whether the next handler handles records at the given level.
+// The handler ignores records whose level is lower.
+func (h *RingBufferHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle retains a copy of the record, then passes the record to the next handler.
+func (h *RingBufferHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.ring.add(r.Clone())
+	return h.next.Handle(ctx, r)
+}
+
+// WithGroup returns a new RingBufferHandler whose next handler has the group.
+func (h *RingBufferHandler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	h2.next = h2.next.WithGroup(name)
+	return &h2
+}
+
+// WithAttrs returns a new RingBufferHandler whose next handler has the attributes.
+func (h *RingBufferHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.next = h2.next.WithAttrs(attrs)
+	return &h2
+}
+
+// Dump returns a copy of the retained records, ordered from oldest to newest.
+func (h *RingBufferHandler) Dump() []slog.Record {
+	return h.ring.dump()
+}
+
+// add puts the record into the buffer, overwriting the oldest if full
+func (rr *recordRing) add(r slog.Record) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if cap(rr.records) == 0 {
+		return
+	}
+	if len(rr.records) < cap(rr.records) {
+		rr.records = append(rr.records, r)
+		return
+	}
+	rr.records[rr.start] = r
+	rr.start = (rr.start + 1) % len(rr.records)
+}
+
+// dump returns a copy of the records in the buffer, from oldest to newest
+func (rr *recordRing) dump() []slog.Record {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	records := make([]slog.Record, 0, len(rr.records))
+	records = append(records, rr.records[rr.start:]...)
+	return append(records, rr.records[:rr.start]...)
+}
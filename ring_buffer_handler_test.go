@@ -0,0 +1,49 @@
+package slogdedup
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+func TestRingBufferHandler(t *testing.T) {
+	t.Parallel()
+
+	h := NewRingBufferHandler(slog.NewJSONHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelInfo}), 3)
+	log := slog.New(h)
+
+	if records := h.Dump(); len(records) != 0 {
+		t.Errorf("Expected an empty buffer; Got %d records", len(records))
+	}
+
+	log.Info("msg1")
+	log.Debug("not enabled")
+	log.With("with1", "arg0").Info("msg2")
+
+	expected := []string{"msg1", "msg2"}
+	if got := ringMessages(h.Dump()); fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("Expected: %v; Got: %v", expected, got)
+	}
+
+	log.WithGroup("group1").Info("msg3", "arg1", "val1")
+	log.Warn("msg4")
+	log.Error("msg5")
+
+	expected = []string{"msg3", "msg4", "msg5"}
+	records := h.Dump()
+	if got := ringMessages(records); fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("Expected: %v; Got: %v", expected, got)
+	}
+	if records[0].NumAttrs() != 1 {
+		t.Errorf("Expected the raw record attributes to be retained; Got %d", records[0].NumAttrs())
+	}
+}
+
+func ringMessages(records []slog.Record) []string {
+	msgs := make([]string, 0, len(records))
+	for _, r := range records {
+		msgs = append(msgs, r.Message)
+	}
+	return msgs
+}
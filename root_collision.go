@@ -0,0 +1,99 @@
+package slogdedup
+
+// collider resolves keys and puts values into a level of the tree, using a
+// DedupMode instead of the handler's own strategy. It is used for the root
+// level with RootCollisionMode, and for the attributes of inlined groups with
+// InlineCollisionMode.
+type collider struct {
+	mode       DedupMode
+	resolveKey func(groups []string, key string, index int) (string, bool)
+}
+
+// newCollider returns a collider, or nil if the mode is the default.
+func newCollider(mode DedupMode, resolveKey func(groups []string, key string, index int) (string, bool)) *collider {
+	if mode == DedupDefault {
+		return nil
+	}
+	return &collider{mode: mode, resolveKey: resolveKey}
+}
+
+// handles returns true if the collider is responsible for keys in the group
+// path, which is only the root level. Safe to call on a nil collider.
+func (c *collider) handles(groups []string) bool {
+	return c != nil && len(groups) == 0
+}
+
+// resolve returns the key to use for the attribute or group key within the
+// group path, and true to keep it or false to drop it. When incrementing, the
+// key is resolved with increasing indexes until it is not already in the tree.
+// If the resolved key stops changing with the index, it is incremented the
+// default way instead.
+func (c *collider) resolve(uniq attrStore, groups []string, key string) (string, bool) {
+	if c.mode != DedupIncrement {
+		return c.resolveKey(groups, key, 0)
+	}
+	var prevKey string
+	for index := 0; ; index++ {
+		newKey, keep := c.resolveKey(groups, key, index)
+		if !keep {
+			return "", false
+		}
+		if _, exists := uniq.Get(newKey); !exists {
+			return newKey, true
+		}
+		if index > 0 && newKey == prevKey {
+			return freeIncrementKey(uniq, newKey), true
+		}
+		prevKey = newKey
+	}
+}
+
+// put puts the value, either an attribute or a subtree, into the level of the
+// tree under the already resolved key, according to the mode.
+func (c *collider) put(state *handleState, uniq attrStore, key string, v any) {
+	// Put calls func(oldValue, true) if key already exists, or func(oldValue, false) if it doesn't.
+	// Then expects us to return (newValue, true) if replacing the oldValue, or (whatever, false) if not.
+	_, written := uniq.Put(key, func(oldValue any, exists bool) (any, bool) {
+		if !exists {
+			return v, true
+		}
+		switch c.mode {
+		case DedupIgnore:
+			return nil, false
+		case DedupAppend:
+			if slice, ok := oldValue.(appended); ok {
+				return append(slice, v), true
+			}
+			return appended{oldValue, v}, true
+		default:
+			return v, true // Overwrite (incremented keys never exist)
+		}
+	})
+	if written {
+		state.order.touch(uniq, key)
+	}
+}
+
+// inline calls resolve to resolve the attributes of an inlined group (a group
+// with an empty key) into the level of the tree at the group path, marking
+// them as inlined while doing so.
+func (s *handleState) inline(groups []string, resolve func()) {
+	prev := s.inlineLevel
+	s.inlineLevel = len(groups) + 1
+	resolve()
+	s.inlineLevel = prev
+}
+
+// colliderFor returns the collider responsible for the key being resolved at
+// the group path, or nil if the handler's own strategy applies. The inline
+// collider takes precedence for the attributes of inlined groups, followed by
+// the root collider for the root level.
+func (s *handleState) colliderFor(groups []string, root, inline *collider) *collider {
+	if inline != nil && s.inlineLevel == len(groups)+1 {
+		return inline
+	}
+	if root.handles(groups) {
+		return root
+	}
+	return nil
+}
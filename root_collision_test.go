@@ -0,0 +1,181 @@
+package slogdedup
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRootCollisionMode(t *testing.T) {
+	t.Parallel()
+
+	handlers := map[string]func(next slog.Handler, mode DedupMode) slog.Handler{
+		"overwrite": func(next slog.Handler, mode DedupMode) slog.Handler {
+			return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{RootCollisionMode: mode}})
+		},
+		"ignore": func(next slog.Handler, mode DedupMode) slog.Handler {
+			return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{RootCollisionMode: mode}})
+		},
+		"increment": func(next slog.Handler, mode DedupMode) slog.Handler {
+			return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{RootCollisionMode: mode}})
+		},
+		"append": func(next slog.Handler, mode DedupMode) slog.Handler {
+			return NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{RootCollisionMode: mode}})
+		},
+	}
+
+	tests := map[DedupMode]string{
+		DedupOverwrite: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","arg1":"main1","arg2":{"arg3":"main3"},"group1":"main1"}`,
+		DedupIgnore:    `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","arg1":"with1","arg2":"with1","group1":{"arg2":"with1"}}`,
+		DedupIncrement: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","arg1":"with1","arg1#01":"main1","arg2":"with1","arg2#01":{"arg3":"main3"},"group1":{"arg2":"with1"},"group1#01":"main1"}`,
+		DedupAppend:    `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","arg1":["with1","main1"],"arg2":["with1",{"arg3":"main3"}],"group1":[{"arg2":"with1"},"main1"]}`,
+	}
+
+	for mode, expected := range tests {
+		for name, handler := range handlers {
+			tester := &testHandler{}
+			log := slog.New(handler(tester, mode)).With("arg1", "with1", slog.Group("group1", "arg2", "with1"), "arg2", "with1")
+			log.Info("main message", "arg1", "main1", "group1", "main1", slog.Group("arg2", "arg3", "main3"))
+
+			jBytes, err := tester.MarshalJSON()
+			if err != nil {
+				t.Errorf("Unable to marshal json: %v", err)
+				continue
+			}
+			jStr := strings.TrimSpace(string(jBytes))
+
+			if jStr != expected {
+				t.Errorf("%s mode %d Expected:\n%s\nGot:\n%s", name, mode, expected, jStr)
+			}
+		}
+	}
+}
+
+func TestRootCollisionMode_KeepsGroupBehavior(t *testing.T) {
+	t.Parallel()
+
+	tester := &testHandler{}
+	h := NewIgnoreHandler(tester, &IgnoreHandlerOptions{CommonOptions: CommonOptions{RootCollisionMode: DedupOverwrite}})
+	log := slog.New(h).With("arg1", "with1").WithGroup("group1").With("arg2", "with1")
+	log.Info("main message", "arg2", "main1")
+
+	jBytes, err := tester.MarshalJSON()
+	if err != nil {
+		t.Fatalf("Unable to marshal json: %v", err)
+	}
+
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","arg1":"with1","group1":{"arg2":"with1"}}`
+	if s := strings.TrimSpace(string(jBytes)); s != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, s)
+	}
+
+	tester = &testHandler{}
+	h2 := NewAppendHandler(tester, &AppendHandlerOptions{CommonOptions: CommonOptions{RootCollisionMode: DedupIgnore}})
+	log = slog.New(h2).With("arg1", "with1", slog.Group("group1", "arg2", "with1"))
+	log.Info("main message", "arg1", "main1", slog.Group("group2", "arg2", "main1", "arg2", "main2"))
+
+	jBytes, err = tester.MarshalJSON()
+	if err != nil {
+		t.Fatalf("Unable to marshal json: %v", err)
+	}
+
+	expected = `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","arg1":"with1","group1":{"arg2":"with1"},"group2":{"arg2":["main1","main2"]}}`
+	if s := strings.TrimSpace(string(jBytes)); s != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, s)
+	}
+}
+
+func TestInlineCollisionMode(t *testing.T) {
+	t.Parallel()
+
+	handlers := map[string]func(next slog.Handler, mode DedupMode) slog.Handler{
+		"overwrite": func(next slog.Handler, mode DedupMode) slog.Handler {
+			return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{InlineCollisionMode: mode}})
+		},
+		"ignore": func(next slog.Handler, mode DedupMode) slog.Handler {
+			return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{InlineCollisionMode: mode}})
+		},
+		"increment": func(next slog.Handler, mode DedupMode) slog.Handler {
+			return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{InlineCollisionMode: mode}})
+		},
+		"append": func(next slog.Handler, mode DedupMode) slog.Handler {
+			return NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{InlineCollisionMode: mode}})
+		},
+	}
+
+	// The default behavior of each handler for an inlined group colliding with
+	// existing keys, at the root level and within a group
+	defaults := map[string]string{
+		"overwrite": `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","arg1":"inline1","arg2":"main2","group1":{"arg1":"inline1"}}`,
+		"ignore":    `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","arg1":"with1","arg2":"main2","group1":{"arg1":"main1"}}`,
+		"increment": `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","arg1":"with1","arg1#01":"inline1","arg2":"main2","group1":{"arg1":"main1","arg1#01":"inline1"}}`,
+		"append":    `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","arg1":["with1","inline1"],"arg2":"main2","group1":{"arg1":["main1","inline1"]}}`,
+	}
+
+	// Every handler resolves inlined collisions the same way for a given mode
+	tests := map[DedupMode]string{
+		DedupOverwrite: defaults["overwrite"],
+		DedupIgnore:    defaults["ignore"],
+		DedupIncrement: defaults["increment"],
+		DedupAppend:    defaults["append"],
+	}
+
+	for name, handler := range handlers {
+		for _, mode := range []DedupMode{DedupDefault, DedupOverwrite, DedupIgnore, DedupIncrement, DedupAppend} {
+			expected, ok := tests[mode]
+			if !ok {
+				expected = defaults[name]
+			}
+
+			tester := &testHandler{}
+			log := slog.New(handler(tester, mode)).With("arg1", "with1")
+			log.Info("main message", "arg2", "main2", slog.Group("", "arg1", "inline1"),
+				slog.Group("group1", "arg1", "main1", slog.Group("", "arg1", "inline1")))
+
+			jBytes, err := tester.MarshalJSON()
+			if err != nil {
+				t.Errorf("Unable to marshal json: %v", err)
+				continue
+			}
+			jStr := strings.TrimSpace(string(jBytes))
+
+			if jStr != expected {
+				t.Errorf("%s mode %s Expected:\n%s\nGot:\n%s", name, mode, expected, jStr)
+			}
+		}
+	}
+}
+
+func TestRootCollisionMode_IndexIgnoringResolveKey(t *testing.T) {
+	t.Parallel()
+
+	// ResolveKey returns the same key for every index, so the collider must
+	// fall back to incrementing the key the default way instead of looping.
+	resolveKey := func(_ []string, key string, _ int) (string, bool) {
+		return strings.ToUpper(key), true
+	}
+
+	done := make(chan string, 1)
+	go func() {
+		tester := &testHandler{}
+		log := slog.New(NewOverwriteHandler(tester, &OverwriteHandlerOptions{CommonOptions: CommonOptions{RootCollisionMode: DedupIncrement}, ResolveKey: resolveKey}))
+		log.Info("main message", "a", 1, "a", 2, "a", 3)
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+		}
+		done <- strings.TrimSpace(string(jBytes))
+	}()
+
+	select {
+	case jStr := <-done:
+		expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","A":1,"A#01":2,"A#02":3}`
+		if jStr != expected {
+			t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out resolving a key with a ResolveKey that ignores the index")
+	}
+}
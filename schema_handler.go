@@ -0,0 +1,143 @@
+package slogdedup
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// TypeErrorSuffix is added to the key of any attribute whose value does not
+// match the kind expected by the SchemaHandler's schema.
+const TypeErrorSuffix = "#type_error"
+
+// SchemaHandler is a slog.Handler middleware that checks each attribute
+// against a schema of expected kinds, before passing the record off to the
+// next handler. The schema's keys are the path of keys to an attribute, with
+// group names and the attribute key joined with dots, such as "group1.count".
+// Any attribute whose value is not of the expected kind has TypeErrorSuffix
+// added to its key, such as "count#type_error", and is moved after the other
+// attributes in its group, so that it doesn't pollute the field's type in a
+// strict pipeline. If an attribute with that key already exists, the
+// mismatched attribute is dropped instead. Attributes that are not
+// in the schema are passed through unchanged.
+// Kinds must match exactly, so a schema expecting slog.KindInt64 will reject a
+// slog.KindUint64 or slog.KindFloat64 value.
+// Put this handler after one of the deduplicating handlers, so that each key
+// is only checked once.
+type SchemaHandler struct {
+	next   slog.Handler
+	schema map[string]slog.Kind
+	groups []string
+}
+
+var _ slog.Handler = &SchemaHandler{} // Assert conformance with interface
+
+// NewSchemaMiddleware creates a SchemaHandler slog.Handler middleware
+// that conforms to [github.com/samber/slog-multi.Middleware] interface.
+// It can be used with slogmulti methods such as Pipe to easily setup a pipeline of slog handlers:
+//
+//	slog.SetDefault(slog.New(slogmulti.
+//		Pipe(slogdedup.NewOverwriteMiddleware(&slogdedup.OverwriteHandlerOptions{})).
+//		Pipe(slogdedup.NewSchemaMiddleware(map[string]slog.Kind{"count": slog.KindInt64})).
+//		Handler(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{})),
+//	))
+func NewSchemaMiddleware(schema map[string]slog.Kind) func(slog.Handler) slog.Handler {
+	return func(next slog.Handler) slog.Handler {
+		return NewSchemaHandler(
+			next,
+			schema,
+		)
+	}
+}
+
+// NewSchemaHandler creates a SchemaHandler slog.Handler middleware that checks
+// each attribute against the schema of expected kinds, before passing the
+// record off to the next handler.
+// If next is nil, all records are discarded instead of panicking.
+func NewSchemaHandler(next slog.Handler, schema map[string]slog.Kind) *SchemaHandler {
+	return &SchemaHandler{
+		next:   nextOrDiscard(next),
+		schema: schema,
+	}
+}
+
+// Enabled reports whether the next handler handles records at the given level.
+// The handler ignores records whose level is lower.
+func (h *SchemaHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle checks the attributes against the schema, then passes the new record
+// to the next handler.
+func (h *SchemaHandler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := make([]slog.Attr, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+
+	newR := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	newR.AddAttrs(h.enforce(h.groups, attrs)...)
+	return h.next.Handle(ctx, newR)
+}
+
+// WithGroup returns a new SchemaHandler whose next handler has the group.
+func (h *SchemaHandler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	h2.groups = append(slices.Clip(h.groups), name)
+	h2.next = h2.next.WithGroup(name)
+	return &h2
+}
+
+// WithAttrs returns a new SchemaHandler whose next handler has the
+// attributes, after they have been checked against the schema.
+func (h *SchemaHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.next = h2.next.WithAttrs(h.enforce(h.groups, attrs))
+	return &h2
+}
+
+// enforce returns the attributes with any that don't match the schema renamed
+// or dropped, recursively. Inline groups are checked as part of their parent.
+func (h *SchemaHandler) enforce(groups []string, attrs []slog.Attr) []slog.Attr {
+	checked := make([]slog.Attr, 0, len(attrs))
+	var mismatched []slog.Attr
+	keys := make(map[string]struct{}, len(attrs))
+	for _, a := range attrs {
+		a.Value = a.Value.Resolve()
+		if a.Equal(slog.Attr{}) {
+			continue
+		}
+		if a.Key == "" && a.Value.Kind() == slog.KindGroup {
+			for _, ga := range h.enforce(groups, a.Value.Group()) {
+				keys[ga.Key] = struct{}{}
+				checked = append(checked, ga)
+			}
+			continue
+		}
+
+		path := strings.Join(append(slices.Clip(groups), a.Key), ".")
+		if kind, ok := h.schema[path]; ok && kind != a.Value.Kind() {
+			mismatched = append(mismatched, a)
+			continue
+		}
+		if a.Value.Kind() == slog.KindGroup {
+			a.Value = slog.GroupValue(h.enforce(append(slices.Clip(groups), a.Key), a.Value.Group())...)
+		}
+		keys[a.Key] = struct{}{}
+		checked = append(checked, a)
+	}
+
+	// Rename the mismatches last, so that they can be dropped if they would
+	// become duplicates of any existing keys
+	for _, a := range mismatched {
+		a.Key += TypeErrorSuffix
+		if _, exists := keys[a.Key]; exists {
+			continue
+		}
+		keys[a.Key] = struct{}{}
+		checked = append(checked, a)
+	}
+	return checked
+}
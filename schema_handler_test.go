@@ -0,0 +1,60 @@
+package slogdedup
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSchemaHandler(t *testing.T) {
+	t.Parallel()
+
+	schema := map[string]slog.Kind{
+		"count":        slog.KindInt64,
+		"user":         slog.KindGroup,
+		"user.id":      slog.KindString,
+		"user.admin":   slog.KindBool,
+		"group1.ratio": slog.KindFloat64,
+	}
+
+	tester := &testHandler{}
+	h := NewOverwriteHandler(NewSchemaHandler(tester, schema), nil)
+
+	log := slog.New(h).With("count", "seven", "other", "val1")
+	log.Info("schema",
+		slog.Group("user", "id", 123, "admin", true, "id#type_error", "already"),
+		slog.Group("group1", "ratio", 0.5),
+	)
+
+	jBytes, err := tester.MarshalJSON()
+	if err != nil {
+		t.Errorf("Unable to marshal json: %v", err)
+	}
+	jStr := strings.TrimSpace(string(jBytes))
+
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"schema","group1":{"ratio":0.5},"other":"val1","user":{"admin":true,"id#type_error":"already"},"count#type_error":"seven"}`
+	if jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+
+	checkRecordForDuplicates(t, tester.Record)
+
+	// Used directly, without a deduplicating handler in front
+	buf := &bytes.Buffer{}
+	sink := slog.NewJSONHandler(buf, &slog.HandlerOptions{ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return a
+	}})
+	log = slog.New(NewSchemaHandler(sink, schema)).With("count", 1).WithGroup("group1").With("ratio", "half")
+	log.Info("direct", "ratio", 0.5, "user", "bob")
+
+	jStr = strings.TrimSpace(buf.String())
+
+	expected = `{"level":"INFO","msg":"direct","count":1,"group1":{"ratio#type_error":"half","ratio":0.5,"user":"bob"}}`
+	if jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+}
@@ -0,0 +1,43 @@
+package slogdedup
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// registeredSink is the pair of functions registered for a sink
+type registeredSink struct {
+	resolveKey  func(groups []string, key string, index int) (string, bool)
+	replaceAttr func(groups []string, a slog.Attr) slog.Attr
+}
+
+var (
+	sinkRegistryMu sync.RWMutex
+	sinkRegistry   = map[string]registeredSink{
+		"graylog":     {resolveKey: ResolveKeyGraylog(nil), replaceAttr: ReplaceAttrGraylog(nil)},
+		"stackdriver": {resolveKey: ResolveKeyStackdriver(nil), replaceAttr: ReplaceAttrStackdriver(nil)},
+		"ecs":         {resolveKey: ResolveKeyECS(nil), replaceAttr: ReplaceAttrECS(nil)},
+	}
+)
+
+// RegisterSink registers the ResolveKey and ReplaceAttr functions for a log
+// sink under the name, so that it can be selected by a configuration string
+// with SinkByName. Registering a name again replaces the previous functions.
+// The built-in sinks are pre-registered, using the default options, as
+// "graylog", "stackdriver", and "ecs".
+func RegisterSink(name string, resolveKey func(groups []string, key string, index int) (string, bool), replaceAttr func(groups []string, a slog.Attr) slog.Attr) {
+	sinkRegistryMu.Lock()
+	defer sinkRegistryMu.Unlock()
+	sinkRegistry[name] = registeredSink{resolveKey: resolveKey, replaceAttr: replaceAttr}
+}
+
+// SinkByName returns the ResolveKey and ReplaceAttr functions registered for
+// the log sink with the name, and true, or false if no sink was registered
+// with the name. The ResolveKey function is meant for the handler options in
+// this package, and the ReplaceAttr function for the final slog handler.
+func SinkByName(name string) (func(groups []string, key string, index int) (string, bool), func(groups []string, a slog.Attr) slog.Attr, bool) {
+	sinkRegistryMu.RLock()
+	defer sinkRegistryMu.RUnlock()
+	s, ok := sinkRegistry[name]
+	return s.resolveKey, s.replaceAttr, ok
+}
@@ -0,0 +1,57 @@
+package slogdedup
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSinkRegistry(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"graylog", "stackdriver", "ecs"} {
+		if resolveKey, replaceAttr, ok := SinkByName(name); !ok || resolveKey == nil || replaceAttr == nil {
+			t.Errorf("Expected built-in sink %q to be registered", name)
+		}
+	}
+
+	if _, _, ok := SinkByName("test-missing"); ok {
+		t.Errorf("Expected no sink to be registered as test-missing")
+	}
+
+	RegisterSink("test-upper",
+		func(groups []string, key string, index int) (string, bool) {
+			return IncrementIfBuiltinKeyConflict(groups, strings.ToUpper(key), index)
+		},
+		func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.MessageKey {
+				a.Key = "MESSAGE"
+			}
+			return a
+		},
+	)
+
+	resolveKey, replaceAttr, ok := SinkByName("test-upper")
+	if !ok {
+		t.Fatalf("Expected sink test-upper to be registered")
+	}
+
+	tester := &testHandler{}
+	log := slog.New(NewOverwriteHandler(tester, &OverwriteHandlerOptions{ResolveKey: resolveKey}))
+	log.Info("registered", "arg1", "val1", "Arg1", "val2")
+
+	jBytes, err := tester.MarshalJSON()
+	if err != nil {
+		t.Errorf("Unable to marshal json: %v", err)
+	}
+	jStr := strings.TrimSpace(string(jBytes))
+
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"registered","ARG1":"val2"}`
+	if jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+
+	if a := replaceAttr(nil, slog.String(slog.MessageKey, "registered")); a.Key != "MESSAGE" {
+		t.Errorf("Expected replaced key MESSAGE; Got %s", a.Key)
+	}
+}
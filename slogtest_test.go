@@ -22,6 +22,18 @@ func TestSlogtest(t *testing.T) {
 		{"IgnoreHandler", func(w io.Writer) slog.Handler { return slogdedup.NewIgnoreHandler(slog.NewJSONHandler(w, nil), nil) }, parseJSON},
 		{"IncrementHandler", func(w io.Writer) slog.Handler { return slogdedup.NewIncrementHandler(slog.NewJSONHandler(w, nil), nil) }, parseJSON},
 		{"AppendHandler", func(w io.Writer) slog.Handler { return slogdedup.NewAppendHandler(slog.NewJSONHandler(w, nil), nil) }, parseJSON},
+		{"OverwriteHandlerCached", func(w io.Writer) slog.Handler {
+			return slogdedup.NewOverwriteHandler(slog.NewJSONHandler(w, nil), &slogdedup.OverwriteHandlerOptions{CommonOptions: slogdedup.CommonOptions{CacheWithAttrs: true}})
+		}, parseJSON},
+		{"IgnoreHandlerCached", func(w io.Writer) slog.Handler {
+			return slogdedup.NewIgnoreHandler(slog.NewJSONHandler(w, nil), &slogdedup.IgnoreHandlerOptions{CommonOptions: slogdedup.CommonOptions{CacheWithAttrs: true}})
+		}, parseJSON},
+		{"IncrementHandlerCached", func(w io.Writer) slog.Handler {
+			return slogdedup.NewIncrementHandler(slog.NewJSONHandler(w, nil), &slogdedup.IncrementHandlerOptions{CommonOptions: slogdedup.CommonOptions{CacheWithAttrs: true}})
+		}, parseJSON},
+		{"AppendHandlerCached", func(w io.Writer) slog.Handler {
+			return slogdedup.NewAppendHandler(slog.NewJSONHandler(w, nil), &slogdedup.AppendHandlerOptions{CommonOptions: slogdedup.CommonOptions{CacheWithAttrs: true}})
+		}, parseJSON},
 	} {
 		t.Run(test.name, func(t *testing.T) {
 			var buf bytes.Buffer
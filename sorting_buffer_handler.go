@@ -0,0 +1,139 @@
+package slogdedup
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+)
+
+// SortingBufferHandler is a slog.Handler middleware that holds the records it
+// handles in memory, and then passes them off to the next handler sorted by
+// their time when flushed. It is useful for batch exporters that require
+// records in timestamp order, and can be placed after a deduplicating handler.
+// Records are flushed when Flush is called, or automatically once the buffer
+// holds size records. Records with the same time keep their original order.
+type SortingBufferHandler struct {
+	next slog.Handler
+	buf  *sortingBuffer
+}
+
+var _ slog.Handler = &SortingBufferHandler{} // Assert conformance with interface
+
+// bufferedRecord is a record held by a SortingBufferHandler, along with the
+// context and the next handler (including any attributes and groups) it was
+// handled with.
+type bufferedRecord struct {
+	ctx    context.Context
+	next   slog.Handler
+	record slog.Record
+}
+
+// sortingBuffer is the buffer of records, shared by a SortingBufferHandler
+// and all handlers derived from it.
+type sortingBuffer struct {
+	mu      sync.Mutex
+	flushMu sync.Mutex // Keeps concurrent flushes from interleaving
+	size    int
+	records []bufferedRecord
+	flushed func()
+}
+
+// NewSortingBufferMiddleware creates a SortingBufferHandler slog.Handler
+// middleware that conforms to [github.com/samber/slog-multi.Middleware] interface.
+// Because the middleware creates a new buffer for each handler it wraps,
+// use NewSortingBufferHandler instead if the buffer needs to be flushed manually.
+func NewSortingBufferMiddleware(size int, flushed func()) func(slog.Handler) slog.Handler {
+	return func(next slog.Handler) slog.Handler {
+		return NewSortingBufferHandler(
+			next,
+			size,
+			flushed,
+		)
+	}
+}
+
+// NewSortingBufferHandler creates a SortingBufferHandler slog.Handler
+// middleware that holds records until flushed, then passes them off to the
+// next handler sorted by time. The buffer is flushed automatically once it
+// holds size records; if size is less than 1, it is only flushed by Flush.
+// If flushed is not nil, it is called after every flush, such as to tell a
+// batch exporter to send its batch.
+// Handlers created by WithAttrs and WithGroup share the same buffer.
+// If next is nil, all records are discarded instead of panicking.
+func NewSortingBufferHandler(next slog.Handler, size int, flushed func()) *SortingBufferHandler {
+	return &SortingBufferHandler{
+		next: nextOrDiscard(next),
+		buf:  &sortingBuffer{size: size, flushed: flushed},
+	}
+}
+
+// Enabled reports whether the next handler handles records at the given level.
+// The handler ignores records whose level is lower.
+func (h *SortingBufferHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle holds a copy of the record, flushing the buffer if it is full.
+func (h *SortingBufferHandler) Handle(ctx context.Context, r slog.Record) error {
+	if h.buf.add(bufferedRecord{ctx: ctx, next: h.next, record: r.Clone()}) {
+		return h.buf.flush()
+	}
+	return nil
+}
+
+// WithGroup returns a new SortingBufferHandler whose next handler has the group.
+func (h *SortingBufferHandler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	h2.next = h2.next.WithGroup(name)
+	return &h2
+}
+
+// WithAttrs returns a new SortingBufferHandler whose next handler has the attributes.
+func (h *SortingBufferHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.next = h2.next.WithAttrs(attrs)
+	return &h2
+}
+
+// Flush sorts all held records by time and passes them off to the next
+// handler, returning any errors from it joined together.
+func (h *SortingBufferHandler) Flush() error {
+	return h.buf.flush()
+}
+
+// add holds the record, and returns true if the buffer is now full
+func (sb *sortingBuffer) add(br bufferedRecord) bool {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.records = append(sb.records, br)
+	return sb.size > 0 && len(sb.records) >= sb.size
+}
+
+// flush empties the buffer, and passes the records off to their next
+// handler in time order
+func (sb *sortingBuffer) flush() error {
+	sb.flushMu.Lock()
+	defer sb.flushMu.Unlock()
+
+	sb.mu.Lock()
+	records := sb.records
+	sb.records = nil
+	sb.mu.Unlock()
+
+	slices.SortStableFunc(records, func(a, b bufferedRecord) int {
+		return a.record.Time.Compare(b.record.Time)
+	})
+
+	var errs []error
+	for _, br := range records {
+		if err := br.next.Handle(br.ctx, br.record); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if sb.flushed != nil {
+		sb.flushed()
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,56 @@
+package slogdedup
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSortingBufferHandler(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	var flushes int
+	h := NewSortingBufferHandler(slog.NewJSONHandler(buf, nil), 4, func() { flushes++ })
+	log := slog.New(h)
+
+	base := time.Date(2023, 9, 29, 13, 0, 59, 0, time.UTC)
+	logAt := func(l *slog.Logger, offset int, msg string) {
+		r := slog.NewRecord(base.Add(time.Duration(offset)*time.Second), slog.LevelInfo, msg, 0)
+		if err := l.Handler().Handle(context.Background(), r); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+
+	logAt(log, 3, "msg3")
+	logAt(log.With("arg1", "val1"), 1, "msg1")
+	logAt(log.WithGroup("group1").With("arg2", "val2"), 2, "msg2")
+	if buf.Len() != 0 || flushes != 0 {
+		t.Errorf("Expected the records to be held; Got:\n%s", buf.String())
+	}
+
+	// The fourth record fills the buffer
+	logAt(log, 0, "msg0")
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"msg0"}
+{"time":"2023-09-29T13:01:00Z","level":"INFO","msg":"msg1","arg1":"val1"}
+{"time":"2023-09-29T13:01:01Z","level":"INFO","msg":"msg2","group1":{"arg2":"val2"}}
+{"time":"2023-09-29T13:01:02Z","level":"INFO","msg":"msg3"}`
+	if s := strings.TrimSpace(buf.String()); s != expected || flushes != 1 {
+		t.Errorf("Expected 1 flush:\n%s\nGot %d:\n%s", expected, flushes, s)
+	}
+
+	buf.Reset()
+	logAt(log, 6, "msg6")
+	logAt(log, 5, "msg5")
+	if err := h.Flush(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	expected = `{"time":"2023-09-29T13:01:04Z","level":"INFO","msg":"msg5"}
+{"time":"2023-09-29T13:01:05Z","level":"INFO","msg":"msg6"}`
+	if s := strings.TrimSpace(buf.String()); s != expected || flushes != 2 {
+		t.Errorf("Expected 2 flushes:\n%s\nGot %d:\n%s", expected, flushes, s)
+	}
+}
@@ -0,0 +1,110 @@
+package slogdedup
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// SummarySinkOptions are options for a NewSummarySink slog.Handler
+type SummarySinkOptions struct {
+	// Summary, if true, writes a short summary line before the full JSON line
+	// of each record, which is easier to skim when tailing logs locally.
+	// The summary line has the level and msg, followed by the attributes as
+	// logfmt key=value pairs in the same order as the JSON, with attributes
+	// inside of groups having their keys prefixed by the group names:
+	//
+	//	INFO hello world arg1=val1 group1.arg2=val2
+	Summary bool
+
+	// HandlerOptions are used by the slog.JSONHandler that writes the JSON
+	// line. The Level is also used to determine which records are written.
+	// ReplaceAttr is not called on the summary line.
+	HandlerOptions *slog.HandlerOptions
+}
+
+// summaryHandler is a terminal slog.Handler that writes each record as a
+// single line of JSON, optionally preceded by a summary line.
+// It does not deduplicate anything itself, and expects to be placed after one
+// of the dedup middlewares.
+type summaryHandler struct {
+	mu      *sync.Mutex
+	w       io.Writer
+	opts    slog.HandlerOptions
+	summary bool
+}
+
+var _ slog.Handler = &summaryHandler{} // Assert conformance with interface
+
+// NewSummarySink creates a terminal slog.Handler that deduplicates all
+// attributes and groups by overwriting older duplicates (see OverwriteHandler),
+// then writes the record to w as a single line of JSON, using a
+// slog.JSONHandler. If opts.Summary is true, each JSON line is preceded by a
+// short summary line, with both lines written together.
+// If opts is nil, the default options are used.
+//
+//	INFO hello world arg1=val1 group1.arg2=val2
+//	{"time":"2024-03-21T09:33:25Z","level":"INFO","msg":"hello world","arg1":"val1","group1":{"arg2":"val2"}}
+func NewSummarySink(w io.Writer, opts *SummarySinkOptions) slog.Handler {
+	if opts == nil {
+		opts = &SummarySinkOptions{}
+	}
+	h := &summaryHandler{mu: &sync.Mutex{}, w: w, summary: opts.Summary}
+	if opts.HandlerOptions != nil {
+		h.opts = *opts.HandlerOptions
+	}
+	return NewOverwriteHandler(h, nil)
+}
+
+// Enabled reports whether the level is at least the minimum level of the
+// HandlerOptions, which defaults to INFO.
+func (h *summaryHandler) Enabled(_ context.Context, level slog.Level) bool {
+	minLevel := slog.LevelInfo
+	if h.opts.Level != nil {
+		minLevel = h.opts.Level.Level()
+	}
+	return level >= minLevel
+}
+
+// Handle writes the summary line, if enabled, and the JSON line.
+func (h *summaryHandler) Handle(ctx context.Context, r slog.Record) error {
+	buf := &bytes.Buffer{}
+	if h.summary {
+		line := &bytes.Buffer{}
+		line.WriteString(r.Level.String())
+		line.WriteByte(' ')
+		line.WriteString(r.Message)
+
+		var pairs [][2]string
+		r.Attrs(func(a slog.Attr) bool {
+			pairs = appendLogfmtPairs(pairs, "", a)
+			return true
+		})
+		for _, pair := range pairs {
+			writeLogfmtPair(line, pair[0], pair[1])
+		}
+		line.WriteByte('\n')
+		buf.Write(line.Bytes())
+	}
+
+	if err := slog.NewJSONHandler(buf, &h.opts).Handle(ctx, r); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(buf.Bytes())
+	return err
+}
+
+// WithGroup returns a new handler that deduplicates and namespaces any future attributes.
+func (h *summaryHandler) WithGroup(name string) slog.Handler {
+	return NewOverwriteHandler(h, nil).WithGroup(name)
+}
+
+// WithAttrs returns a new handler that deduplicates and includes the attributes.
+func (h *summaryHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return NewOverwriteHandler(h, nil).WithAttrs(attrs)
+}
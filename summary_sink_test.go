@@ -0,0 +1,44 @@
+package slogdedup
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+)
+
+func TestSummarySink(t *testing.T) {
+	t.Parallel()
+
+	// Remove the time, because it is variable
+	noTime := func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return a
+	}
+
+	buf := &bytes.Buffer{}
+	log := slog.New(NewSummarySink(buf, &SummarySinkOptions{Summary: true, HandlerOptions: &slog.HandlerOptions{ReplaceAttr: noTime}}))
+
+	log = log.With("zed", "first", "arg1", "with1arg1")
+	log.WithGroup("group1").Info("hello world", "arg2", "with spaces", "arg1", "main1arg1")
+	log.Debug("not enabled")
+
+	expected := `INFO hello world arg1=with1arg1 group1.arg1=main1arg1 group1.arg2="with spaces" zed=first
+{"level":"INFO","msg":"hello world","arg1":"with1arg1","group1":{"arg1":"main1arg1","arg2":"with spaces"},"zed":"first"}
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, buf.String())
+	}
+
+	// Without the summary line
+	buf.Reset()
+	log = slog.New(NewSummarySink(buf, &SummarySinkOptions{HandlerOptions: &slog.HandlerOptions{ReplaceAttr: noTime}}))
+	log.Info("hello world", "arg1", "val1", "arg1", "val2")
+
+	expected = `{"level":"INFO","msg":"hello world","arg1":"val2"}
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, buf.String())
+	}
+}
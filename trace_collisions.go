@@ -0,0 +1,65 @@
+package slogdedup
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// CollisionSpan is the part of a tracing span that is needed to record
+// deduplication collisions as span events. It exists so that this package does
+// not depend on any tracing library. For example, an adapter for an
+// OpenTelemetry span (go.opentelemetry.io/otel/trace) could be:
+//
+//	type otelSpan struct{ span trace.Span }
+//
+//	func (s otelSpan) AddEvent(name string, attrs ...slog.Attr) {
+//		kvs := make([]attribute.KeyValue, 0, len(attrs))
+//		for _, a := range attrs {
+//			kvs = append(kvs, attribute.String(a.Key, a.Value.String()))
+//		}
+//		s.span.AddEvent(name, trace.WithAttributes(kvs...))
+//	}
+//
+//	func spanFromContext(ctx context.Context) slogdedup.CollisionSpan {
+//		if span := trace.SpanFromContext(ctx); span.IsRecording() {
+//			return otelSpan{span: span}
+//		}
+//		return nil
+//	}
+type CollisionSpan interface {
+	AddEvent(name string, attrs ...slog.Attr)
+}
+
+// CollisionEventName is the name of the span event added for each collision.
+const CollisionEventName = "slogdedup.collision"
+
+// collision is a key that collided with an existing key, along with the
+// value that was kept and the value that was dropped. The values are either
+// an attribute or a subtree.
+type collision struct {
+	keyPath []string // Groups leading to the key, followed by the key
+	kept    any
+	dropped any
+}
+
+// collide records that the key in the groups collided with an existing key.
+func (s *handleState) collide(groups []string, key string, kept, dropped any) {
+	s.collisions = append(s.collisions, collision{keyPath: append(slices.Clip(groups), key), kept: kept, dropped: dropped})
+}
+
+// traceCollisions adds an event for each collision to the context's span,
+// if there is one.
+func traceCollisions(ctx context.Context, spanFromContext func(ctx context.Context) CollisionSpan, collisions []collision) {
+	if spanFromContext == nil || len(collisions) == 0 {
+		return
+	}
+	span := spanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	for _, c := range collisions {
+		span.AddEvent(CollisionEventName, slog.String("key", strings.Join(c.keyPath, ".")))
+	}
+}
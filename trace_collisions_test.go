@@ -0,0 +1,92 @@
+package slogdedup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type fakeSpanKey struct{}
+
+// fakeSpan records all events added to it
+type fakeSpan struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (s *fakeSpan) AddEvent(name string, attrs ...slog.Attr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, fmt.Sprintf("%s %v", name, attrs))
+}
+
+func TestOverwriteHandler_TraceCollisions(t *testing.T) {
+	t.Parallel()
+
+	for _, cache := range []bool{false, true} {
+		tester := &testHandler{}
+		h := NewOverwriteHandler(tester, &OverwriteHandlerOptions{
+			TraceCollisions: true,
+			CommonOptions: CommonOptions{
+				CacheWithAttrs: cache,
+			},
+			SpanFromContext: func(ctx context.Context) CollisionSpan {
+				if span, ok := ctx.Value(fakeSpanKey{}).(*fakeSpan); ok {
+					return span
+				}
+				return nil
+			},
+		})
+		log := slog.New(h).With("arg1", "with1", "arg1", "with2").WithGroup("group1")
+
+		// No span in the context
+		log.Info("main message", "arg2", "main1")
+
+		span := &fakeSpan{}
+		ctx := context.WithValue(context.Background(), fakeSpanKey{}, span)
+		log.InfoContext(ctx, "main message", "arg2", "main1", "arg2", "main2", slog.Group("group2", "arg3", "main3"), slog.Group("group2", "arg3", "main4"))
+		log.InfoContext(ctx, "main message", "arg3", "main3")
+
+		expected := fmt.Sprint([]string{
+			"slogdedup.collision [key=arg1]",
+			"slogdedup.collision [key=group1.arg2]",
+			"slogdedup.collision [key=group1.group2]",
+			"slogdedup.collision [key=arg1]",
+		})
+		if got := fmt.Sprint(span.events); got != expected {
+			t.Errorf("cache=%t Expected:\n%s\nGot:\n%s", cache, expected, got)
+		}
+	}
+}
+
+func TestDebugDuplicates(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	tester := &testHandler{}
+	logComplex(t, NewOverwriteHandler(tester, &OverwriteHandlerOptions{DebugDuplicates: buf}))
+
+	expected := `{"path":"/typed","kept":3,"dropped":"overwritten"}
+{"path":"/arg1","kept":"with2arg1","dropped":"with1arg1"}
+{"path":"/arg3","kept":"with2arg3","dropped":"with1arg3"}
+{"path":"/msg#01","kept":"with2msg","dropped":"prexisting01"}
+{"path":"/msg#01","kept":"with2msg2","dropped":"with2msg"}
+{"path":"/typed","kept":true,"dropped":3}
+{"path":"/level#01","kept":{"levelGroupKey":"levelGroupValue"},"dropped":"with2level"}
+{"path":"/level#01","kept":{"inlinedLevelGroupKey":"inlinedLevelGroupValue"},"dropped":{"levelGroupKey":"levelGroupValue"}}
+{"path":"/group1/arg1","kept":"group1with4arg1","dropped":"group1with3arg1"}
+{"path":"/group1/arg3","kept":"group1with4arg3","dropped":"group1with3arg3"}
+{"path":"/group1/overwrittenGroup","kept":"with4overwrittenGroup","dropped":{"arg":"arg"}}
+{"path":"/group1/arg1","kept":"main1arg1","dropped":"group1with4arg1"}
+{"path":"/group1/level","kept":"main1overwritten","dropped":"with4overwritten"}
+{"path":"/group1/level","kept":"main1level","dropped":"main1overwritten"}
+{"path":"/group1/main1group3/group3","kept":"group3arg0","dropped":"group3overwritten"}
+{"path":"/group1","kept":{"arg1":"main1arg1","arg2":"group1with3arg2","arg3":"group1with4arg3","arg4":"group1with4arg4","arg5":"with4inlinedGroupArg5","arg6":"main1arg6","level":"main1level","main1":"arg0","main1group3":{"group3":"group3arg0"},"msg":"with4msg","overwrittenGroup":"with4overwrittenGroup","separateGroup2":{"arg1":"group2arg1","arg2":"group2arg2","group2":"group2arg0"},"source":"with3source","time":"with3time","with3":"arg0","with4":"arg0"},"dropped":"with2group1"}`
+	if s := strings.TrimSpace(buf.String()); s != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, s)
+	}
+}
@@ -0,0 +1,80 @@
+package slogdedup
+
+import (
+	"log/slog"
+
+	"modernc.org/b/v2"
+)
+
+// DedupMode is the strategy used to deduplicate attributes and groups with
+// the same key. Each mode corresponds to one of the handlers in this package.
+type DedupMode int
+
+const (
+	// DedupDefault uses the default strategy, which is the handler's own when
+	// used for an option such as RootCollisionMode, and is DedupOverwrite for
+	// AppendToTree. This is the default.
+	DedupDefault DedupMode = iota
+
+	// DedupOverwrite overwrites older attributes with newer ones (see OverwriteHandler)
+	DedupOverwrite
+
+	// DedupIgnore ignores newer attributes, keeping the oldest (see IgnoreHandler)
+	DedupIgnore
+
+	// DedupIncrement renames newer attributes with an incrementing suffix (see IncrementHandler)
+	DedupIncrement
+
+	// DedupAppend appends all values together into a slice (see AppendHandler)
+	DedupAppend
+)
+
+// String returns the lower-case name of the mode
+func (m DedupMode) String() string {
+	switch m {
+	case DedupDefault:
+		return "default"
+	case DedupOverwrite:
+		return "overwrite"
+	case DedupIgnore:
+		return "ignore"
+	case DedupIncrement:
+		return "increment"
+	case DedupAppend:
+		return "append"
+	default:
+		return "unknown"
+	}
+}
+
+// AppendToTree resolves and deduplicates the attributes using the mode,
+// adding the results to the tree. This is the same core logic the handlers use,
+// exposed for those building their own pipelines.
+// The tree may be new or may be reused from a previous call, but it must have
+// been created with the same keyCompare function (which defaults to
+// CaseSensitiveCmp if nil).
+// Groups is the list of currently open groups that contain the attributes.
+// The default ResolveKey function for the handlers is used (IncrementIfBuiltinKeyConflict).
+//
+// The tree values are either slog.Attr's or subtrees, and should be converted
+// back into attributes with AttrsFromTree.
+func AppendToTree(uniq *b.Tree[string, any], attrs []slog.Attr, mode DedupMode, keyCompare func(a, b string) int, groups []string) {
+	state := &handleState{}
+	store := (*btreeStore)(uniq)
+	switch mode {
+	case DedupIgnore:
+		NewIgnoreHandler(nil, &IgnoreHandlerOptions{KeyCompare: keyCompare}).resolveValues(state, store, attrs, groups)
+	case DedupIncrement:
+		NewIncrementHandler(nil, &IncrementHandlerOptions{KeyCompare: keyCompare}).resolveValues(state, store, attrs, groups)
+	case DedupAppend:
+		NewAppendHandler(nil, &AppendHandlerOptions{KeyCompare: keyCompare}).resolveValues(state, store, attrs, groups)
+	default:
+		NewOverwriteHandler(nil, &OverwriteHandlerOptions{KeyCompare: keyCompare}).resolveValues(state, store, attrs, groups)
+	}
+}
+
+// AttrsFromTree converts a tree populated by AppendToTree back into a sorted
+// slice of attributes, with any subtrees converted into slog.Group's.
+func AttrsFromTree(uniq *b.Tree[string, any]) []slog.Attr {
+	return buildAttrs((*btreeStore)(uniq), nil, AppendedGroupMap)
+}
@@ -0,0 +1,45 @@
+package slogdedup
+
+import (
+	"log/slog"
+	"testing"
+
+	"modernc.org/b/v2"
+)
+
+func TestAppendToTree(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		mode     DedupMode
+		expected string
+	}{
+		{name: "overwrite", mode: DedupOverwrite, expected: `[arg1=second group1=[arg2=two] msg#01=third]`},
+		{name: "ignore", mode: DedupIgnore, expected: `[arg1=first group1=[arg2=one] msg#01=third]`},
+		{name: "increment", mode: DedupIncrement, expected: `[arg1=first arg1#01=second group1=[arg2=one arg2#01=two] msg#01=third]`},
+		{name: "append", mode: DedupAppend, expected: `[arg1=[first second] group1=[arg2=[one two]] msg#01=third]`},
+	}
+
+	for _, testCase := range tests {
+		uniq := b.TreeNew[string, any](CaseSensitiveCmp)
+
+		// Reuse the same tree across multiple calls
+		AppendToTree(uniq, []slog.Attr{slog.String("arg1", "first"), slog.Group("group1", "arg2", "one")}, testCase.mode, CaseSensitiveCmp, nil)
+		AppendToTree(uniq, []slog.Attr{slog.String("arg1", "second"), slog.String("msg", "third")}, testCase.mode, CaseSensitiveCmp, nil)
+
+		// Populate the subtree directly
+		if v, ok := uniq.Get("group1"); ok {
+			AppendToTree(v.(*b.Tree[string, any]), []slog.Attr{slog.String("arg2", "two")}, testCase.mode, CaseSensitiveCmp, []string{"group1"})
+		} else {
+			t.Errorf("%s: group1 subtree missing", testCase.name)
+		}
+
+		attrs := AttrsFromTree(uniq)
+		checkForDuplicates(t, attrs)
+
+		if got := slog.GroupValue(attrs...).String(); got != testCase.expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, testCase.expected, got)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package slogdedup
+
+import (
+	"log/slog"
+	"slices"
+)
+
+// uniqueScanner builds the final attributes of a record directly, without
+// creating a tree, when none of the keys at any level are duplicated. Most
+// records have no duplicates, and in that case every handler's output is the
+// same as if the tree had been built, but building it is much slower.
+// It is only used when no options are set that change the tree after it is
+// built, or that depend on it while it is built.
+type uniqueScanner struct {
+	resolveKey         func(groups []string, key string, index int) (string, bool)
+	keyCompare         func(a, b string) int
+	keyCompareForGroup func(groups []string) func(a, b string) int
+	keyOrder           func(a, b string) int
+	sorted             bool
+}
+
+// newUniqueScanner returns a uniqueScanner, or nil if it is not enabled.
+func newUniqueScanner(enabled bool, resolveKey func(groups []string, key string, index int) (string, bool), keyCompare func(a, b string) int, keyCompareForGroup func(groups []string) func(a, b string) int, keyOrder func(a, b string) int, orderMode OrderMode) *uniqueScanner {
+	if !enabled {
+		return nil
+	}
+	return &uniqueScanner{
+		resolveKey:         resolveKey,
+		keyCompare:         keyCompare,
+		keyCompareForGroup: keyCompareForGroup,
+		keyOrder:           keyOrder,
+		sorted:             orderMode == OrderSorted,
+	}
+}
+
+// attrs returns the final attributes for the groups and attributes, and true,
+// if no keys are duplicated. Otherwise it returns false, and the tree must be
+// built instead. Safe to call on a nil uniqueScanner.
+func (u *uniqueScanner) attrs(goas []*groupOrAttrs) ([]slog.Attr, bool) {
+	if u == nil {
+		return nil, false
+	}
+	return u.level(goas, nil)
+}
+
+// level returns the attributes for the level of the groups, which include all
+// groups opened with WithGroup after it.
+func (u *uniqueScanner) level(goas []*groupOrAttrs, groups []string) ([]slog.Attr, bool) {
+	var attrs []slog.Attr
+	ok := true
+	for i, goa := range goas {
+		if goa.group == "" {
+			if attrs, ok = u.values(attrs, goa.attrs, groups); !ok {
+				return nil, false
+			}
+			continue
+		}
+		key, keep := u.resolveKey(groups, goa.group, 0)
+		if !keep {
+			continue // Dropped groups leave the attributes after them at this level
+		}
+		if key == "" {
+			return nil, false // Let the tree handle groups resolved to empty keys
+		}
+		children, ok := u.level(goas[i+1:], append(slices.Clip(groups), key))
+		if !ok {
+			return nil, false
+		}
+		if len(children) > 0 {
+			attrs = append(attrs, slog.Attr{Key: key, Value: slog.GroupValue(children...)})
+		}
+		break
+	}
+	return attrs, u.unique(attrs, groups)
+}
+
+// values appends the resolved attributes to dst, with their keys resolved,
+// any inlined groups inlined, and any empty groups removed. It returns false
+// if any keys in a group attribute are duplicated.
+func (u *uniqueScanner) values(dst []slog.Attr, attrs []slog.Attr, groups []string) ([]slog.Attr, bool) {
+	for _, a := range attrs {
+		a.Value = a.Value.Resolve()
+		if a.Equal(slog.Attr{}) {
+			continue // Ignore empty attributes, and keep iterating
+		}
+
+		// Groups with empty keys are inlined, and have no key to resolve
+		if a.Key == "" && a.Value.Kind() == slog.KindGroup {
+			var ok bool
+			if dst, ok = u.values(dst, a.Value.Group(), groups); !ok {
+				return nil, false
+			}
+			continue
+		}
+
+		key, keep := u.resolveKey(groups, a.Key, 0)
+		if !keep {
+			continue
+		}
+		if a.Value.Kind() != slog.KindGroup {
+			dst = append(dst, slog.Attr{Key: key, Value: a.Value})
+			continue
+		}
+
+		// Groups whose keys were resolved to empty are also inlined
+		if key == "" {
+			var ok bool
+			if dst, ok = u.values(dst, a.Value.Group(), groups); !ok {
+				return nil, false
+			}
+			continue
+		}
+
+		groupPath := append(slices.Clip(groups), key)
+		children, ok := u.values(nil, a.Value.Group(), groupPath)
+		if !ok || !u.unique(children, groupPath) {
+			return nil, false
+		}
+		if len(children) > 0 {
+			dst = append(dst, slog.Attr{Key: key, Value: slog.GroupValue(children...)})
+		}
+	}
+	return dst, true
+}
+
+// unique returns true if none of the keys of the attributes are the same
+// according to the key comparison function of the group path. If sorting,
+// the attributes are also sorted.
+func (u *uniqueScanner) unique(attrs []slog.Attr, groups []string) bool {
+	if len(attrs) < 2 {
+		return true
+	}
+	keyCompare := u.keyCompare
+	if u.keyCompareForGroup != nil {
+		if kc := u.keyCompareForGroup(groups); kc != nil {
+			keyCompare = kc
+		}
+	}
+
+	cmp := func(a1, a2 slog.Attr) int { return keyCompare(a1.Key, a2.Key) }
+	sorted := attrs
+	if !u.sorted {
+		sorted = slices.Clone(attrs) // Keep the attributes in insertion order
+	}
+	slices.SortFunc(sorted, cmp)
+	for i := 1; i < len(sorted); i++ {
+		if cmp(sorted[i-1], sorted[i]) == 0 {
+			return false
+		}
+	}
+	if u.sorted && u.keyOrder != nil {
+		slices.SortStableFunc(attrs, func(a1, a2 slog.Attr) int { return u.keyOrder(a1.Key, a2.Key) })
+	}
+	return true
+}
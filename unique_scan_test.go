@@ -0,0 +1,299 @@
+package slogdedup
+
+import (
+	"context"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// shortCircuitTestHandlers returns constructors for each handler with a
+// variety of options, which create the handler with and without ShortCircuitUnique.
+func shortCircuitTestHandlers() map[string]func(next slog.Handler, short bool) slog.Handler {
+	dropKey := func(groups []string, key string, index int) (string, bool) {
+		if key == "drop" {
+			return "", false
+		}
+		return IncrementIfBuiltinKeyConflict(groups, key, index)
+	}
+	return map[string]func(next slog.Handler, short bool) slog.Handler{
+		"overwrite": func(next slog.Handler, short bool) slog.Handler {
+			return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{ShortCircuitUnique: short}})
+		},
+		"overwrite-insertion-cache": func(next slog.Handler, short bool) slog.Handler {
+			return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{ShortCircuitUnique: short, OrderMode: OrderInsertion, CacheWithAttrs: true}})
+		},
+		"overwrite-aliases": func(next slog.Handler, short bool) slog.Handler {
+			return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{ShortCircuitUnique: short}, KeyAliases: map[string]string{"alias": "arg1"}})
+		},
+		"ignore": func(next slog.Handler, short bool) slog.Handler {
+			return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{ShortCircuitUnique: short}, ResolveKey: dropKey})
+		},
+		"ignore-stable-case": func(next slog.Handler, short bool) slog.Handler {
+			return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{ShortCircuitUnique: short, OrderMode: OrderInsertionStableDedup}, KeyCompare: CaseInsensitiveCmp})
+		},
+		"increment": func(next slog.Handler, short bool) slog.Handler {
+			return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{ShortCircuitUnique: short}})
+		},
+		"increment-insertion-drop": func(next slog.Handler, short bool) slog.Handler {
+			return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{ShortCircuitUnique: short, OrderMode: OrderInsertion}, ResolveKey: dropKey})
+		},
+		"append": func(next slog.Handler, short bool) slog.Handler {
+			return NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{ShortCircuitUnique: short}})
+		},
+		"append-unify": func(next slog.Handler, short bool) slog.Handler {
+			return NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{ShortCircuitUnique: short, OrderMode: OrderInsertion, UnifyGroupSources: true}})
+		},
+	}
+}
+
+func TestShortCircuitUnique(t *testing.T) {
+	t.Parallel()
+
+	for name, handler := range shortCircuitTestHandlers() {
+		var expected, got []string
+		for _, short := range []bool{false, true} {
+			tester := &testHandler{}
+			log := slog.New(handler(tester, short)).With("b", "with1", "drop", "with1", slog.Group("", "a", "inline"), slog.Group("empty"))
+			log2 := log.WithGroup("group1").With("z", "with2", "drop", "with2")
+			log3 := log2.WithGroup("drop").WithGroup("group2")
+
+			var outputs []string
+			for _, l := range []*slog.Logger{log, log2, log3} {
+				// No duplicates
+				l.Info("main message", "c", "main", slog.Group("group3", "y", 1, "x", 2), "msg", "builtin", "time", "builtin")
+				outputs = append(outputs, tester.String())
+				l.Info("main message", "alias", "main", slog.Group("", slog.Group("group4", "B", 3)), "drop", "main")
+				outputs = append(outputs, tester.String())
+				l.Info("main message")
+				outputs = append(outputs, tester.String())
+
+				// Duplicates at the same level, at another level, and within a group attribute
+				l.Info("main message", "b", "main", "B", "main")
+				outputs = append(outputs, tester.String())
+				l.Info("main message", "arg1", "main", "alias", "main", slog.Group("group1", "z", "main"))
+				outputs = append(outputs, tester.String())
+				l.Info("main message", slog.Group("group3", "y", 1, "y", 2))
+				outputs = append(outputs, tester.String())
+			}
+
+			logComplex(t, handler(tester, short))
+			outputs = append(outputs, tester.String())
+
+			if short {
+				got = outputs
+			} else {
+				expected = outputs
+			}
+		}
+
+		for i := range expected {
+			if got[i] != expected[i] {
+				t.Errorf("%s %d Expected:\n%s\nGot:\n%s", name, i, expected[i], got[i])
+			}
+		}
+	}
+}
+
+func TestShortCircuitUnique_Skips(t *testing.T) {
+	t.Parallel()
+
+	h := NewOverwriteHandler(nil, &OverwriteHandlerOptions{CommonOptions: CommonOptions{ShortCircuitUnique: true}}).WithGroup("group1").(*OverwriteHandler)
+	if _, ok := h.unique.attrs(collectGroupOrAttrs(h.goa, &groupOrAttrs{attrs: []slog.Attr{slog.String("a", "1"), slog.String("b", "2")}})); !ok {
+		t.Error("Expected a record without duplicates to skip building the tree")
+	}
+	if _, ok := h.unique.attrs(collectGroupOrAttrs(h.goa, &groupOrAttrs{attrs: []slog.Attr{slog.String("a", "1"), slog.String("a", "2")}})); ok {
+		t.Error("Expected a record with duplicates to build the tree")
+	}
+
+	h = NewOverwriteHandler(nil, &OverwriteHandlerOptions{CommonOptions: CommonOptions{ShortCircuitUnique: true, SequenceKey: "seq"}})
+	if h.unique != nil {
+		t.Error("Expected options that need the tree to disable ShortCircuitUnique")
+	}
+}
+
+func BenchmarkShortCircuitUnique(b *testing.B) {
+	for _, short := range []bool{false, true} {
+		name := "tree"
+		if short {
+			name = "short-circuit"
+		}
+		b.Run(name, func(b *testing.B) {
+			log := slog.New(NewOverwriteHandler(slog.NewJSONHandler(io.Discard, nil), &OverwriteHandlerOptions{CommonOptions: CommonOptions{ShortCircuitUnique: short}}))
+			for i := 0; i < 10; i++ {
+				log = log.With(strings.Repeat("k", i+1), i)
+			}
+			log = log.WithGroup("request").With("id", "abc123", slog.Group("user", "id", 5, "name", "bob"))
+
+			ctx := context.Background()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				log.InfoContext(ctx, "main message", "arg1", i, "arg2", "val2")
+			}
+		})
+	}
+}
+
+// shortCircuitOptionValues holds a value that is not the default for every
+// option of every handler, by name. Every option must have one, so that
+// TestShortCircuitUnique_EachOption also checks any option added later.
+var shortCircuitOptionValues = map[string]any{
+	"KeyCompare":              CaseInsensitiveCmp,
+	"ResolveKey":              DropIfBuiltinKeyConflict,
+	"KeyCompareForGroup":      func(groups []string) func(a, b string) int { return CaseInsensitiveCmp },
+	"KeyOrder":                CaseInsensitiveCmp,
+	"HashOrder":               true,
+	"KeyMap":                  func(_ []string, key string) (string, bool) { return strings.TrimPrefix(key, "map_"), true },
+	"SanitizeKeysPrometheus":  true,
+	"ReservedOutputKeys":      []string{"arg1"},
+	"OrderMode":               OrderInsertion,
+	"TextOrder":               true,
+	"MaxGoaDepth":             1,
+	"UnifyGroupSources":       true,
+	"RootCollisionMode":       DedupAppend,
+	"InlineCollisionMode":     DedupIncrement,
+	"EnsureTime":              true,
+	"DropTime":                true,
+	"EnsureKeys":              []slog.Attr{slog.String("ensured", "default")},
+	"SortBuiltins":            true,
+	"BuiltinsLast":            true,
+	"SampleFunc":              func(r slog.Record) bool { return true },
+	"SampleBeforeDedup":       true,
+	"RequestIDFromContext":    func(ctx context.Context) string { return "request" },
+	"RequestIDKey":            "rid",
+	"SequenceKey":             "_seq",
+	"ModeTagKey":              "_mode",
+	"GroupDepthKey":           "_depth",
+	"FingerprintKey":          "_fingerprint",
+	"Hasher":                  fnv.New64,
+	"CacheWithAttrs":          true,
+	"MaxNodes":                3,
+	"DedupSliceValues":        true,
+	"SplitKeys":               map[string]string{"split": ","},
+	"Timer":                   func(d time.Duration) {},
+	"SizeObserver":            func(bytes int) {},
+	"AttrCountObserver":       func(level slog.Level, count int) {},
+	"ResolveMessage":          true,
+	"LargeValueThreshold":     20,
+	"LargeValueGroup":         "_large",
+	"CollapseSingletonGroups": true,
+	"CollapseSeparator":       "_",
+	"ShortCircuitUnique":      true,
+	"ParallelThreshold":       1,
+	"TypeDisambiguate":        true,
+	"BytesAs":                 BytesHex,
+	"TimeAttrLayout":          time.Kitchen,
+	"BoolAggregate":           BoolOr,
+	"WarnOnGroupLoss":         true,
+	"KeyAliases":              map[string]string{"alias": "arg1"},
+	"AllowKeys":               []string{"arg*", "group1", "a.b", "__proto__", "bytes", "when", "slice", "large", "split", "valuer", "msg"},
+	"DenyKeys":                []string{"arg2"},
+	"DenyKeysFromContext":     func(ctx context.Context) []string { return []string{"arg3"} },
+	"StrictBuiltins":          true,
+	"RecoverValuers":          true,
+	"TraceCollisions":         true,
+	"DebugDuplicates":         io.Discard,
+	"SpanFromContext":         func(ctx context.Context) CollisionSpan { return nil },
+	"LevelRouter":             func(level slog.Level) slog.Handler { return nil },
+	"CollectAllKey":           "#all",
+	"Prefer":                  func(existing, incoming slog.Value) slog.Value { return incoming },
+	"ReserveIncrementSuffix":  true,
+	"IncrementFirst":          true,
+	"IncrementStart":          5,
+	"AppendedGroupFormat":     AppendedGroupAttrs,
+	"JoinKeys":                map[string]string{"arg1": ","},
+	"SortAppendedGroupSlices": true,
+}
+
+// shortCircuitOptionCompanions lists the options that only have an effect
+// together with other options, which are set with them.
+var shortCircuitOptionCompanions = map[string][]string{
+	"LargeValueThreshold": {"LargeValueGroup"},
+	"LargeValueGroup":     {"LargeValueThreshold"},
+	"CollapseSeparator":   {"CollapseSingletonGroups"},
+	"Hasher":              {"FingerprintKey"},
+	"RequestIDKey":        {"RequestIDFromContext"},
+	"SampleBeforeDedup":   {"SampleFunc"},
+	"TraceCollisions":     {"SpanFromContext"},
+	"SpanFromContext":     {"TraceCollisions"},
+}
+
+func TestShortCircuitUnique_EachOption(t *testing.T) {
+	t.Parallel()
+
+	handlers := map[string]func(opts any, next slog.Handler) slog.Handler{
+		"overwrite": func(opts any, next slog.Handler) slog.Handler {
+			return NewOverwriteHandler(next, opts.(*OverwriteHandlerOptions))
+		},
+		"ignore": func(opts any, next slog.Handler) slog.Handler {
+			return NewIgnoreHandler(next, opts.(*IgnoreHandlerOptions))
+		},
+		"increment": func(opts any, next slog.Handler) slog.Handler {
+			return NewIncrementHandler(next, opts.(*IncrementHandlerOptions))
+		},
+		"append": func(opts any, next slog.Handler) slog.Handler {
+			return NewAppendHandler(next, opts.(*AppendHandlerOptions))
+		},
+	}
+	newOptions := map[string]func() any{
+		"overwrite": func() any { return &OverwriteHandlerOptions{} },
+		"ignore":    func() any { return &IgnoreHandlerOptions{} },
+		"increment": func() any { return &IncrementHandlerOptions{} },
+		"append":    func() any { return &AppendHandlerOptions{} },
+	}
+
+	// A record without duplicate keys, so that it can be short-circuited,
+	// with attributes that the options act on
+	when := time.Date(2023, 9, 29, 13, 0, 59, 0, time.UTC)
+	attrs := []slog.Attr{
+		slog.String("arg1", "a,b"), slog.String("arg2", "val2"), slog.String("arg3", "val3"), slog.Int("a.b", 1),
+		slog.String("__proto__", "proto"), slog.Any("bytes", []byte("abc")), slog.Time("when", when),
+		slog.Any("slice", []any{1, 1, 2}), slog.String("large", strings.Repeat("x", 30)), slog.String("split", "c,d"),
+		slog.Any("valuer", doubleValuer(2)), slog.String("map_key", "mapped"), slog.String("msg", "user"),
+		slog.Group("group1", slog.Bool("flag", true)), slog.String("ALIAS", "alias"),
+	}
+
+	for name, handler := range handlers {
+		for _, field := range reflect.VisibleFields(reflect.TypeOf(newOptions[name]()).Elem()) {
+			if field.Anonymous || field.Name == "ShortCircuitUnique" {
+				continue
+			}
+			value, ok := shortCircuitOptionValues[field.Name]
+			if !ok {
+				t.Errorf("%s option %s needs a value in shortCircuitOptionValues", name, field.Name)
+				continue
+			}
+
+			var outputs [2]string
+			for i, short := range []bool{false, true} {
+				opts := newOptions[name]()
+				optsValue := reflect.ValueOf(opts).Elem()
+				optsValue.FieldByName(field.Name).Set(reflect.ValueOf(value))
+				for _, companion := range shortCircuitOptionCompanions[field.Name] {
+					optsValue.FieldByName(companion).Set(reflect.ValueOf(shortCircuitOptionValues[companion]))
+				}
+				optsValue.FieldByName("ShortCircuitUnique").SetBool(short)
+
+				tester := &testHandler{}
+				h := handler(opts, tester).WithAttrs([]slog.Attr{slog.String("with1", "with")})
+				r := slog.NewRecord(when, slog.LevelInfo, "main {arg2}", 0)
+				r.AddAttrs(attrs...)
+				// Some options return an error, which must also be the same
+				if err := h.Handle(context.Background(), r); err != nil {
+					outputs[i] = err.Error()
+				} else {
+					outputs[i] = tester.String()
+				}
+			}
+
+			if outputs[0] != outputs[1] {
+				t.Errorf("%s option %s Expected:\n%s\nGot:\n%s", name, field.Name, outputs[0], outputs[1])
+			}
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package slogdedup
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// BytesFormat determines how attribute values that are byte slices ([]byte)
+// are rewritten before being passed to the next handler.
+type BytesFormat int
+
+const (
+	// BytesAsIs leaves byte slices alone, letting the final handler render them.
+	// This is the default.
+	BytesAsIs BytesFormat = iota
+
+	// BytesBase64 rewrites byte slices as standard base64 encoded strings.
+	BytesBase64
+
+	// BytesHex rewrites byte slices as lower-case hex encoded strings.
+	BytesHex
+
+	// BytesString rewrites byte slices as strings, as-is.
+	BytesString
+)
+
+// formatBytes rewrites the value as a string if it is a byte slice
+func formatBytes(v slog.Value, format BytesFormat) slog.Value {
+	if format == BytesAsIs || v.Kind() != slog.KindAny {
+		return v
+	}
+	bs, ok := v.Any().([]byte)
+	if !ok {
+		return v
+	}
+	switch format {
+	case BytesBase64:
+		return slog.StringValue(base64.StdEncoding.EncodeToString(bs))
+	case BytesHex:
+		return slog.StringValue(hex.EncodeToString(bs))
+	case BytesString:
+		return slog.StringValue(string(bs))
+	default:
+		return v
+	}
+}
+
+// formatTime rewrites the value as a string using the layout, if the layout is
+// not empty and the value is a time.
+func formatTime(v slog.Value, layout string) slog.Value {
+	if layout == "" || v.Kind() != slog.KindTime {
+		return v
+	}
+	return slog.StringValue(v.Time().Format(layout))
+}
+
+// dedupSliceValue returns the value with any repeated elements removed, if
+// enabled and it is a []string or []any. The original slice is not modified.
+func dedupSliceValue(v slog.Value, enabled bool) slog.Value {
+	if !enabled || v.Kind() != slog.KindAny {
+		return v
+	}
+	switch slice := v.Any().(type) {
+	case []string:
+		seen := make(map[string]struct{}, len(slice))
+		deduped := make([]string, 0, len(slice))
+		for _, s := range slice {
+			if _, ok := seen[s]; !ok {
+				seen[s] = struct{}{}
+				deduped = append(deduped, s)
+			}
+		}
+		return slog.AnyValue(deduped)
+	case []any:
+		seen := make(map[any]struct{}, len(slice))
+		deduped := make([]any, 0, len(slice))
+		for _, e := range slice {
+			if e != nil && !reflect.ValueOf(e).Comparable() {
+				deduped = append(deduped, e) // Can't be used as a map key
+				continue
+			}
+			if _, ok := seen[e]; !ok {
+				seen[e] = struct{}{}
+				deduped = append(deduped, e)
+			}
+		}
+		return slog.AnyValue(deduped)
+	default:
+		return v
+	}
+}
+
+// splitValue returns the value split by the separator into a []string, if the
+// separator is not empty and the value is a string.
+func splitValue(v slog.Value, sep string) slog.Value {
+	if sep == "" || v.Kind() != slog.KindString {
+		return v
+	}
+	return slog.AnyValue(strings.Split(v.String(), sep))
+}
+
+// BoolAggregate determines how the OverwriteHandler combines duplicate
+// attributes that both have boolean values.
+type BoolAggregate int
+
+const (
+	// BoolLast keeps the newest value, like any other duplicate attribute.
+	// This is the default.
+	BoolLast BoolAggregate = iota
+
+	// BoolOr combines the values with a logical OR, so the result is true if
+	// any of the values are true.
+	BoolOr
+
+	// BoolAnd combines the values with a logical AND, so the result is true
+	// only if all of the values are true.
+	BoolAnd
+)
+
+// aggregateBool returns the attribute with its value combined with the value
+// of the existing attribute with the same key, if both values are booleans.
+func aggregateBool(uniq attrStore, a slog.Attr, aggregate BoolAggregate) slog.Attr {
+	if aggregate == BoolLast || a.Value.Kind() != slog.KindBool {
+		return a
+	}
+	existing, ok := uniq.Get(a.Key)
+	if !ok {
+		return a
+	}
+	existingAttr, ok := existing.(slog.Attr)
+	if !ok || existingAttr.Value.Kind() != slog.KindBool {
+		return a
+	}
+	switch aggregate {
+	case BoolOr:
+		a.Value = slog.BoolValue(existingAttr.Value.Bool() || a.Value.Bool())
+	case BoolAnd:
+		a.Value = slog.BoolValue(existingAttr.Value.Bool() && a.Value.Bool())
+	}
+	return a
+}
+
+// maxLogValues is the maximum number of nested LogValuer's that will be
+// resolved, matching the limit used by slog.Value.Resolve.
+const maxLogValues = 100
+
+// resolveValue resolves the value like slog.Value.Resolve, except that if
+// recoverValuers is true, a panic in LogValue is recovered and the value is
+// replaced with a string describing the panic, without a stack trace.
+func resolveValue(v slog.Value, recoverValuers bool) (rv slog.Value) {
+	if !recoverValuers {
+		return v.Resolve()
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			rv = slog.StringValue(fmt.Sprintf("!PANIC: LogValue: %v", p))
+		}
+	}()
+	for i := 0; i < maxLogValues; i++ {
+		if v.Kind() != slog.KindLogValuer {
+			return v
+		}
+		v = v.LogValuer().LogValue()
+	}
+	return slog.AnyValue(fmt.Errorf("LogValue called too many times on Value of type %T", v.Any()))
+}
+
+// resolveParallel resolves the values of the attributes in place with
+// resolve, split between a goroutine per CPU, if there are more attributes
+// than the threshold and the threshold is positive. The attributes keep their
+// order, so resolving them again later is a cheap no-op. Values nested in
+// groups are left for the serial pass, so that the groups, which may be shared
+// with other records, are never modified.
+func resolveParallel(attrs []slog.Attr, threshold int, resolve func(v slog.Value) slog.Value) {
+	if threshold <= 0 || len(attrs) <= threshold {
+		return
+	}
+	workers := min(runtime.GOMAXPROCS(0), len(attrs))
+	size := (len(attrs) + workers - 1) / workers
+
+	var wg sync.WaitGroup
+	for start := 0; start < len(attrs); start += size {
+		chunk := attrs[start:min(start+size, len(attrs))]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range chunk {
+				chunk[i].Value = resolve(chunk[i].Value)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// AppendedGroupFormat determines how groups that have been appended into a
+// slice of values by the AppendHandler are rendered.
+type AppendedGroupFormat int
+
+const (
+	// AppendedGroupMap renders each group as a map[string]any, which most
+	// handlers can render, but which loses the order of the group's attributes.
+	// This is the default.
+	AppendedGroupMap AppendedGroupFormat = iota
+
+	// AppendedGroupAttrs renders each group as an AppendedGroup, which keeps
+	// the group's attributes in order, and which sinks can detect by type.
+	AppendedGroupAttrs
+)
+
+// AppendedGroup is a group of attributes that has been appended into a slice
+// of values, with any nested groups also being AppendedGroup's. Sinks that
+// can render groups may check for this type. It marshals into a JSON object,
+// keeping its attributes in order.
+type AppendedGroup []slog.Attr
+
+// MarshalJSON marshals the group into a JSON object, keeping its attributes in order.
+func (g AppendedGroup) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, a := range g {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(a.Key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+
+		var val []byte
+		if a.Value.Kind() == slog.KindGroup {
+			val, err = AppendedGroup(a.Value.Group()).MarshalJSON()
+		} else {
+			val, err = json.Marshal(a.Value.Any())
+		}
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// compareValues orders values for sorting slices: numbers first, by their
+// numeric value, then other values by their type and formatted string, and
+// then groups by their formatted string. Maps format with their keys sorted,
+// so the order is deterministic.
+func compareValues(a, b any) int {
+	if c := cmp.Compare(valueRank(a), valueRank(b)); c != 0 {
+		return c
+	}
+	switch valueRank(a) {
+	case 0:
+		af, _ := numericValue(a)
+		bf, _ := numericValue(b)
+		return cmp.Compare(af, bf)
+	case 1:
+		if c := cmp.Compare(fmt.Sprintf("%T", a), fmt.Sprintf("%T", b)); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// valueRank returns the position of the kind of value in the sort order.
+func valueRank(v any) int {
+	if _, ok := numericValue(v); ok {
+		return 0
+	}
+	switch v.(type) {
+	case map[string]any, AppendedGroup:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// numericValue returns the value as a float64, and true if it is a number.
+func numericValue(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,389 @@
+package slogdedup
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBytesAs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		format   BytesFormat
+		expected string
+	}{
+		{format: BytesAsIs, expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"bytes","bytes":"aGk9IQ==","group1":{"bytes":"aGk9IQ=="}}`},
+		{format: BytesBase64, expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"bytes","bytes":"aGk9IQ==","group1":{"bytes":"aGk9IQ=="}}`},
+		{format: BytesHex, expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"bytes","bytes":"68693d21","group1":{"bytes":"68693d21"}}`},
+		{format: BytesString, expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"bytes","bytes":"hi=!","group1":{"bytes":"hi=!"}}`},
+	}
+
+	tester := &testHandler{}
+	for _, testCase := range tests {
+		log := slog.New(NewOverwriteHandler(tester, &OverwriteHandlerOptions{BytesAs: testCase.format}))
+		log.Info("bytes", slog.Any("bytes", []byte("hi=!")), slog.Group("group1", slog.Any("bytes", []byte("hi=!"))))
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != testCase.expected {
+			t.Errorf("%d Expected:\n%s\nGot:\n%s", testCase.format, testCase.expected, jStr)
+		}
+	}
+}
+
+func TestTimeAttrLayout(t *testing.T) {
+	t.Parallel()
+
+	tester := &testHandler{}
+	log := slog.New(NewOverwriteHandler(tester, &OverwriteHandlerOptions{TimeAttrLayout: time.DateTime}))
+	at := time.Date(2023, 9, 29, 13, 30, 15, 123, time.UTC)
+	log.Info("times", "at", at, slog.Group("group1", slog.Time("at", at)), "arg1", "val1")
+
+	jBytes, err := tester.MarshalJSON()
+	if err != nil {
+		t.Errorf("Unable to marshal json: %v", err)
+	}
+	jStr := strings.TrimSpace(string(jBytes))
+
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"times","arg1":"val1","at":"2023-09-29 13:30:15","group1":{"at":"2023-09-29 13:30:15"}}`
+	if jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+
+	checkRecordForDuplicates(t, tester.Record)
+}
+
+type panicValuer struct{}
+
+func (panicValuer) LogValue() slog.Value {
+	panic("boom")
+}
+
+func TestRecoverValuers(t *testing.T) {
+	t.Parallel()
+
+	tester := &testHandler{}
+	log := slog.New(NewOverwriteHandler(tester, &OverwriteHandlerOptions{RecoverValuers: true}))
+	log.Info("valuer", "arg1", panicValuer{}, slog.Group("group1", "arg2", panicValuer{}))
+
+	jBytes, err := tester.MarshalJSON()
+	if err != nil {
+		t.Errorf("Unable to marshal json: %v", err)
+	}
+	jStr := strings.TrimSpace(string(jBytes))
+
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"valuer","arg1":"!PANIC: LogValue: boom","group1":{"arg2":"!PANIC: LogValue: boom"}}`
+	if jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+
+	checkRecordForDuplicates(t, tester.Record)
+}
+
+func TestDedupSliceValues(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		handler func(next slog.Handler) slog.Handler
+	}{
+		{
+			name: "overwrite",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{DedupSliceValues: true}})
+			},
+		},
+		{
+			name: "ignore",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{DedupSliceValues: true}})
+			},
+		},
+		{
+			name: "increment",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{DedupSliceValues: true}})
+			},
+		},
+		{
+			name: "append",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{DedupSliceValues: true}})
+			},
+		},
+	}
+
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"slices","any":[1,"a",1.5,[1],[1]],"group1":{"tags":["b","a"]},"tags":["a","b"]}`
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		tags := []string{"a", "a", "b", "a"}
+		log := slog.New(testCase.handler(tester)).With("tags", tags)
+		log.Info("slices",
+			slog.Any("any", []any{1, "a", 1, 1.5, "a", []int{1}, []int{1}}),
+			slog.Group("group1", slog.Any("tags", []string{"b", "a", "b"})),
+		)
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, expected, jStr)
+		}
+
+		// The original slice must not be modified
+		if strings.Join(tags, ",") != "a,a,b,a" {
+			t.Errorf("%s Original slice modified: %v", testCase.name, tags)
+		}
+
+		checkRecordForDuplicates(t, tester.Record)
+	}
+}
+
+func TestSplitKeys(t *testing.T) {
+	t.Parallel()
+
+	splitKeys := map[string]string{"tags": ",", "path": "/"}
+	tests := []struct {
+		name    string
+		handler func(next slog.Handler) slog.Handler
+	}{
+		{
+			name: "overwrite",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{SplitKeys: splitKeys}})
+			},
+		},
+		{
+			name: "ignore",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{SplitKeys: splitKeys}})
+			},
+		},
+		{
+			name: "increment",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{SplitKeys: splitKeys}})
+			},
+		},
+		{
+			name: "append",
+			handler: func(next slog.Handler) slog.Handler {
+				return NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{SplitKeys: splitKeys, DedupSliceValues: true}})
+			},
+		},
+	}
+
+	for _, testCase := range tests {
+		tester := &testHandler{}
+		log := slog.New(testCase.handler(tester)).With("tags", "a,b,c")
+		log.Info("split",
+			slog.Int("path", 5),
+			slog.Group("group1", slog.String("path", "/usr/bin"), slog.String("other", "a,b")),
+		)
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		// Only string values are split
+		expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"split","group1":{"other":"a,b","path":["","usr","bin"]},"path":5,"tags":["a","b","c"]}`
+		if jStr != expected {
+			t.Errorf("%s Expected:\n%s\nGot:\n%s", testCase.name, expected, jStr)
+		}
+	}
+}
+
+func TestBoolAggregate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		aggregate BoolAggregate
+		expected  string
+	}{
+		{aggregate: BoolLast, expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"bools","all_ok":false,"error_seen":false,"group1":{"error_seen":false},"mixed":true}`},
+		{aggregate: BoolOr, expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"bools","all_ok":true,"error_seen":true,"group1":{"error_seen":true},"mixed":true}`},
+		{aggregate: BoolAnd, expected: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"bools","all_ok":false,"error_seen":false,"group1":{"error_seen":false},"mixed":true}`},
+	}
+
+	tester := &testHandler{}
+	for _, testCase := range tests {
+		log := slog.New(NewOverwriteHandler(tester, &OverwriteHandlerOptions{BoolAggregate: testCase.aggregate})).With("error_seen", false, "all_ok", true, "mixed", "no")
+		log.Info("bools", "error_seen", true, "all_ok", false, "error_seen", false, "mixed", true,
+			slog.Group("group1", "error_seen", false, "error_seen", true, "error_seen", false))
+
+		jBytes, err := tester.MarshalJSON()
+		if err != nil {
+			t.Errorf("Unable to marshal json: %v", err)
+			continue
+		}
+		jStr := strings.TrimSpace(string(jBytes))
+
+		if jStr != testCase.expected {
+			t.Errorf("%d Expected:\n%s\nGot:\n%s", testCase.aggregate, testCase.expected, jStr)
+		}
+	}
+}
+
+type doubleValuer int
+
+func (v doubleValuer) LogValue() slog.Value {
+	return slog.IntValue(int(v) * 2)
+}
+
+// parallelTestHandlers returns constructors for each handler with the ParallelThreshold
+func parallelTestHandlers() map[string]func(next slog.Handler, threshold int) slog.Handler {
+	return map[string]func(next slog.Handler, threshold int) slog.Handler{
+		"overwrite": func(next slog.Handler, threshold int) slog.Handler {
+			return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{ParallelThreshold: threshold}, RecoverValuers: true})
+		},
+		"ignore": func(next slog.Handler, threshold int) slog.Handler {
+			return NewIgnoreHandler(next, &IgnoreHandlerOptions{CommonOptions: CommonOptions{ParallelThreshold: threshold}})
+		},
+		"increment": func(next slog.Handler, threshold int) slog.Handler {
+			return NewIncrementHandler(next, &IncrementHandlerOptions{CommonOptions: CommonOptions{ParallelThreshold: threshold}})
+		},
+		"append": func(next slog.Handler, threshold int) slog.Handler {
+			return NewAppendHandler(next, &AppendHandlerOptions{CommonOptions: CommonOptions{ParallelThreshold: threshold}})
+		},
+	}
+}
+
+// wideArgs returns the arguments for a record with many attributes, including
+// duplicates, valuers, and groups.
+func wideArgs(n int) []any {
+	args := make([]any, 0, n+3)
+	for i := 0; i < n; i++ {
+		args = append(args, slog.Any(fmt.Sprintf("arg%d", i%(n/3+1)), doubleValuer(i)))
+	}
+	return append(args, slog.Group("group1", "arg1", doubleValuer(1)), slog.Group("", "arg2", "inline"))
+}
+
+func TestParallelThreshold(t *testing.T) {
+	t.Parallel()
+
+	noTime := &slog.HandlerOptions{ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return a
+	}}
+	args := wideArgs(300)
+
+	for name, handler := range parallelTestHandlers() {
+		expected := &bytes.Buffer{}
+		slog.New(handler(slog.NewJSONHandler(expected, noTime), 0)).Info("main message", args...)
+
+		// Log concurrently, so that running with -race also checks the goroutines
+		bufs := make([]*bytes.Buffer, 4)
+		var wg sync.WaitGroup
+		for i := range bufs {
+			bufs[i] = &bytes.Buffer{}
+			log := slog.New(handler(slog.NewJSONHandler(bufs[i], noTime), 10))
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				log.Info("main message", args...)
+			}()
+		}
+		wg.Wait()
+
+		for i, buf := range bufs {
+			if buf.String() != expected.String() {
+				t.Errorf("%s %d Expected:\n%s\nGot:\n%s", name, i, expected.String(), buf.String())
+			}
+		}
+	}
+}
+
+func BenchmarkParallelThreshold(b *testing.B) {
+	args := wideArgs(500)
+	for _, threshold := range []int{0, 100} {
+		b.Run(fmt.Sprintf("threshold=%d", threshold), func(b *testing.B) {
+			log := slog.New(NewOverwriteHandler(slog.NewJSONHandler(io.Discard, nil), &OverwriteHandlerOptions{CommonOptions: CommonOptions{ParallelThreshold: threshold}}))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				log.Info("main message", args...)
+			}
+		})
+	}
+}
+
+// groupValuer is a LogValuer that resolves to a group holding more valuers,
+// nested to the depth.
+type groupValuer int
+
+func (v groupValuer) LogValue() slog.Value {
+	if v <= 0 {
+		return slog.AnyValue(doubleValuer(v))
+	}
+	return slog.GroupValue(slog.Any("inner", doubleValuer(v)), slog.Any("nested", v-1))
+}
+
+func TestParallelThreshold_NestedValuers(t *testing.T) {
+	t.Parallel()
+
+	noTime := &slog.HandlerOptions{ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return a
+	}}
+
+	// The groups are shared by every record, so that running with -race also
+	// checks that they are never modified
+	args := make([]any, 0, 80)
+	for i := 0; i < 20; i++ {
+		args = append(args, slog.Any(fmt.Sprintf("valuer%d", i%7), groupValuer(i%4)),
+			slog.Group(fmt.Sprintf("group%d", i%5), "arg1", doubleValuer(i), "arg2", groupValuer(2)),
+			slog.Any("bytes", []byte("abc")), slog.Time("at", time.Date(2023, 9, 29, 13, 0, 59, 0, time.UTC)))
+	}
+
+	handlers := parallelTestHandlers()
+	handlers["overwrite-conversions"] = func(next slog.Handler, threshold int) slog.Handler {
+		return NewOverwriteHandler(next, &OverwriteHandlerOptions{CommonOptions: CommonOptions{ParallelThreshold: threshold}, BytesAs: BytesHex, TimeAttrLayout: time.Kitchen})
+	}
+
+	for name, handler := range handlers {
+		expected := &bytes.Buffer{}
+		slog.New(handler(slog.NewJSONHandler(expected, noTime), 0)).Info("main message", args...)
+
+		bufs := make([]*bytes.Buffer, 4)
+		var wg sync.WaitGroup
+		for i := range bufs {
+			bufs[i] = &bytes.Buffer{}
+			log := slog.New(handler(slog.NewJSONHandler(bufs[i], noTime), 10))
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				log.Info("main message", args...)
+			}()
+		}
+		wg.Wait()
+
+		for i, buf := range bufs {
+			if buf.String() != expected.String() {
+				t.Errorf("%s %d Expected:\n%s\nGot:\n%s", name, i, expected.String(), buf.String())
+			}
+		}
+	}
+}
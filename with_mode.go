@@ -0,0 +1,89 @@
+package slogdedup
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// handlerConfig holds the configuration and accumulated groups and
+// attributes that all four deduplicating handlers have in common. Each handler
+// embeds it, so that a handler can be converted into one with a different
+// deduplication mode without copying its fields one by one. The
+// IncrementHandler ignores typeDisambiguate, because incrementing the key
+// already keeps values of different types apart.
+type handlerConfig struct {
+	next               slog.Handler
+	goa                *groupOrAttrs
+	keyCompare         func(a, b string) int
+	keyCompareForGroup func(groups []string) func(a, b string) int
+	keyOrder           func(a, b string) int
+	resolveKey         func(groups []string, key string, index int) (string, bool)
+	orderMode          OrderMode
+	depthGuard         *goaDepthGuard
+	unifyGroups        bool
+	typeDisambiguate   bool
+	rootCollider       *collider
+	inlineCollider     *collider
+	ensureTime         bool
+	dropTime           bool
+	ensureKeys         []slog.Attr
+	sortBuiltins       bool
+	builtinsLast       bool
+	sample             func(r slog.Record) bool
+	sampleBeforeDedup  bool
+	requestID          func(ctx context.Context) string
+	requestIDKey       string
+	sequence           *sequencer
+	modeTagKey         string
+	groupDepthKey      string
+	fingerprint        *fingerprinter
+	newStore           func(keyCompare func(a, b string) int) attrStore
+	maxNodes           int
+	dedupSlices        bool
+	splitKeys          map[string]string
+	timer              func(d time.Duration)
+	sizeObserver       func(bytes int)
+	attrCountObserver  func(level slog.Level, count int)
+	resolveMessage     bool
+	largeValues        *largeValueMover
+	collapseSeparator  string
+	unique             *uniqueScanner
+	parallelThreshold  int
+}
+
+// canShortCircuit returns true if none of the shared options need the tree to
+// be built, so that records without duplicate keys can skip building it with
+// ShortCircuitUnique. Each handler also checks its own options.
+func (c handlerConfig) canShortCircuit() bool {
+	return c.rootCollider == nil && c.inlineCollider == nil && len(c.ensureKeys) == 0 && c.sequence == nil &&
+		c.modeTagKey == "" && c.groupDepthKey == "" && c.fingerprint == nil && c.maxNodes <= 0 && !c.dedupSlices &&
+		len(c.splitKeys) == 0 && c.sizeObserver == nil && !c.resolveMessage && c.largeValues == nil &&
+		c.collapseSeparator == "" && !c.sortBuiltins && !c.builtinsLast
+}
+
+// newHandlerWithMode returns a new handler of the mode, created from the
+// config, with its own depth guard and its own With* cache if cached is true.
+// Options that are specific to the handler of the mode are left at their
+// defaults. It panics if the mode is not one of the four handler modes,
+// instead of returning a nil handler that would only fail later when used.
+func newHandlerWithMode(c handlerConfig, cached bool, mode DedupMode) slog.Handler {
+	c.depthGuard = c.depthGuard.derive()
+	switch mode {
+	case DedupOverwrite:
+		return &OverwriteHandler{handlerConfig: c, cache: newGoaCache(cached)}
+	case DedupIgnore:
+		return &IgnoreHandler{handlerConfig: c, cache: newGoaCache(cached)}
+	case DedupIncrement:
+		return &IncrementHandler{
+			handlerConfig:       c,
+			resolveIncrementKey: resolveIncrementKeyClosure(c.resolveKey, nil, false, false, 1),
+			cache:               newGoaCache(cached),
+		}
+	case DedupAppend:
+		return &AppendHandler{handlerConfig: c, cache: newGoaCache(cached)}
+	default:
+		panic(fmt.Sprintf("slogdedup: WithMode called with an unknown mode: %d", int(mode)))
+	}
+}
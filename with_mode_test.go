@@ -0,0 +1,91 @@
+package slogdedup
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestWithMode(t *testing.T) {
+	t.Parallel()
+
+	tester := &testHandler{}
+	h := NewIncrementHandler(tester, &IncrementHandlerOptions{CommonOptions: CommonOptions{SequenceKey: "_seq"}})
+	log := slog.New(h).With("arg1", "with1", "arg1", "with2").WithGroup("group1").With("arg2", "with1")
+
+	log.Info("main message", "arg2", "main1")
+	expected := `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","_seq":1,"arg1":"with1","arg1#01":"with2","group1":{"arg2":"with1","arg2#01":"main1"}}`
+	checkWithMode(t, tester, expected)
+
+	// Convert to an append handler, keeping the With attributes and shared options
+	appendLog := slog.New(log.Handler().(*IncrementHandler).WithMode(DedupAppend))
+	appendLog.Info("main message", "arg2", "main1")
+	expected = `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","_seq":2,"arg1":["with1","with2"],"group1":{"arg2":["with1","main1"]}}`
+	checkWithMode(t, tester, expected)
+
+	// The original handler is unchanged
+	log.Info("main message", "arg2", "main1")
+	expected = `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","_seq":3,"arg1":"with1","arg1#01":"with2","group1":{"arg2":"with1","arg2#01":"main1"}}`
+	checkWithMode(t, tester, expected)
+
+	if h.WithMode(DedupIncrement) != slog.Handler(h) {
+		t.Error("Expected the same handler when converting to its own mode")
+	}
+	if _, ok := NewAppendHandler(tester, nil).WithMode(DedupOverwrite).(*OverwriteHandler); !ok {
+		t.Error("Expected an OverwriteHandler")
+	}
+}
+
+func checkWithMode(t *testing.T, tester *testHandler, expected string) {
+	t.Helper()
+	jBytes, err := tester.MarshalJSON()
+	if err != nil {
+		t.Errorf("Unable to marshal json: %v", err)
+		return
+	}
+	if jStr := strings.TrimSpace(string(jBytes)); jStr != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, jStr)
+	}
+}
+
+func TestWithMode_KeepsOptions(t *testing.T) {
+	t.Parallel()
+
+	// TypeDisambiguate and ModeTagKey must survive the conversion to every mode.
+	// The IncrementHandler has no need for TypeDisambiguate.
+	tests := map[DedupMode]string{
+		DedupOverwrite: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","_mode":"overwrite","arg1":"with1","arg1#group":{"arg2":"main1"}}`,
+		DedupIgnore:    `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","_mode":"ignore","arg1":"with1","arg1#group":{"arg2":"main1"}}`,
+		DedupIncrement: `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","_mode":"increment","arg1":"with1","arg1#01":{"arg2":"main1"}}`,
+		DedupAppend:    `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","_mode":"append","arg1":"with1","arg1#group":{"arg2":"main1"}}`,
+	}
+
+	for mode, expected := range tests {
+		tester := &testHandler{}
+		h := NewOverwriteHandler(tester, &OverwriteHandlerOptions{TypeDisambiguate: true, CommonOptions: CommonOptions{ModeTagKey: "_mode"}})
+		log := slog.New(h.WithMode(mode)).With("arg1", "with1")
+		log.Info("main message", slog.Group("arg1", "arg2", "main1"))
+		checkWithMode(t, tester, expected)
+	}
+}
+
+func TestWithMode_KeyAliases(t *testing.T) {
+	t.Parallel()
+
+	// KeyAliases is applied when resolving keys, so it carries over
+	tester := &testHandler{}
+	h := NewOverwriteHandler(tester, &OverwriteHandlerOptions{KeyAliases: map[string]string{"alias": "arg1"}})
+	slog.New(h.WithMode(DedupAppend)).Info("main message", "arg1", "main1", "alias", "main2")
+	checkWithMode(t, tester, `{"time":"2023-09-29T13:00:59Z","level":"INFO","msg":"main message","arg1":["main1","main2"]}`)
+}
+
+func TestWithMode_UnknownMode(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("Expected a panic for an unknown mode")
+		}
+	}()
+	NewOverwriteHandler(nil, nil).WithMode(DedupMode(99))
+}